@@ -26,6 +26,7 @@ func main() {
 			runCreateRotationKey,
 			runCreatePrivateJwk,
 			runCreateInviteCode,
+			runDisableInviteCode,
 			runResetPassword,
 		},
 		ErrWriter: os.Stdout,
@@ -134,7 +135,7 @@ var runCreateInviteCode = &cli.Command{
 
 		code := fmt.Sprintf("%s-%s", helpers.RandomVarchar(8), helpers.RandomVarchar(8))
 
-		if err := db.Exec("INSERT INTO invite_codes (did, code, remaining_use_count) VALUES (?, ?, ?)", forDid, code, uses).Error; err != nil {
+		if err := db.Exec("INSERT INTO invite_codes (did, code, remaining_use_count, created_by, created_at) VALUES (?, ?, ?, ?, ?)", forDid, code, uses, "admin", time.Now()).Error; err != nil {
 			return err
 		}
 
@@ -144,6 +145,37 @@ var runCreateInviteCode = &cli.Command{
 	},
 }
 
+var runDisableInviteCode = &cli.Command{
+	Name:  "disable-invite-code",
+	Usage: "disables an invite code",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "code",
+			Required: true,
+			Usage:    "the invite code to disable",
+		},
+	},
+	Action: func(cmd *cli.Context) error {
+		db, err := newDb()
+		if err != nil {
+			return err
+		}
+
+		res := db.Exec("UPDATE invite_codes SET disabled = true WHERE code = ?", cmd.String("code"))
+		if res.Error != nil {
+			return res.Error
+		}
+
+		if res.RowsAffected == 0 {
+			return fmt.Errorf("invite code %s not found", cmd.String("code"))
+		}
+
+		fmt.Printf("Invite code %s has been disabled\n", cmd.String("code"))
+
+		return nil
+	},
+}
+
 var runResetPassword = &cli.Command{
 	Name:  "reset-password",
 	Usage: "resets a password",
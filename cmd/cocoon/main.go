@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/haileyok/cocoon/server"
 	_ "github.com/joho/godotenv/autoload"
@@ -36,6 +37,26 @@ func main() {
 				Required: true,
 				EnvVars:  []string{"COCOON_HOSTNAME"},
 			},
+			&cli.StringSliceFlag{
+				Name:    "user-domains",
+				Usage:   "domains that users can create handles under. defaults to your hostname",
+				EnvVars: []string{"COCOON_USER_DOMAINS"},
+			},
+			&cli.StringFlag{
+				Name:    "dns-addr",
+				Usage:   "if set, serve dns for your user domains on this address (i.e. :53)",
+				EnvVars: []string{"COCOON_DNS_ADDR"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "dns-addresses",
+				Usage:   "the ip addresses that names in your user domains resolve to when serving dns. defaults to the addresses of your hostname",
+				EnvVars: []string{"COCOON_DNS_ADDRESSES"},
+			},
+			&cli.StringFlag{
+				Name:    "metrics-addr",
+				Usage:   "if set, serve prometheus metrics on this address (i.e. :9090)",
+				EnvVars: []string{"COCOON_METRICS_ADDR"},
+			},
 			&cli.StringFlag{
 				Name:     "rotation-key-path",
 				Required: true,
@@ -56,11 +77,77 @@ func main() {
 				Required: true,
 				EnvVars:  []string{"COCOON_RELAYS"},
 			},
+			&cli.StringSliceFlag{
+				Name:    "moderation-dids",
+				Usage:   "dids of moderation services that may call admin endpoints and see taken down content with service auth",
+				EnvVars: []string{"COCOON_MODERATION_DIDS"},
+			},
+			&cli.StringFlag{
+				Name:    "default-appview",
+				Usage:   "the service that proxied requests go to when no route matches and the client doesn't ask for one",
+				Value:   "did:web:api.bsky.app#bsky_appview",
+				EnvVars: []string{"COCOON_DEFAULT_APPVIEW"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "proxy-routes",
+				Usage:   "services to proxy methods to by nsid prefix, i.e. chat.bsky.=did:web:api.bsky.chat#bsky_chat",
+				Value:   cli.NewStringSlice("chat.bsky.=did:web:api.bsky.chat#bsky_chat"),
+				EnvVars: []string{"COCOON_PROXY_ROUTES"},
+			},
+			&cli.StringFlag{
+				Name:    "local-appview",
+				Usage:   "one of off, fallback or only. serves a few app.bsky methods from local records instead of the appview",
+				Value:   "off",
+				EnvVars: []string{"COCOON_LOCAL_APPVIEW"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "trusted-proxies",
+				Usage:   "ips or cidrs of proxies in front of cocoon whose X-Forwarded-For header can be trusted. loopback and private addresses are always trusted",
+				EnvVars: []string{"COCOON_TRUSTED_PROXIES"},
+			},
+			&cli.BoolFlag{
+				Name:    "disable-rate-limits",
+				Usage:   "turn off rate limiting on the login and account endpoints",
+				EnvVars: []string{"COCOON_DISABLE_RATE_LIMITS"},
+			},
+			&cli.BoolFlag{
+				Name:    "oauth-allow-insecure-clients",
+				Usage:   "accept oauth clients with plain http client ids and redirect uris. only for developing clients locally",
+				EnvVars: []string{"COCOON_OAUTH_ALLOW_INSECURE_CLIENTS"},
+			},
 			&cli.StringFlag{
 				Name:     "admin-password",
 				Required: true,
 				EnvVars:  []string{"COCOON_ADMIN_PASSWORD"},
 			},
+			&cli.StringFlag{
+				Name:    "registration-policy",
+				Usage:   "one of open, invite, waitlist or closed",
+				Value:   "invite",
+				EnvVars: []string{"COCOON_REGISTRATION_POLICY"},
+			},
+			&cli.DurationFlag{
+				Name:    "invite-interval",
+				Usage:   "if set, accounts earn a single-use invite code every interval, up to 5 unused ones at a time",
+				EnvVars: []string{"COCOON_INVITE_INTERVAL"},
+			},
+			&cli.DurationFlag{
+				Name:    "invite-code-expiry",
+				Usage:   "if set, invite codes expire this long after they are created",
+				EnvVars: []string{"COCOON_INVITE_CODE_EXPIRY"},
+			},
+			&cli.DurationFlag{
+				Name:    "handle-change-cooldown",
+				Usage:   "how long a user has to wait between handle changes",
+				Value:   5 * time.Minute,
+				EnvVars: []string{"COCOON_HANDLE_CHANGE_COOLDOWN"},
+			},
+			&cli.IntFlag{
+				Name:    "handle-change-limit",
+				Usage:   "how many times a user may change their handle in a day. 0 for no limit",
+				Value:   10,
+				EnvVars: []string{"COCOON_HANDLE_CHANGE_LIMIT"},
+			},
 			&cli.StringFlag{
 				Name:     "smtp-user",
 				Required: false,
@@ -110,22 +197,38 @@ var run = &cli.Command{
 	Flags: []cli.Flag{},
 	Action: func(cmd *cli.Context) error {
 		s, err := server.New(&server.Args{
-			Addr:            cmd.String("addr"),
-			DbName:          cmd.String("db-name"),
-			Did:             cmd.String("did"),
-			Hostname:        cmd.String("hostname"),
-			RotationKeyPath: cmd.String("rotation-key-path"),
-			JwkPath:         cmd.String("jwk-path"),
-			ContactEmail:    cmd.String("contact-email"),
-			Version:         Version,
-			Relays:          cmd.StringSlice("relays"),
-			AdminPassword:   cmd.String("admin-password"),
-			SmtpUser:        cmd.String("smtp-user"),
-			SmtpPass:        cmd.String("smtp-pass"),
-			SmtpHost:        cmd.String("smtp-host"),
-			SmtpPort:        cmd.String("smtp-port"),
-			SmtpEmail:       cmd.String("smtp-email"),
-			SmtpName:        cmd.String("smtp-name"),
+			Addr:                      cmd.String("addr"),
+			DbName:                    cmd.String("db-name"),
+			Did:                       cmd.String("did"),
+			Hostname:                  cmd.String("hostname"),
+			UserDomains:               cmd.StringSlice("user-domains"),
+			DnsAddr:                   cmd.String("dns-addr"),
+			DnsAddresses:              cmd.StringSlice("dns-addresses"),
+			MetricsAddr:               cmd.String("metrics-addr"),
+			RotationKeyPath:           cmd.String("rotation-key-path"),
+			JwkPath:                   cmd.String("jwk-path"),
+			ContactEmail:              cmd.String("contact-email"),
+			Version:                   Version,
+			Relays:                    cmd.StringSlice("relays"),
+			ModerationDids:            cmd.StringSlice("moderation-dids"),
+			DefaultAppview:            cmd.String("default-appview"),
+			ProxyRoutes:               cmd.StringSlice("proxy-routes"),
+			LocalAppview:              cmd.String("local-appview"),
+			OAuthAllowInsecureClients: cmd.Bool("oauth-allow-insecure-clients"),
+			AdminPassword:             cmd.String("admin-password"),
+			RegistrationPolicy:        cmd.String("registration-policy"),
+			InviteInterval:            cmd.Duration("invite-interval"),
+			InviteCodeExpiry:          cmd.Duration("invite-code-expiry"),
+			HandleChangeCooldown:      cmd.Duration("handle-change-cooldown"),
+			HandleChangeLimit:         cmd.Int("handle-change-limit"),
+			TrustedProxies:            cmd.StringSlice("trusted-proxies"),
+			DisableRateLimits:         cmd.Bool("disable-rate-limits"),
+			SmtpUser:                  cmd.String("smtp-user"),
+			SmtpPass:                  cmd.String("smtp-pass"),
+			SmtpHost:                  cmd.String("smtp-host"),
+			SmtpPort:                  cmd.String("smtp-port"),
+			SmtpEmail:                 cmd.String("smtp-email"),
+			SmtpName:                  cmd.String("smtp-name"),
 		})
 		if err != nil {
 			fmt.Printf("error creating cocoon: %v", err)
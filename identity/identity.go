@@ -9,71 +9,87 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/bluesky-social/indigo/atproto/crypto"
 	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/bluesky-social/indigo/util"
 )
 
 func ResolveHandle(ctx context.Context, cli *http.Client, handle string) (string, error) {
-	if cli == nil {
-		cli = util.RobustHTTPClient()
-	}
-
-	var did string
-
 	_, err := syntax.ParseHandle(handle)
 	if err != nil {
 		return "", err
 	}
 
-	recs, err := net.LookupTXT(fmt.Sprintf("_atproto.%s", handle))
-	if err == nil {
-		for _, rec := range recs {
-			if strings.HasPrefix(rec, "did=") {
-				did = strings.Split(rec, "did=")[1]
-				break
-			}
-		}
-	} else {
+	did, err := ResolveHandleDNS(ctx, handle)
+	if err != nil {
 		fmt.Printf("erorr getting txt records: %v\n", err)
 	}
 
 	if did == "" {
-		req, err := http.NewRequestWithContext(
-			ctx,
-			"GET",
-			fmt.Sprintf("https://%s/.well-known/atproto-did", handle),
-			nil,
-		)
+		did, err = ResolveHandleWellKnown(ctx, cli, handle)
 		if err != nil {
-			return "", nil
+			return "", err
 		}
+	}
 
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return "", nil
-		}
-		defer resp.Body.Close()
+	return did, nil
+}
 
-		if resp.StatusCode != http.StatusOK {
-			io.Copy(io.Discard, resp.Body)
-			return "", fmt.Errorf("unable to resolve handle")
-		}
+// looks up the did in the handle's _atproto TXT record. returns an empty did without an error if
+// the record exists but has no did in it
+func ResolveHandleDNS(ctx context.Context, handle string) (string, error) {
+	recs, err := net.DefaultResolver.LookupTXT(ctx, fmt.Sprintf("_atproto.%s", handle))
+	if err != nil {
+		return "", err
+	}
 
-		b, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return "", err
+	for _, rec := range recs {
+		if strings.HasPrefix(rec, "did=") {
+			return strings.Split(rec, "did=")[1], nil
 		}
+	}
 
-		maybeDid := string(b)
+	return "", nil
+}
 
-		if _, err := syntax.ParseDID(maybeDid); err != nil {
-			return "", fmt.Errorf("unable to resolve handle")
-		}
+func ResolveHandleWellKnown(ctx context.Context, cli *http.Client, handle string) (string, error) {
+	if cli == nil {
+		cli = util.RobustHTTPClient()
+	}
 
-		did = maybeDid
+	req, err := http.NewRequestWithContext(
+		ctx,
+		"GET",
+		fmt.Sprintf("https://%s/.well-known/atproto-did", handle),
+		nil,
+	)
+	if err != nil {
+		return "", err
 	}
 
-	return did, nil
+	resp, err := cli.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return "", fmt.Errorf("received status %d", resp.StatusCode)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return "", err
+	}
+
+	maybeDid := strings.TrimSpace(string(b))
+
+	if _, err := syntax.ParseDID(maybeDid); err != nil {
+		return "", fmt.Errorf("response was not a valid did")
+	}
+
+	return maybeDid, nil
 }
 
 func FetchDidDoc(ctx context.Context, cli *http.Client, did string) (*DidDoc, error) {
@@ -201,3 +217,22 @@ func ResolveService(ctx context.Context, cli *http.Client, did string) (string,
 
 	return service, nil
 }
+
+func (d *DidDoc) PdsEndpoint() string {
+	for _, svc := range d.Service {
+		if svc.Id == "#atproto_pds" || svc.Id == d.Id+"#atproto_pds" {
+			return svc.ServiceEndpoint
+		}
+	}
+	return ""
+}
+
+// the key that the did's repo commits and service auth tokens are signed with
+func (d *DidDoc) AtprotoSigningKey() (crypto.PublicKey, error) {
+	for _, vm := range d.VerificationMethods {
+		if vm.Id == "#atproto" || vm.Id == d.Id+"#atproto" {
+			return crypto.ParsePublicMultibase(vm.PublicKeyMultibase)
+		}
+	}
+	return nil, fmt.Errorf("could not find atproto verification method in did document")
+}
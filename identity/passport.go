@@ -84,3 +84,57 @@ func (p *Passport) BustDoc(ctx context.Context, did string) error {
 func (p *Passport) BustDid(ctx context.Context, handle string) error {
 	return p.bc.BustDid(handle)
 }
+
+type HandleVerification struct {
+	Handle       string
+	Did          string
+	DnsDid       string
+	DnsErr       error
+	WellKnownDid string
+	WellKnownErr error
+}
+
+func (hv *HandleVerification) Verified() bool {
+	return hv.DnsDid == hv.Did || hv.WellKnownDid == hv.Did
+}
+
+// describes what each resolution method found, for showing to a user whose handle didn't verify
+func (hv *HandleVerification) Summary() string {
+	describe := func(found string, err error) string {
+		switch {
+		case err != nil:
+			return "lookup failed (" + err.Error() + ")"
+		case found == "":
+			return "no did found"
+		case found != hv.Did:
+			return "found " + found
+		default:
+			return "ok"
+		}
+	}
+
+	return "DNS TXT record at _atproto." + hv.Handle + ": " + describe(hv.DnsDid, hv.DnsErr) +
+		"; HTTPS at https://" + hv.Handle + "/.well-known/atproto-did: " + describe(hv.WellKnownDid, hv.WellKnownErr)
+}
+
+// resolves the handle with both dns and well-known, bypassing the cache, and reports whether either
+// of them points at the given did. the cache is updated with whatever the handle resolved to
+func (p *Passport) VerifyHandle(ctx context.Context, handle string, did string) *HandleVerification {
+	hv := &HandleVerification{
+		Handle: handle,
+		Did:    did,
+	}
+
+	hv.DnsDid, hv.DnsErr = ResolveHandleDNS(ctx, handle)
+	if hv.DnsDid != did {
+		hv.WellKnownDid, hv.WellKnownErr = ResolveHandleWellKnown(ctx, p.h, handle)
+	}
+
+	if hv.Verified() {
+		p.bc.PutDid(handle, did)
+	} else {
+		p.bc.BustDid(handle)
+	}
+
+	return hv
+}
@@ -18,6 +18,28 @@ func InputError(e echo.Context, custom *string) error {
 	return genericError(e, 400, msg)
 }
 
+// like InputError, but also includes a human readable message for the client to display
+func InputErrorWithMessage(e echo.Context, name string, message string) error {
+	return e.JSON(400, map[string]string{
+		"error":   name,
+		"message": message,
+	})
+}
+
+func RateLimitError(e echo.Context, message string) error {
+	return e.JSON(429, map[string]string{
+		"error":   "RateLimitExceeded",
+		"message": message,
+	})
+}
+
+func UpstreamError(e echo.Context, message string) error {
+	return e.JSON(502, map[string]string{
+		"error":   "UpstreamFailure",
+		"message": message,
+	})
+}
+
 func ServerError(e echo.Context, suffix *string) error {
 	msg := "Internal server error"
 	if suffix != nil {
@@ -18,11 +18,42 @@ type Repo struct {
 	EmailUpdateCodeExpiresAt       *time.Time
 	PasswordResetCode              *string
 	PasswordResetCodeExpiresAt     *time.Time
+	AccountDeleteCode              *string
+	AccountDeleteCodeExpiresAt     *time.Time
+	PlcOperationCode               *string
+	PlcOperationCodeExpiresAt      *time.Time
+	EmailAuthFactor                bool
+	AuthFactorCode                 *string
+	AuthFactorCodeExpiresAt        *time.Time
+	TotpSecret                     []byte
+	TotpEnabledAt                  *time.Time
+	TotpLastStep                   int64
 	Password                       string
 	SigningKey                     []byte
 	Rev                            string
 	Root                           []byte
 	Preferences                    []byte
+	DeactivatedAt                  *time.Time
+	TakedownRef                    *string
+	InvitesDisabled                bool
+	InviteNote                     *string
+}
+
+func (r *Repo) Active() bool {
+	return r.Status() == nil
+}
+
+func (r *Repo) Status() *string {
+	var status string
+	switch {
+	case r.TakedownRef != nil:
+		status = "takendown"
+	case r.DeactivatedAt != nil:
+		status = "deactivated"
+	default:
+		return nil
+	}
+	return &status
 }
 
 func (r *Repo) SignFor(ctx context.Context, did string, msg []byte) ([]byte, error) {
@@ -53,30 +84,147 @@ type InviteCode struct {
 	Code              string `gorm:"primaryKey"`
 	Did               string `gorm:"index"`
 	RemainingUseCount int
+	Disabled          bool
+	CreatedBy         string
+	CreatedAt         time.Time
+	ExpiresAt         *time.Time
+}
+
+type InviteCodeUse struct {
+	Code   string `gorm:"primaryKey"`
+	UsedBy string `gorm:"primaryKey;index"`
+	UsedAt time.Time
+}
+
+type HandleBlocklistEntry struct {
+	Term      string `gorm:"primaryKey"`
+	Reason    *string
+	CreatedAt time.Time
+}
+
+type ReservedKey struct {
+	Did        string `gorm:"primaryKey"`
+	PrivateKey []byte
+	CreatedAt  time.Time `gorm:"index"`
+}
+
+type ProxyUpstream struct {
+	ID        uint
+	Service   string `gorm:"index"`
+	Url       string
+	Priority  int
+	Disabled  bool
+	CreatedAt time.Time
+}
+
+// jtis of service auth tokens we've accepted, so that they can't be replayed before they expire
+type UsedServiceAuthToken struct {
+	Iss       string    `gorm:"primaryKey"`
+	Jti       string    `gorm:"primaryKey"`
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+type HandleChange struct {
+	ID        uint
+	Did       string `gorm:"index:idx_handle_changes_did_created_at"`
+	Handle    string
+	CreatedAt time.Time `gorm:"index:idx_handle_changes_did_created_at,sort:desc"`
+}
+
+// only a hash of each code is kept, they're deleted as they get used
+type TotpRecoveryCode struct {
+	ID        uint
+	Did       string `gorm:"index"`
+	CodeHash  string
+	CreatedAt time.Time
+}
+
+type WaitlistEntry struct {
+	Email      string `gorm:"primaryKey"`
+	Handle     string
+	CreatedAt  time.Time
+	ApprovedAt *time.Time
+	InviteCode *string
 }
 
 type Token struct {
 	Token        string `gorm:"primaryKey"`
 	Did          string `gorm:"index"`
 	RefreshToken string `gorm:"index"`
+	SessionId    string `gorm:"index"`
 	CreatedAt    time.Time
 	ExpiresAt    time.Time `gorm:"index:,sort:asc"`
 }
 
+// each session has one refresh token at a time. the session id, creation time and client details are carried
+// over to the new token when it's refreshed
 type RefreshToken struct {
-	Token     string `gorm:"primaryKey"`
-	Did       string `gorm:"index"`
-	CreatedAt time.Time
-	ExpiresAt time.Time `gorm:"index:,sort:asc"`
+	Token            string `gorm:"primaryKey"`
+	Did              string `gorm:"index"`
+	SessionId        string `gorm:"index"`
+	SessionCreatedAt time.Time
+	LastUsedAt       time.Time
+	UserAgent        string
+	Ip               string
+	CreatedAt        time.Time
+	ExpiresAt        time.Time `gorm:"index:,sort:asc"`
+}
+
+// a pushed authorization request, waiting for the user to sign in and approve it. once they do it gets a code
+// the client can exchange for tokens
+type OAuthAuthorizationRequest struct {
+	ID            string `gorm:"primaryKey"`
+	ClientId      string
+	ClientAuth    string
+	DpopJkt       string
+	RedirectUri   string
+	Scope         string
+	State         string
+	ResponseMode  string
+	CodeChallenge string
+	LoginHint     string
+	Csrf          string
+	Did           *string
+	Code          *string `gorm:"uniqueIndex"`
+	CreatedAt     time.Time
+	ExpiresAt     time.Time `gorm:"index:,sort:asc"`
+}
+
+// gorm would call these o_auth_*
+func (OAuthAuthorizationRequest) TableName() string {
+	return "oauth_authorization_requests"
+}
+
+// one oauth session. the refresh token rotates on every use but the id stays the same, and access tokens carry it
+// as their jti
+type OAuthToken struct {
+	ID           string `gorm:"primaryKey"`
+	Did          string `gorm:"index"`
+	ClientId     string
+	ClientAuth   string
+	Scope        string
+	DpopJkt      string
+	RefreshToken string `gorm:"uniqueIndex"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	ExpiresAt    time.Time `gorm:"index:,sort:asc"`
+}
+
+func (OAuthToken) TableName() string {
+	return "oauth_tokens"
 }
 
 type Record struct {
-	Did       string `gorm:"primaryKey:idx_record_did_created_at;index:idx_record_did_nsid"`
-	CreatedAt string `gorm:"index;index:idx_record_did_created_at,sort:desc"`
-	Nsid      string `gorm:"primaryKey;index:idx_record_did_nsid"`
-	Rkey      string `gorm:"primaryKey"`
-	Cid       string
-	Value     []byte
+	Did         string `gorm:"primaryKey:idx_record_did_created_at;index:idx_record_did_nsid"`
+	CreatedAt   string `gorm:"index;index:idx_record_did_created_at,sort:desc"`
+	Nsid        string `gorm:"primaryKey;index:idx_record_did_nsid"`
+	Rkey        string `gorm:"primaryKey"`
+	Cid         string
+	Rev         string `gorm:"index"`
+	Value       []byte
+	TakedownRef *string
+	// the thread a post belongs to, empty for posts that aren't replies and null for other records
+	ReplyRoot *string `gorm:"index"`
 }
 
 type Block struct {
@@ -87,11 +235,12 @@ type Block struct {
 }
 
 type Blob struct {
-	ID        uint
-	CreatedAt string `gorm:"index"`
-	Did       string `gorm:"index;index:idx_blob_did_cid"`
-	Cid       []byte `gorm:"index;index:idx_blob_did_cid"`
-	RefCount  int
+	ID          uint
+	CreatedAt   string `gorm:"index"`
+	Did         string `gorm:"index;index:idx_blob_did_cid"`
+	Cid         []byte `gorm:"index;index:idx_blob_did_cid"`
+	RefCount    int
+	TakedownRef *string
 }
 
 type BlobPart struct {
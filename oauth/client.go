@@ -0,0 +1,411 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+const (
+	clientCacheTTL      = 10 * time.Minute
+	clientMetadataLimit = 64 * 1024
+	clientAssertionJwt  = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+)
+
+// https://atproto.com/specs/oauth#client-id-metadata-document
+type ClientMetadata struct {
+	ClientId                    string          `json:"client_id"`
+	ClientName                  string          `json:"client_name,omitempty"`
+	ClientUri                   string          `json:"client_uri,omitempty"`
+	LogoUri                     string          `json:"logo_uri,omitempty"`
+	TosUri                      string          `json:"tos_uri,omitempty"`
+	PolicyUri                   string          `json:"policy_uri,omitempty"`
+	RedirectUris                []string        `json:"redirect_uris"`
+	GrantTypes                  []string        `json:"grant_types"`
+	ResponseTypes               []string        `json:"response_types"`
+	Scope                       string          `json:"scope"`
+	ApplicationType             string          `json:"application_type,omitempty"`
+	TokenEndpointAuthMethod     string          `json:"token_endpoint_auth_method,omitempty"`
+	TokenEndpointAuthSigningAlg string          `json:"token_endpoint_auth_signing_alg,omitempty"`
+	DpopBoundAccessTokens       bool            `json:"dpop_bound_access_tokens"`
+	Jwks                        json.RawMessage `json:"jwks,omitempty"`
+	JwksUri                     string          `json:"jwks_uri,omitempty"`
+}
+
+// fetches the metadata document a client id points to. swap it out to test clients that aren't on the internet
+type ClientFetcher interface {
+	FetchClient(ctx context.Context, clientId string) (*ClientMetadata, error)
+}
+
+// an http client for fetching client metadata and jwks. client ids are urls anyone can pick, so it refuses to
+// connect to anything that isn't a public address. the check happens after dns resolution, so a hostname that
+// resolves to an internal address is refused too
+func NewPublicHttpClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("refusing to connect to non-public address %s", host)
+			}
+
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: 15 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+
+	// carrier grade nat, which is used for internal networks too
+	if ip4 := ip.To4(); ip4 != nil && ip4[0] == 100 && ip4[1]&0xc0 == 64 {
+		return false
+	}
+
+	return true
+}
+
+type HttpClientFetcher struct {
+	cli *http.Client
+}
+
+func NewHttpClientFetcher(cli *http.Client) *HttpClientFetcher {
+	return &HttpClientFetcher{
+		cli: cli,
+	}
+}
+
+func (f *HttpClientFetcher) FetchClient(ctx context.Context, clientId string) (*ClientMetadata, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", clientId, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("accept", "application/json")
+
+	resp, err := f.cli.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("client metadata request returned status %d", resp.StatusCode)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, clientMetadataLimit))
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata ClientMetadata
+	if err := json.Unmarshal(b, &metadata); err != nil {
+		return nil, fmt.Errorf("error decoding client metadata: %w", err)
+	}
+
+	return &metadata, nil
+}
+
+type cachedClient struct {
+	metadata  *ClientMetadata
+	expiresAt time.Time
+}
+
+// turns client ids into validated metadata. loopback clients are built from the id itself, everything else is
+// fetched and cached for a little while
+type ClientResolver struct {
+	fetcher ClientFetcher
+	// lets plain http client ids and redirect uris through, for running clients on localhost during development
+	allowInsecure bool
+
+	lk    sync.Mutex
+	cache map[string]cachedClient
+}
+
+func NewClientResolver(fetcher ClientFetcher, allowInsecure bool) *ClientResolver {
+	return &ClientResolver{
+		fetcher:       fetcher,
+		allowInsecure: allowInsecure,
+		cache:         map[string]cachedClient{},
+	}
+}
+
+func (r *ClientResolver) Resolve(ctx context.Context, clientId string) (*ClientMetadata, error) {
+	if IsLoopbackClientId(clientId) {
+		return loopbackClientMetadata(clientId)
+	}
+
+	r.lk.Lock()
+	cached, ok := r.cache[clientId]
+	r.lk.Unlock()
+
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.metadata, nil
+	}
+
+	u, err := url.Parse(clientId)
+	if err != nil || u.Host == "" || u.Fragment != "" || (u.Scheme != "https" && !(r.allowInsecure && u.Scheme == "http")) {
+		return nil, NewError("invalid_client", "client_id must be an https url")
+	}
+
+	metadata, err := r.fetcher.FetchClient(ctx, clientId)
+	if err != nil {
+		return nil, NewError("invalid_client", "could not fetch client metadata: "+err.Error())
+	}
+
+	if err := metadata.validate(clientId, r.allowInsecure); err != nil {
+		return nil, NewError("invalid_client_metadata", err.Error())
+	}
+
+	r.lk.Lock()
+	r.cache[clientId] = cachedClient{
+		metadata:  metadata,
+		expiresAt: time.Now().Add(clientCacheTTL),
+	}
+	r.lk.Unlock()
+
+	return metadata, nil
+}
+
+func (c *ClientMetadata) validate(clientId string, allowInsecure bool) error {
+	if c.ClientId != clientId {
+		return fmt.Errorf("client_id in metadata does not match")
+	}
+
+	if len(c.RedirectUris) == 0 {
+		return fmt.Errorf("redirect_uris is required")
+	}
+
+	for _, ru := range c.RedirectUris {
+		if err := validateRedirectUri(ru, c.ApplicationType, allowInsecure); err != nil {
+			return err
+		}
+	}
+
+	if !HasScope(c.Scope, ScopeAtproto) {
+		return fmt.Errorf("scope must include %s", ScopeAtproto)
+	}
+
+	if !slices.Contains(c.ResponseTypes, "code") {
+		return fmt.Errorf("response_types must include code")
+	}
+
+	if !slices.Contains(c.GrantTypes, "authorization_code") {
+		return fmt.Errorf("grant_types must include authorization_code")
+	}
+
+	if !c.DpopBoundAccessTokens {
+		return fmt.Errorf("dpop_bound_access_tokens must be true")
+	}
+
+	switch c.TokenEndpointAuthMethod {
+	case "", "none":
+		c.TokenEndpointAuthMethod = "none"
+	case "private_key_jwt":
+		if len(c.Jwks) == 0 && c.JwksUri == "" {
+			return fmt.Errorf("private_key_jwt clients need jwks or jwks_uri")
+		}
+		if c.TokenEndpointAuthSigningAlg == "" {
+			c.TokenEndpointAuthSigningAlg = "ES256"
+		}
+	default:
+		return fmt.Errorf("unsupported token_endpoint_auth_method %q", c.TokenEndpointAuthMethod)
+	}
+
+	return nil
+}
+
+func validateRedirectUri(raw, applicationType string, allowInsecure bool) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid redirect uri %q", raw)
+	}
+
+	switch {
+	case u.Scheme == "https":
+		return nil
+	case u.Scheme == "http" && isLoopbackHost(u.Hostname()):
+		return nil
+	case u.Scheme == "http" && allowInsecure:
+		return nil
+	case applicationType == "native" && strings.Contains(u.Scheme, "."):
+		// native apps can use a private scheme, which has to be their domain reversed
+		return nil
+	default:
+		return fmt.Errorf("redirect uri %q is not allowed", raw)
+	}
+}
+
+func isLoopbackHost(host string) bool {
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// clients that run on the user's own machine identify as http://localhost, with their redirect uris and scope
+// in the query string since there's no document to fetch
+func IsLoopbackClientId(clientId string) bool {
+	u, err := url.Parse(clientId)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "http" && u.Host == "localhost" && (u.Path == "" || u.Path == "/")
+}
+
+func loopbackClientMetadata(clientId string) (*ClientMetadata, error) {
+	u, err := url.Parse(clientId)
+	if err != nil {
+		return nil, NewError("invalid_client", "invalid client_id")
+	}
+
+	redirectUris := u.Query()["redirect_uri"]
+	if len(redirectUris) == 0 {
+		redirectUris = []string{"http://127.0.0.1/", "http://[::1]/"}
+	}
+
+	for _, ru := range redirectUris {
+		ruu, err := url.Parse(ru)
+		if err != nil || ruu.Scheme != "http" || !isLoopbackHost(ruu.Hostname()) {
+			return nil, NewError("invalid_client", "loopback clients can only redirect to loopback ips")
+		}
+	}
+
+	scope := u.Query().Get("scope")
+	if scope == "" {
+		scope = ScopeAtproto
+	}
+
+	return &ClientMetadata{
+		ClientId:                clientId,
+		ClientName:              "Loopback client",
+		RedirectUris:            redirectUris,
+		GrantTypes:              []string{"authorization_code", "refresh_token"},
+		ResponseTypes:           []string{"code"},
+		Scope:                   scope,
+		ApplicationType:         "native",
+		TokenEndpointAuthMethod: "none",
+		DpopBoundAccessTokens:   true,
+	}, nil
+}
+
+func (c *ClientMetadata) IsConfidential() bool {
+	return c.TokenEndpointAuthMethod == "private_key_jwt"
+}
+
+// loopback redirects can come back on any port (RFC 8252 7.3), everything else has to match exactly
+func (c *ClientMetadata) AllowsRedirectUri(raw string) bool {
+	if slices.Contains(c.RedirectUris, raw) {
+		return true
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "http" || !isLoopbackHost(u.Hostname()) {
+		return false
+	}
+
+	for _, ru := range c.RedirectUris {
+		ruu, err := url.Parse(ru)
+		if err != nil || ruu.Hostname() != u.Hostname() || ruu.Scheme != u.Scheme {
+			continue
+		}
+		if ruu.Path == u.Path && ruu.RawQuery == u.RawQuery {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *ClientMetadata) AllowsScope(scope string) bool {
+	allowed := ParseScopes(c.Scope)
+	for _, sc := range ParseScopes(scope) {
+		if !slices.Contains(allowed, sc) || !slices.Contains(SupportedScopes, sc) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *ClientMetadata) KeySet(ctx context.Context, cli *http.Client) (jwk.Set, error) {
+	if len(c.Jwks) > 0 {
+		return jwk.Parse(c.Jwks)
+	}
+
+	return jwk.Fetch(ctx, c.JwksUri, jwk.WithHTTPClient(cli))
+}
+
+// what the consent page calls the client
+func (c *ClientMetadata) DisplayName() string {
+	if c.ClientName != "" {
+		return c.ClientName
+	}
+
+	if u, err := url.Parse(c.ClientId); err == nil {
+		return u.Host
+	}
+
+	return c.ClientId
+}
+
+// checks the private_key_jwt assertion a confidential client authenticates with
+func VerifyClientAssertion(ctx context.Context, cli *http.Client, client *ClientMetadata, assertionType, assertion, issuer string, replay *ReplayCache) error {
+	if assertionType != clientAssertionJwt || assertion == "" {
+		return NewError("invalid_client", "client authentication is required")
+	}
+
+	keys, err := client.KeySet(ctx, cli)
+	if err != nil {
+		return NewError("invalid_client", "could not get client keys: "+err.Error())
+	}
+
+	tok, err := jwt.Parse([]byte(assertion),
+		jwt.WithKeySet(keys, jws.WithInferAlgorithmFromKey(true)),
+		jwt.WithValidate(true),
+		jwt.WithIssuer(client.ClientId),
+		jwt.WithSubject(client.ClientId),
+		jwt.WithAudience(issuer),
+		jwt.WithRequiredClaim("jti"),
+		jwt.WithRequiredClaim("iat"),
+		jwt.WithAcceptableSkew(dpopMaxClockSkew),
+	)
+	if err != nil {
+		return NewError("invalid_client", "invalid client assertion: "+err.Error())
+	}
+
+	if time.Since(tok.IssuedAt()) > dpopMaxAge {
+		return NewError("invalid_client", "client assertion is too old")
+	}
+
+	if !replay.Use("client:"+tok.JwtID(), tok.IssuedAt().Add(dpopMaxAge+dpopMaxClockSkew)) {
+		return NewError("invalid_client", "client assertion has already been used")
+	}
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package oauth
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+)
+
+type fakeClientFetcher struct {
+	clients map[string]*ClientMetadata
+	fetches int
+}
+
+func (f *fakeClientFetcher) FetchClient(ctx context.Context, clientId string) (*ClientMetadata, error) {
+	f.fetches++
+
+	metadata, ok := f.clients[clientId]
+	if !ok {
+		return nil, fmt.Errorf("client metadata request returned status 404")
+	}
+
+	// the resolver fills in defaults, so hand out copies like a real fetch would
+	cp := *metadata
+	return &cp, nil
+}
+
+func testClientMetadata(clientId string) *ClientMetadata {
+	return &ClientMetadata{
+		ClientId:              clientId,
+		RedirectUris:          []string{"https://app.example.com/callback"},
+		GrantTypes:            []string{"authorization_code", "refresh_token"},
+		ResponseTypes:         []string{"code"},
+		Scope:                 "atproto transition:generic",
+		DpopBoundAccessTokens: true,
+	}
+}
+
+func TestClientResolver(t *testing.T) {
+	const clientId = "https://app.example.com/client-metadata.json"
+
+	tests := []struct {
+		name     string
+		clientId string
+		metadata func(*ClientMetadata)
+		wantErr  string
+	}{
+		{name: "valid", clientId: clientId},
+		{name: "not found", clientId: "https://app.example.com/missing.json", wantErr: "invalid_client"},
+		{name: "plain http", clientId: "http://app.example.com/client-metadata.json", wantErr: "invalid_client"},
+		{name: "fragment", clientId: clientId + "#foo", wantErr: "invalid_client"},
+		{name: "mismatched client_id", clientId: clientId, metadata: func(c *ClientMetadata) { c.ClientId = "https://other.example.com/" }, wantErr: "invalid_client_metadata"},
+		{name: "no redirect uris", clientId: clientId, metadata: func(c *ClientMetadata) { c.RedirectUris = nil }, wantErr: "invalid_client_metadata"},
+		{name: "http redirect uri", clientId: clientId, metadata: func(c *ClientMetadata) { c.RedirectUris = []string{"http://app.example.com/callback"} }, wantErr: "invalid_client_metadata"},
+		{name: "missing atproto scope", clientId: clientId, metadata: func(c *ClientMetadata) { c.Scope = "transition:generic" }, wantErr: "invalid_client_metadata"},
+		{name: "no code response type", clientId: clientId, metadata: func(c *ClientMetadata) { c.ResponseTypes = []string{"token"} }, wantErr: "invalid_client_metadata"},
+		{name: "no authorization_code grant", clientId: clientId, metadata: func(c *ClientMetadata) { c.GrantTypes = []string{"refresh_token"} }, wantErr: "invalid_client_metadata"},
+		{name: "not dpop bound", clientId: clientId, metadata: func(c *ClientMetadata) { c.DpopBoundAccessTokens = false }, wantErr: "invalid_client_metadata"},
+		{name: "private_key_jwt without keys", clientId: clientId, metadata: func(c *ClientMetadata) { c.TokenEndpointAuthMethod = "private_key_jwt" }, wantErr: "invalid_client_metadata"},
+		{name: "unsupported auth method", clientId: clientId, metadata: func(c *ClientMetadata) { c.TokenEndpointAuthMethod = "client_secret_basic" }, wantErr: "invalid_client_metadata"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metadata := testClientMetadata(clientId)
+			if tt.metadata != nil {
+				tt.metadata(metadata)
+			}
+
+			fetcher := &fakeClientFetcher{clients: map[string]*ClientMetadata{clientId: metadata}}
+			r := NewClientResolver(fetcher, false)
+
+			client, err := r.Resolve(context.Background(), tt.clientId)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if client.TokenEndpointAuthMethod != "none" {
+					t.Errorf("expected auth method to default to none, got %q", client.TokenEndpointAuthMethod)
+				}
+				return
+			}
+
+			oerr, ok := err.(*Error)
+			if !ok || oerr.Code != tt.wantErr {
+				t.Fatalf("expected %s error, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestClientResolverCaches(t *testing.T) {
+	const clientId = "https://app.example.com/client-metadata.json"
+
+	fetcher := &fakeClientFetcher{clients: map[string]*ClientMetadata{clientId: testClientMetadata(clientId)}}
+	r := NewClientResolver(fetcher, false)
+
+	for range 3 {
+		if _, err := r.Resolve(context.Background(), clientId); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if fetcher.fetches != 1 {
+		t.Errorf("expected 1 fetch, got %d", fetcher.fetches)
+	}
+}
+
+func TestClientResolverLoopback(t *testing.T) {
+	fetcher := &fakeClientFetcher{}
+	r := NewClientResolver(fetcher, false)
+
+	client, err := r.Resolve(context.Background(), "http://localhost?redirect_uri=http%3A%2F%2F127.0.0.1%3A8080%2Fcallback&scope=atproto+transition%3Ageneric")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if fetcher.fetches != 0 {
+		t.Error("loopback clients shouldn't be fetched")
+	}
+
+	if !client.AllowsRedirectUri("http://127.0.0.1:9090/callback") {
+		t.Error("loopback redirects should be allowed on any port")
+	}
+
+	if client.AllowsRedirectUri("http://127.0.0.1:9090/other") {
+		t.Error("loopback redirects still need a matching path")
+	}
+
+	if !client.AllowsScope("atproto transition:generic") || client.AllowsScope("atproto transition:chat.bsky") {
+		t.Error("loopback clients should only get the scopes they asked for")
+	}
+
+	if _, err := r.Resolve(context.Background(), "http://localhost?redirect_uri=https%3A%2F%2Fevil.example.com%2F"); err == nil {
+		t.Error("loopback clients shouldn't be able to redirect anywhere else")
+	}
+}
+
+func TestClientResolverInsecure(t *testing.T) {
+	const clientId = "http://app.local/client-metadata.json"
+
+	metadata := testClientMetadata(clientId)
+	metadata.RedirectUris = []string{"http://app.local/callback"}
+
+	fetcher := &fakeClientFetcher{clients: map[string]*ClientMetadata{clientId: metadata}}
+
+	if _, err := NewClientResolver(fetcher, false).Resolve(context.Background(), clientId); err == nil {
+		t.Error("expected plain http clients to be rejected")
+	}
+
+	if _, err := NewClientResolver(fetcher, true).Resolve(context.Background(), clientId); err != nil {
+		t.Errorf("expected plain http clients to be allowed, got %v", err)
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"1.1.1.1", true},
+		{"2606:4700:4700::1111", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.0.0.1", false},
+		{"172.16.5.4", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::ffff:127.0.0.1", false},
+	}
+
+	for _, tt := range tests {
+		if got := isPublicIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("isPublicIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package oauth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+const (
+	dpopNonceWindow  = 3 * time.Minute
+	dpopMaxAge       = 5 * time.Minute
+	dpopMaxClockSkew = time.Minute
+)
+
+// the client has to retry with the nonce from the DPoP-Nonce header
+var ErrUseDpopNonce = errors.New("use_dpop_nonce")
+
+// remembers jtis until they expire so proofs and client assertions can't be replayed. it's kept in memory since
+// every authenticated request carries a proof and the entries only live a few minutes
+type ReplayCache struct {
+	lk        sync.Mutex
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+func NewReplayCache() *ReplayCache {
+	return &ReplayCache{
+		seen: map[string]time.Time{},
+	}
+}
+
+// returns false if the jti has been used before
+func (c *ReplayCache) Use(jti string, expiresAt time.Time) bool {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastPrune) > time.Minute {
+		for k, exp := range c.seen {
+			if now.After(exp) {
+				delete(c.seen, k)
+			}
+		}
+		c.lastPrune = now
+	}
+
+	if _, ok := c.seen[jti]; ok {
+		return false
+	}
+
+	c.seen[jti] = expiresAt
+	return true
+}
+
+type DpopProof struct {
+	Jkt string
+	Jti string
+}
+
+type dpopClaims struct {
+	Jti   string `json:"jti"`
+	Htm   string `json:"htm"`
+	Htu   string `json:"htu"`
+	Iat   int64  `json:"iat"`
+	Nonce string `json:"nonce"`
+	Ath   string `json:"ath"`
+}
+
+// nonces are derived from the current time window so they rotate without having to be stored anywhere
+type DpopManager struct {
+	secret []byte
+	replay *ReplayCache
+}
+
+func NewDpopManager(replay *ReplayCache) *DpopManager {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+
+	return &DpopManager{
+		secret: secret,
+		replay: replay,
+	}
+}
+
+func (d *DpopManager) nonceFor(window int64) string {
+	mac := hmac.New(sha256.New, d.secret)
+	binary.Write(mac, binary.BigEndian, window)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (d *DpopManager) Nonce() string {
+	return d.nonceFor(time.Now().Unix() / int64(dpopNonceWindow.Seconds()))
+}
+
+func (d *DpopManager) validNonce(nonce string) bool {
+	window := time.Now().Unix() / int64(dpopNonceWindow.Seconds())
+	for _, w := range []int64{window, window - 1, window + 1} {
+		if hmac.Equal([]byte(nonce), []byte(d.nonceFor(w))) {
+			return true
+		}
+	}
+	return false
+}
+
+// checks a DPoP proof for a request to htu. accessToken is the token the proof is bound to when it's used
+// against a resource, or empty at the token and par endpoints
+func (d *DpopManager) Verify(proof, htm, htu, accessToken string) (*DpopProof, error) {
+	if proof == "" {
+		return nil, fmt.Errorf("missing dpop proof")
+	}
+
+	msg, err := jws.Parse([]byte(proof))
+	if err != nil {
+		return nil, fmt.Errorf("invalid dpop proof: %w", err)
+	}
+
+	if len(msg.Signatures()) != 1 {
+		return nil, fmt.Errorf("dpop proof must have exactly one signature")
+	}
+
+	hdr := msg.Signatures()[0].ProtectedHeaders()
+	if hdr.Type() != "dpop+jwt" {
+		return nil, fmt.Errorf("dpop proof has the wrong typ")
+	}
+
+	if hdr.Algorithm() != jwa.ES256 {
+		return nil, fmt.Errorf("unsupported dpop algorithm %s", hdr.Algorithm())
+	}
+
+	key := hdr.JWK()
+	if key == nil {
+		return nil, fmt.Errorf("dpop proof is missing its jwk")
+	}
+
+	if _, ok := key.(jwk.ECDSAPrivateKey); ok {
+		return nil, fmt.Errorf("dpop proof contains a private key")
+	}
+
+	payload, err := jws.Verify([]byte(proof), jws.WithKey(jwa.ES256, key))
+	if err != nil {
+		return nil, fmt.Errorf("invalid dpop signature: %w", err)
+	}
+
+	var claims dpopClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("invalid dpop claims: %w", err)
+	}
+
+	if claims.Jti == "" {
+		return nil, fmt.Errorf("dpop proof is missing jti")
+	}
+
+	if claims.Htm != htm {
+		return nil, fmt.Errorf("dpop htm does not match the request")
+	}
+
+	if normalizeHtu(claims.Htu) != normalizeHtu(htu) {
+		return nil, fmt.Errorf("dpop htu does not match the request")
+	}
+
+	iat := time.Unix(claims.Iat, 0)
+	if time.Since(iat) > dpopMaxAge || time.Until(iat) > dpopMaxClockSkew {
+		return nil, fmt.Errorf("dpop proof is too old or from the future")
+	}
+
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		if claims.Ath != base64.RawURLEncoding.EncodeToString(sum[:]) {
+			return nil, fmt.Errorf("dpop ath does not match the access token")
+		}
+	}
+
+	if claims.Nonce == "" || !d.validNonce(claims.Nonce) {
+		return nil, ErrUseDpopNonce
+	}
+
+	if !d.replay.Use("dpop:"+claims.Jti, iat.Add(dpopMaxAge+dpopMaxClockSkew)) {
+		return nil, fmt.Errorf("dpop proof has already been used")
+	}
+
+	thumb, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DpopProof{
+		Jkt: base64.RawURLEncoding.EncodeToString(thumb),
+		Jti: claims.Jti,
+	}, nil
+}
+
+func normalizeHtu(htu string) string {
+	u, err := url.Parse(htu)
+	if err != nil {
+		return htu
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
@@ -0,0 +1,175 @@
+package oauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+const testHtu = "https://pds.example.com/oauth/token"
+
+type testProof struct {
+	htm   string
+	htu   string
+	nonce string
+	ath   string
+	jti   string
+	iat   time.Time
+}
+
+func newTestDpopKey(t *testing.T) jwk.Key {
+	t.Helper()
+
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := jwk.FromRaw(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func signTestProof(t *testing.T, key jwk.Key, p testProof) string {
+	t.Helper()
+
+	pub, err := key.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hdrs := jws.NewHeaders()
+	hdrs.Set(jws.TypeKey, "dpop+jwt")
+	hdrs.Set(jws.JWKKey, pub)
+
+	payload, err := json.Marshal(map[string]any{
+		"jti":   p.jti,
+		"htm":   p.htm,
+		"htu":   p.htu,
+		"iat":   p.iat.Unix(),
+		"nonce": p.nonce,
+		"ath":   p.ath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := jws.Sign(payload, jws.WithKey(jwa.ES256, key, jws.WithProtectedHeaders(hdrs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(signed)
+}
+
+func TestDpopVerify(t *testing.T) {
+	d := NewDpopManager(NewReplayCache())
+	key := newTestDpopKey(t)
+
+	accessToken := "access-token"
+	sum := sha256.Sum256([]byte(accessToken))
+	ath := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	valid := func() testProof {
+		return testProof{
+			htm:   "POST",
+			htu:   testHtu,
+			nonce: d.Nonce(),
+			jti:   uuid.NewString(),
+			iat:   time.Now(),
+		}
+	}
+
+	tests := []struct {
+		name        string
+		proof       func() testProof
+		htu         string
+		accessToken string
+		wantNonce   bool
+		wantErr     bool
+	}{
+		{"valid", valid, testHtu, "", false, false},
+		{"query string is ignored", valid, testHtu + "?foo=bar", "", false, false},
+		{"missing nonce", func() testProof { p := valid(); p.nonce = ""; return p }, testHtu, "", true, true},
+		{"made up nonce", func() testProof { p := valid(); p.nonce = "nope"; return p }, testHtu, "", true, true},
+		{"wrong htm", func() testProof { p := valid(); p.htm = "GET"; return p }, testHtu, "", false, true},
+		{"wrong htu", func() testProof { p := valid(); p.htu = "https://evil.example.com/oauth/token"; return p }, testHtu, "", false, true},
+		{"missing jti", func() testProof { p := valid(); p.jti = ""; return p }, testHtu, "", false, true},
+		{"too old", func() testProof { p := valid(); p.iat = time.Now().Add(-10 * time.Minute); return p }, testHtu, "", false, true},
+		{"from the future", func() testProof { p := valid(); p.iat = time.Now().Add(10 * time.Minute); return p }, testHtu, "", false, true},
+		{"matching ath", func() testProof { p := valid(); p.ath = ath; return p }, testHtu, accessToken, false, false},
+		{"missing ath", valid, testHtu, accessToken, false, true},
+		{"wrong ath", func() testProof { p := valid(); p.ath = ath; return p }, testHtu, "other-token", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proof, err := d.Verify(signTestProof(t, key, tt.proof()), "POST", tt.htu, tt.accessToken)
+			if tt.wantNonce && !errors.Is(err, ErrUseDpopNonce) {
+				t.Fatalf("expected ErrUseDpopNonce, got %v", err)
+			}
+			if tt.wantErr && err == nil {
+				t.Fatal("expected an error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.wantErr && proof.Jkt == "" {
+				t.Fatal("expected a key thumbprint")
+			}
+		})
+	}
+}
+
+func TestDpopVerifyReplay(t *testing.T) {
+	d := NewDpopManager(NewReplayCache())
+	key := newTestDpopKey(t)
+
+	proof := signTestProof(t, key, testProof{
+		htm:   "POST",
+		htu:   testHtu,
+		nonce: d.Nonce(),
+		jti:   uuid.NewString(),
+		iat:   time.Now(),
+	})
+
+	if _, err := d.Verify(proof, "POST", testHtu, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := d.Verify(proof, "POST", testHtu, ""); err == nil {
+		t.Fatal("expected a replayed proof to be rejected")
+	}
+}
+
+func TestDpopVerifyOtherManagersNonce(t *testing.T) {
+	d := NewDpopManager(NewReplayCache())
+	other := NewDpopManager(NewReplayCache())
+	key := newTestDpopKey(t)
+
+	proof := signTestProof(t, key, testProof{
+		htm:   "POST",
+		htu:   testHtu,
+		nonce: other.Nonce(),
+		jti:   uuid.NewString(),
+		iat:   time.Now(),
+	})
+
+	if _, err := d.Verify(proof, "POST", testHtu, ""); !errors.Is(err, ErrUseDpopNonce) {
+		t.Fatalf("expected ErrUseDpopNonce, got %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+// Package oauth has the protocol pieces of the atproto OAuth profile: client metadata, DPoP proofs, PKCE and
+// scopes. The authorization server endpoints themselves live in the server package.
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"slices"
+	"strings"
+)
+
+const (
+	ScopeAtproto           = "atproto"
+	ScopeTransitionGeneric = "transition:generic"
+	ScopeTransitionChat    = "transition:chat.bsky"
+	ScopeTransitionEmail   = "transition:email"
+)
+
+var SupportedScopes = []string{
+	ScopeAtproto,
+	ScopeTransitionGeneric,
+	ScopeTransitionChat,
+	ScopeTransitionEmail,
+}
+
+// an error in the shape RFC 6749 wants returned to clients
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+func NewError(code, description string) *Error {
+	return &Error{
+		Code:        code,
+		Description: description,
+	}
+}
+
+// unguessable identifiers for codes, request uris and refresh tokens
+func RandomToken(prefix string) string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(b)
+}
+
+func ParseScopes(scope string) []string {
+	return strings.Fields(scope)
+}
+
+func HasScope(scope, want string) bool {
+	return slices.Contains(ParseScopes(scope), want)
+}
+
+// only S256 is supported, plain challenges are useless against the attacks PKCE is meant to stop
+func VerifyPkce(challenge, method, verifier string) bool {
+	if method != "S256" || len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:]) == challenge
+}
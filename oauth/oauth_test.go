@@ -0,0 +1,55 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func TestVerifyPkce(t *testing.T) {
+	verifier := strings.Repeat("a", 43)
+	challenge := pkceChallenge(verifier)
+
+	tests := []struct {
+		name      string
+		challenge string
+		method    string
+		verifier  string
+		want      bool
+	}{
+		{"valid", challenge, "S256", verifier, true},
+		{"wrong verifier", challenge, "S256", strings.Repeat("b", 43), false},
+		{"plain method", verifier, "plain", verifier, false},
+		{"verifier too short", pkceChallenge("short"), "S256", "short", false},
+		{"verifier too long", pkceChallenge(strings.Repeat("a", 129)), "S256", strings.Repeat("a", 129), false},
+		{"empty challenge", "", "S256", verifier, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyPkce(tt.challenge, tt.method, tt.verifier); got != tt.want {
+				t.Errorf("VerifyPkce() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	if !HasScope("atproto transition:generic", ScopeTransitionGeneric) {
+		t.Error("expected transition:generic to be found")
+	}
+
+	if HasScope("atproto transition:generic", ScopeTransitionChat) {
+		t.Error("didn't expect transition:chat.bsky to be found")
+	}
+
+	if HasScope("atproto:extra", ScopeAtproto) {
+		t.Error("scopes should only match whole words")
+	}
+}
@@ -143,13 +143,49 @@ func (c *Client) SendOperation(ctx context.Context, did string, op *Operation) e
 	defer resp.Body.Close()
 
 	b, err = io.ReadAll(resp.Body)
-	if err != nil {
+	if err != nil || resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("error sending operation. status code: %d, response: %s", resp.StatusCode, string(b))
 	}
 
 	return nil
 }
 
+func (c *Client) RotationKeyDid() (string, error) {
+	pubrotkey, err := c.rotationKey.PublicKey()
+	if err != nil {
+		return "", err
+	}
+
+	return pubrotkey.DIDKey(), nil
+}
+
+// the values a did document should have for an account hosted here with the given signing key
+func (c *Client) RecommendedCredentials(sigkey *crypto.PrivateKeyK256, handle string) (*Credentials, error) {
+	pubsigkey, err := sigkey.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	rotationKey, err := c.RotationKeyDid()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Credentials{
+		RotationKeys: []string{rotationKey},
+		AlsoKnownAs:  []string{"at://" + handle},
+		VerificationMethods: map[string]string{
+			"atproto": pubsigkey.DIDKey(),
+		},
+		Services: map[string]identity.OperationService{
+			"atproto_pds": {
+				Type:     "AtprotoPersonalDataServer",
+				Endpoint: "https://" + c.pdsHostname,
+			},
+		},
+	}, nil
+}
+
 func DidFromOp(op *Operation) (string, error) {
 	b, err := op.MarshalCBOR()
 	if err != nil {
@@ -18,6 +18,13 @@ type Operation struct {
 	Sig                 string                               `json:"sig,omitempty"`
 }
 
+type Credentials struct {
+	RotationKeys        []string                             `json:"rotationKeys"`
+	AlsoKnownAs         []string                             `json:"alsoKnownAs"`
+	VerificationMethods map[string]string                    `json:"verificationMethods"`
+	Services            map[string]identity.OperationService `json:"services"`
+}
+
 type OperationService struct {
 	Type     string `json:"type"`
 	Endpoint string `json:"endpoint"`
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/util"
+	"gorm.io/gorm"
+)
+
+// the error name sync endpoints should return for a repo with the given status
+func repoStatusError(status string) string {
+	switch status {
+	case "takendown":
+		return "RepoTakendown"
+	case "deactivated":
+		return "RepoDeactivated"
+	default:
+		return "RepoInactive"
+	}
+}
+
+// whether the did document currently says that the account lives here and signs with the key we have for it
+func (s *Server) validDid(ctx context.Context, did string, signingKey []byte) (bool, error) {
+	ctx = context.WithValue(ctx, "skip-cache", true)
+	doc, err := s.passport.FetchDoc(ctx, did)
+	if err != nil {
+		return false, err
+	}
+
+	if doc.PdsEndpoint() != "https://"+s.config.Hostname {
+		return false, nil
+	}
+
+	k, err := crypto.ParsePrivateBytesK256(signingKey)
+	if err != nil {
+		return false, err
+	}
+
+	pubsigkey, err := k.PublicKey()
+	if err != nil {
+		return false, err
+	}
+
+	dockey, err := doc.AtprotoSigningKey()
+	if err != nil {
+		return false, nil
+	}
+
+	return dockey.Equal(pubsigkey), nil
+}
+
+func (s *Server) sendAccountEvent(did string, status *string) {
+	s.evtman.AddEvent(context.TODO(), &events.XRPCStreamEvent{
+		RepoAccount: &atproto.SyncSubscribeRepos_Account{
+			Did:    did,
+			Active: status == nil,
+			Status: status,
+			Seq:    time.Now().UnixMicro(), // TODO: no
+			Time:   time.Now().Format(util.ISO8601),
+		},
+	})
+}
+
+// every table with rows that belong to an account, and the column holding its did. blob_parts are keyed by
+// blob, so they're deleted separately before the blobs they belong to. anything new that's stored per account
+// needs to go here too
+var accountTables = []struct {
+	table  string
+	column string
+}{
+	{"blobs", "did"},
+	{"records", "did"},
+	{"blocks", "did"},
+	{"tokens", "did"},
+	{"refresh_tokens", "did"},
+	{"invite_codes", "did"},
+	{"invite_code_uses", "used_by"},
+	{"handle_changes", "did"},
+	{"reserved_keys", "did"},
+	{"oauth_tokens", "did"},
+	{"oauth_authorization_requests", "did"},
+	{"totp_recovery_codes", "did"},
+	{"actors", "did"},
+	{"repos", "did"},
+}
+
+// removes every trace of an account from the database, then tells the firehose it is gone
+func (s *Server) deleteAccount(ctx context.Context, did string) error {
+	if err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM blob_parts WHERE blob_id IN (SELECT id FROM blobs WHERE did = ?)", did).Error; err != nil {
+			return err
+		}
+
+		for _, t := range accountTables {
+			if err := tx.Exec("DELETE FROM "+t.table+" WHERE "+t.column+" = ?", did).Error; err != nil {
+				return fmt.Errorf("error deleting from %s: %w", t.table, err)
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	s.sendAccountEvent(did, to.StringPtr("deleted"))
+
+	s.evtman.AddEvent(ctx, &events.XRPCStreamEvent{
+		RepoTombstone: &atproto.SyncSubscribeRepos_Tombstone{
+			Did:  did,
+			Seq:  time.Now().UnixMicro(), // TODO: no
+			Time: time.Now().Format(util.ISO8601),
+		},
+	})
+
+	return nil
+}
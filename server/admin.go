@@ -0,0 +1,100 @@
+package server
+
+import (
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/haileyok/cocoon/models"
+)
+
+const (
+	AdminSubjectTypeRepo   = "com.atproto.admin.defs#repoRef"
+	AdminSubjectTypeRecord = "com.atproto.repo.strongRef"
+	AdminSubjectTypeBlob   = "com.atproto.admin.defs#repoBlobRef"
+	defaultTakedownRef     = "TAKEDOWN"
+)
+
+type ComAtprotoAdminDefsStatusAttr struct {
+	Applied bool    `json:"applied"`
+	Ref     *string `json:"ref,omitempty"`
+}
+
+// a flattened version of the repoRef | strongRef | repoBlobRef union
+type ComAtprotoAdminSubject struct {
+	Type      string  `json:"$type"`
+	Did       string  `json:"did,omitempty"`
+	Uri       string  `json:"uri,omitempty"`
+	Cid       string  `json:"cid,omitempty"`
+	RecordUri *string `json:"recordUri,omitempty"`
+}
+
+func takedownAttr(ref *string) *ComAtprotoAdminDefsStatusAttr {
+	return &ComAtprotoAdminDefsStatusAttr{
+		Applied: ref != nil,
+		Ref:     ref,
+	}
+}
+
+func deactivatedAttr(urepo *models.Repo) *ComAtprotoAdminDefsStatusAttr {
+	return &ComAtprotoAdminDefsStatusAttr{
+		Applied: urepo.DeactivatedAt != nil,
+	}
+}
+
+type ComAtprotoAdminDefsAccountView struct {
+	Did              string                           `json:"did"`
+	Handle           string                           `json:"handle"`
+	Email            *string                          `json:"email,omitempty"`
+	IndexedAt        string                           `json:"indexedAt"`
+	InvitedBy        *ComAtprotoServerDefsInviteCode  `json:"invitedBy,omitempty"`
+	Invites          []ComAtprotoServerDefsInviteCode `json:"invites,omitempty"`
+	InvitesDisabled  bool                             `json:"invitesDisabled"`
+	InviteNote       *string                          `json:"inviteNote,omitempty"`
+	EmailConfirmedAt *string                          `json:"emailConfirmedAt,omitempty"`
+	DeactivatedAt    *string                          `json:"deactivatedAt,omitempty"`
+}
+
+func (s *Server) getAccountView(urepo *models.RepoActor) (*ComAtprotoAdminDefsAccountView, error) {
+	codes, err := s.getInviteCodesForAccount(urepo.Repo.Did)
+	if err != nil {
+		return nil, err
+	}
+
+	invites, err := s.getInviteCodeViews(codes)
+	if err != nil {
+		return nil, err
+	}
+
+	view := ComAtprotoAdminDefsAccountView{
+		Did:             urepo.Repo.Did,
+		Handle:          urepo.Handle,
+		Email:           to.StringPtr(urepo.Email),
+		IndexedAt:       urepo.Repo.CreatedAt.Format(time.RFC3339),
+		Invites:         invites,
+		InvitesDisabled: urepo.InvitesDisabled,
+		InviteNote:      urepo.InviteNote,
+	}
+
+	invitedBy, err := s.getInvitedBy(urepo.Repo.Did)
+	if err != nil {
+		return nil, err
+	}
+
+	if invitedBy != nil {
+		views, err := s.getInviteCodeViews([]models.InviteCode{*invitedBy})
+		if err != nil {
+			return nil, err
+		}
+		view.InvitedBy = &views[0]
+	}
+
+	if urepo.EmailConfirmedAt != nil {
+		view.EmailConfirmedAt = to.StringPtr(urepo.EmailConfirmedAt.Format(time.RFC3339))
+	}
+
+	if urepo.DeactivatedAt != nil {
+		view.DeactivatedAt = to.StringPtr(urepo.DeactivatedAt.Format(time.RFC3339))
+	}
+
+	return &view, nil
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+)
+
+const authFactorCodeExpiry = 10 * time.Minute
+
+var (
+	errAuthFactorRequired = errors.New("a sign in code has been sent to your email address")
+	errTotpRequired       = errors.New("enter the code from your authenticator app")
+	errAuthFactorInvalid  = errors.New("sign in code is invalid")
+	errAuthFactorExpired  = errors.New("sign in code has expired")
+)
+
+// checks the second factor for an account that has one turned on, after its password has already been checked.
+// an authenticator app takes the place of email codes once it's set up. with no token, errTotpRequired or
+// errAuthFactorRequired (after emailing a fresh code) is returned, so the client can ask the user for the code
+// and try again
+func (s *Server) checkAuthFactor(urepo *models.RepoActor, token string) error {
+	token = strings.ToUpper(strings.TrimSpace(token))
+
+	if urepo.TotpEnabledAt != nil {
+		if token == "" {
+			return errTotpRequired
+		}
+
+		ok, err := s.checkTotpOrRecoveryCode(urepo, token)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return errAuthFactorInvalid
+		}
+
+		return nil
+	}
+
+	if !urepo.EmailAuthFactor {
+		return nil
+	}
+
+	if token == "" {
+		code := fmt.Sprintf("%s-%s", helpers.RandomVarchar(5), helpers.RandomVarchar(5))
+		eat := time.Now().Add(authFactorCodeExpiry).UTC()
+
+		if err := s.db.Exec("UPDATE repos SET auth_factor_code = ?, auth_factor_code_expires_at = ? WHERE did = ?", code, eat, urepo.Repo.Did).Error; err != nil {
+			return err
+		}
+
+		if err := s.sendAuthFactorCode(urepo.Email, urepo.Handle, code); err != nil {
+			return err
+		}
+
+		return errAuthFactorRequired
+	}
+
+	if urepo.AuthFactorCode == nil || urepo.AuthFactorCodeExpiresAt == nil {
+		return errAuthFactorInvalid
+	}
+
+	if subtle.ConstantTimeCompare([]byte(*urepo.AuthFactorCode), []byte(token)) != 1 {
+		return errAuthFactorInvalid
+	}
+
+	if time.Now().UTC().After(*urepo.AuthFactorCodeExpiresAt) {
+		return errAuthFactorExpired
+	}
+
+	if err := s.db.Exec("UPDATE repos SET auth_factor_code = NULL, auth_factor_code_expires_at = NULL WHERE did = ?", urepo.Repo.Did).Error; err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func isAuthFactorError(err error) bool {
+	return errors.Is(err, errAuthFactorRequired) || errors.Is(err, errTotpRequired) || errors.Is(err, errAuthFactorInvalid) || errors.Is(err, errAuthFactorExpired)
+}
@@ -1,6 +1,9 @@
 package server
 
 import (
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/haileyok/cocoon/models"
 )
 
@@ -35,3 +38,24 @@ func (s *Server) getRepoActorByDid(did string) (*models.RepoActor, error) {
 	}
 	return &repo, nil
 }
+
+// identifier is whatever someone typed into a login form: a did, a handle or an email address
+func (s *Server) getRepoActorByIdentifier(identifier string) (*models.RepoActor, error) {
+	identifier = strings.ToLower(identifier)
+
+	var repo models.RepoActor
+	var err error
+	if _, perr := syntax.ParseDID(identifier); perr == nil {
+		err = s.db.Raw("SELECT r.*, a.* FROM repos r LEFT JOIN actors a ON r.did = a.did WHERE r.did = ?", identifier).Scan(&repo).Error
+	} else if _, perr := syntax.ParseHandle(identifier); perr == nil {
+		err = s.db.Raw("SELECT r.*, a.* FROM actors a LEFT JOIN repos r ON a.did = r.did WHERE a.handle = ?", identifier).Scan(&repo).Error
+	} else {
+		err = s.db.Raw("SELECT r.*, a.* FROM repos r LEFT JOIN actors a ON r.did = a.did WHERE r.email = ?", identifier).Scan(&repo).Error
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &repo, nil
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/haileyok/cocoon/identity"
+	"github.com/haileyok/cocoon/models"
+)
+
+// did:webs under one of our user domains have their did document served by us, the same way we serve
+// atproto-did for handles under them
+func (s *Server) isLocalDidWeb(did string) bool {
+	domain, ok := strings.CutPrefix(did, "did:web:")
+	if !ok || strings.Contains(domain, ":") {
+		return false
+	}
+
+	return s.isLocalHandle(domain)
+}
+
+func (s *Server) didWebDocument(urepo *models.RepoActor) (*identity.DidDoc, error) {
+	k, err := crypto.ParsePrivateBytesK256(urepo.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+
+	pubsigkey, err := k.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	did := urepo.Repo.Did
+
+	return &identity.DidDoc{
+		Context: []string{
+			"https://www.w3.org/ns/did/v1",
+			"https://w3id.org/security/multikey/v1",
+			"https://w3id.org/security/suites/secp256k1-2019/v1",
+		},
+		Id:          did,
+		AlsoKnownAs: []string{"at://" + urepo.Handle},
+		VerificationMethods: []identity.DidDocVerificationMethod{
+			{
+				Id:                 did + "#atproto",
+				Type:               "Multikey",
+				Controller:         did,
+				PublicKeyMultibase: pubsigkey.Multibase(),
+			},
+		},
+		Service: []identity.DidDocService{
+			{
+				Id:              "#atproto_pds",
+				Type:            "AtprotoPersonalDataServer",
+				ServiceEndpoint: "https://" + s.config.Hostname,
+			},
+		},
+	}, nil
+}
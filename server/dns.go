@@ -0,0 +1,237 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/haileyok/cocoon/models"
+	"github.com/miekg/dns"
+)
+
+const dnsRecordTtl = 60
+
+// an authoritative dns server for our user domains. it serves the _atproto TXT records of every handle,
+// which are looked up in the actors table on every query so handle changes show up immediately. every other
+// name in the zones points at cocoon like a wildcard record would, so handles still resolve for well-known
+type HandleDNS struct {
+	s       *Server
+	servers []*dns.Server
+	ips     []net.IP
+}
+
+// ips are the addresses that names in our zones resolve to. if there are none, the addresses that our
+// hostname resolves to right now are used
+func NewHandleDNS(s *Server, addr string, ips []string) (*HandleDNS, error) {
+	hd := &HandleDNS{s: s}
+
+	for _, ipstr := range ips {
+		ip := net.ParseIP(strings.TrimSpace(ipstr))
+		if ip == nil {
+			return nil, fmt.Errorf("invalid dns address %q", ipstr)
+		}
+		hd.ips = append(hd.ips, ip)
+	}
+
+	if len(hd.ips) == 0 {
+		found, err := net.LookupIP(s.config.Hostname)
+		if err != nil {
+			return nil, fmt.Errorf("could not look up the addresses of %s for dns, set them with COCOON_DNS_ADDRESSES: %w", s.config.Hostname, err)
+		}
+		hd.ips = found
+	}
+
+	mux := dns.NewServeMux()
+	for _, domain := range s.config.UserDomains {
+		mux.HandleFunc(dns.Fqdn(strings.TrimPrefix(domain, ".")), hd.handleQuery)
+	}
+	mux.HandleFunc(".", func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeRefused)
+		w.WriteMsg(m)
+	})
+
+	for _, net := range []string{"udp", "tcp"} {
+		hd.servers = append(hd.servers, &dns.Server{
+			Addr:    addr,
+			Net:     net,
+			Handler: mux,
+		})
+	}
+
+	return hd, nil
+}
+
+func (hd *HandleDNS) Start() error {
+	errs := make(chan error, len(hd.servers))
+
+	for _, srv := range hd.servers {
+		started := make(chan struct{})
+		srv.NotifyStartedFunc = func() { close(started) }
+
+		go func() {
+			if err := srv.ListenAndServe(); err != nil {
+				errs <- fmt.Errorf("dns server (%s) failed: %w", srv.Net, err)
+			}
+		}()
+
+		select {
+		case <-started:
+		case err := <-errs:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (hd *HandleDNS) Shutdown(ctx context.Context) error {
+	for _, srv := range hd.servers {
+		if err := srv.ShutdownContext(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the most specific of our zones that name is in, so nested user domains each get their own zone
+func (hd *HandleDNS) zoneFor(name string) (string, bool) {
+	var found string
+	for _, domain := range hd.s.config.UserDomains {
+		zone := dns.Fqdn(strings.TrimPrefix(domain, "."))
+		if dns.IsSubDomain(zone, name) && len(zone) > len(found) {
+			found = zone
+		}
+	}
+	return found, found != ""
+}
+
+func (hd *HandleDNS) soa(zone string) dns.RR {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: dnsRecordTtl},
+		Ns:      dns.Fqdn(hd.s.config.Hostname),
+		Mbox:    "hostmaster." + zone,
+		Serial:  uint32(time.Now().Unix()),
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  dnsRecordTtl,
+	}
+}
+
+func (hd *HandleDNS) ns(zone string) dns.RR {
+	return &dns.NS{
+		Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: dnsRecordTtl},
+		Ns:  dns.Fqdn(hd.s.config.Hostname),
+	}
+}
+
+// the A and AAAA records for name that match qtype
+func (hd *HandleDNS) addresses(name string, qtype uint16) []dns.RR {
+	var rrs []dns.RR
+	for _, ip := range hd.ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			if qtype == dns.TypeA || qtype == dns.TypeANY {
+				rrs = append(rrs, &dns.A{
+					Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: dnsRecordTtl},
+					A:   ip4,
+				})
+			}
+		} else if qtype == dns.TypeAAAA || qtype == dns.TypeANY {
+			rrs = append(rrs, &dns.AAAA{
+				Hdr:  dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: dnsRecordTtl},
+				AAAA: ip,
+			})
+		}
+	}
+	return rrs
+}
+
+func (hd *HandleDNS) handleQuery(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+
+	if len(r.Question) != 1 {
+		m.SetRcode(r, dns.RcodeFormatError)
+		w.WriteMsg(m)
+		return
+	}
+
+	q := r.Question[0]
+	name := strings.ToLower(q.Name)
+
+	zone, ok := hd.zoneFor(name)
+	if !ok {
+		m.SetRcode(r, dns.RcodeRefused)
+		w.WriteMsg(m)
+		return
+	}
+
+	if name == zone {
+		switch q.Qtype {
+		case dns.TypeSOA:
+			m.Answer = append(m.Answer, hd.soa(zone))
+		case dns.TypeNS:
+			m.Answer = append(m.Answer, hd.ns(zone))
+		case dns.TypeANY:
+			m.Answer = append(m.Answer, hd.soa(zone), hd.ns(zone))
+			m.Answer = append(m.Answer, hd.addresses(q.Name, q.Qtype)...)
+		default:
+			m.Answer = append(m.Answer, hd.addresses(q.Name, q.Qtype)...)
+		}
+
+		// our own hostname is usually inside the zone, so resolvers need its addresses along with the NS record
+		if q.Qtype == dns.TypeNS || q.Qtype == dns.TypeANY {
+			if _, ok := hd.zoneFor(dns.Fqdn(strings.ToLower(hd.s.config.Hostname))); ok {
+				m.Extra = append(m.Extra, hd.addresses(dns.Fqdn(hd.s.config.Hostname), dns.TypeANY)...)
+			}
+		}
+
+		if len(m.Answer) == 0 {
+			m.Ns = append(m.Ns, hd.soa(zone))
+		}
+		w.WriteMsg(m)
+		return
+	}
+
+	// everything that isn't an _atproto record points at us, so that handles and our own hostname resolve
+	if !strings.HasPrefix(name, "_atproto.") {
+		m.Answer = append(m.Answer, hd.addresses(q.Name, q.Qtype)...)
+		if len(m.Answer) == 0 {
+			m.Ns = append(m.Ns, hd.soa(zone))
+		}
+		w.WriteMsg(m)
+		return
+	}
+
+	handle := strings.TrimSuffix(strings.TrimPrefix(name, "_atproto."), ".")
+
+	var actor models.Actor
+	if err := hd.s.db.Raw("SELECT * FROM actors WHERE handle = ?", handle).Scan(&actor).Error; err != nil {
+		hd.s.logger.Error("error looking up actor for dns query", "handle", handle, "error", err)
+		m.SetRcode(r, dns.RcodeServerFailure)
+		w.WriteMsg(m)
+		return
+	}
+
+	if actor.Did == "" {
+		m.SetRcode(r, dns.RcodeNameError)
+		m.Ns = append(m.Ns, hd.soa(zone))
+		w.WriteMsg(m)
+		return
+	}
+
+	if q.Qtype == dns.TypeTXT || q.Qtype == dns.TypeANY {
+		m.Answer = append(m.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: dnsRecordTtl},
+			Txt: []string{"did=" + actor.Did},
+		})
+	} else {
+		m.Ns = append(m.Ns, hd.soa(zone))
+	}
+
+	w.WriteMsg(m)
+}
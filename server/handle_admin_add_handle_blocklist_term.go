@@ -0,0 +1,52 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm/clause"
+)
+
+type CocoonAdminAddHandleBlocklistTermRequest struct {
+	Term   string  `json:"term" validate:"required"`
+	Reason *string `json:"reason,omitempty"`
+}
+
+// terms are matched against handles with separators removed, so store them the same way
+func normalizeBlocklistTerm(term string) string {
+	return strings.NewReplacer("-", "", ".", "", " ", "").Replace(strings.ToLower(term))
+}
+
+func (s *Server) handleAdminAddHandleBlocklistTerm(e echo.Context) error {
+	var req CocoonAdminAddHandleBlocklistTermRequest
+	if err := e.Bind(&req); err != nil {
+		s.logger.Error("error binding", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := e.Validate(req); err != nil {
+		return helpers.InputError(e, nil)
+	}
+
+	term := normalizeBlocklistTerm(req.Term)
+	if term == "" {
+		return helpers.InputError(e, nil)
+	}
+
+	if err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "term"}},
+		DoUpdates: clause.AssignmentColumns([]string{"reason"}),
+	}).Create(&models.HandleBlocklistEntry{
+		Term:      term,
+		Reason:    req.Reason,
+		CreatedAt: time.Now(),
+	}).Error; err != nil {
+		s.logger.Error("error adding handle blocklist term", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	return e.NoContent(200)
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+)
+
+type CocoonAdminAddProxyUpstreamRequest struct {
+	Service  string `json:"service" validate:"required"`
+	Url      string `json:"url" validate:"required"`
+	Priority int    `json:"priority"`
+}
+
+type CocoonAdminAddProxyUpstreamResponse struct {
+	Id uint `json:"id"`
+}
+
+func (s *Server) handleAdminAddProxyUpstream(e echo.Context) error {
+	var req CocoonAdminAddProxyUpstreamRequest
+	if err := e.Bind(&req); err != nil {
+		s.logger.Error("error binding", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := e.Validate(req); err != nil {
+		return helpers.InputError(e, nil)
+	}
+
+	if _, _, err := parseProxyService(req.Service); err != nil {
+		return helpers.InputErrorWithMessage(e, "InvalidRequest", err.Error())
+	}
+
+	u, err := url.Parse(req.Url)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return helpers.InputErrorWithMessage(e, "InvalidRequest", "url must be an http or https url")
+	}
+
+	upstream := models.ProxyUpstream{
+		Service:   req.Service,
+		Url:       req.Url,
+		Priority:  req.Priority,
+		CreatedAt: time.Now(),
+	}
+
+	if err := s.db.Create(&upstream).Error; err != nil {
+		s.logger.Error("error adding proxy upstream", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := s.upstreams.Load(); err != nil {
+		s.logger.Error("error reloading proxy upstreams", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	return e.JSON(200, CocoonAdminAddProxyUpstreamResponse{
+		Id: upstream.ID,
+	})
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+)
+
+type CocoonAdminApproveWaitlistEntryRequest struct {
+	Email string `json:"email" validate:"required"`
+}
+
+type CocoonAdminApproveWaitlistEntryResponse struct {
+	Code string `json:"code"`
+}
+
+func (s *Server) handleAdminApproveWaitlistEntry(e echo.Context) error {
+	var req CocoonAdminApproveWaitlistEntryRequest
+	if err := e.Bind(&req); err != nil {
+		s.logger.Error("error binding", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := e.Validate(req); err != nil {
+		return helpers.InputError(e, nil)
+	}
+
+	var entry models.WaitlistEntry
+	if err := s.db.Raw("SELECT * FROM waitlist_entries WHERE email = ?", strings.ToLower(req.Email)).Scan(&entry).Error; err != nil {
+		s.logger.Error("error getting waitlist entry", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if entry.Email == "" {
+		return helpers.InputError(e, to.StringPtr("NotFound"))
+	}
+
+	if entry.InviteCode != nil {
+		return e.JSON(200, CocoonAdminApproveWaitlistEntryResponse{
+			Code: *entry.InviteCode,
+		})
+	}
+
+	ic := s.newInviteCodeModel(newInviteCode(), "admin", "admin", 1)
+	if err := s.db.Create(&ic).Error; err != nil {
+		s.logger.Error("error creating invite code", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := s.db.Exec("UPDATE waitlist_entries SET approved_at = ?, invite_code = ? WHERE email = ?", time.Now(), ic.Code, entry.Email).Error; err != nil {
+		s.logger.Error("error approving waitlist entry", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if s.mail != nil {
+		go func() {
+			if err := s.sendWaitlistApproval(entry.Email, ic.Code); err != nil {
+				s.logger.Error("error sending waitlist approval email", "error", err)
+			}
+		}()
+	}
+
+	return e.JSON(200, CocoonAdminApproveWaitlistEntryResponse{
+		Code: ic.Code,
+	})
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/labstack/echo/v4"
+)
+
+type ComAtprotoAdminDeleteAccountRequest struct {
+	Did string `json:"did" validate:"required,atproto-did"`
+}
+
+func (s *Server) handleAdminDeleteAccount(e echo.Context) error {
+	var req ComAtprotoAdminDeleteAccountRequest
+	if err := e.Bind(&req); err != nil {
+		s.logger.Error("error binding", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := e.Validate(req); err != nil {
+		return helpers.InputError(e, nil)
+	}
+
+	urepo, err := s.getRepoActorByDid(req.Did)
+	if err != nil {
+		s.logger.Error("error looking up repo", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if urepo.Repo.Did == "" {
+		return helpers.InputError(e, to.StringPtr("AccountNotFound"))
+	}
+
+	if err := s.deleteAccount(e.Request().Context(), urepo.Repo.Did); err != nil {
+		s.logger.Error("error deleting account", "did", urepo.Repo.Did, "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	return e.NoContent(200)
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/labstack/echo/v4"
+)
+
+type ComAtprotoAdminDisableAccountInvitesRequest struct {
+	Account string  `json:"account" validate:"required,atproto-did"`
+	Note    *string `json:"note,omitempty"`
+}
+
+func (s *Server) handleAdminDisableAccountInvites(e echo.Context) error {
+	var req ComAtprotoAdminDisableAccountInvitesRequest
+	if err := e.Bind(&req); err != nil {
+		s.logger.Error("error binding", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := e.Validate(req); err != nil {
+		return helpers.InputError(e, nil)
+	}
+
+	res := s.db.Exec("UPDATE repos SET invites_disabled = true, invite_note = ? WHERE did = ?", req.Note, req.Account)
+	if res.Error != nil {
+		s.logger.Error("error disabling account invites", "error", res.Error)
+		return helpers.ServerError(e, nil)
+	}
+
+	if res.RowsAffected == 0 {
+		return helpers.InputError(e, to.StringPtr("AccountNotFound"))
+	}
+
+	if err := s.db.Exec("UPDATE invite_codes SET disabled = true WHERE did = ?", req.Account).Error; err != nil {
+		s.logger.Error("error disabling invite codes", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	return e.NoContent(200)
+}
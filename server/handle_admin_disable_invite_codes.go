@@ -0,0 +1,35 @@
+package server
+
+import (
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/labstack/echo/v4"
+)
+
+type ComAtprotoAdminDisableInviteCodesRequest struct {
+	Codes    []string `json:"codes,omitempty"`
+	Accounts []string `json:"accounts,omitempty"`
+}
+
+func (s *Server) handleAdminDisableInviteCodes(e echo.Context) error {
+	var req ComAtprotoAdminDisableInviteCodesRequest
+	if err := e.Bind(&req); err != nil {
+		s.logger.Error("error binding", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if len(req.Codes) > 0 {
+		if err := s.db.Exec("UPDATE invite_codes SET disabled = true WHERE code IN ?", req.Codes).Error; err != nil {
+			s.logger.Error("error disabling invite codes", "error", err)
+			return helpers.ServerError(e, nil)
+		}
+	}
+
+	if len(req.Accounts) > 0 {
+		if err := s.db.Exec("UPDATE invite_codes SET disabled = true WHERE did IN ?", req.Accounts).Error; err != nil {
+			s.logger.Error("error disabling invite codes", "error", err)
+			return helpers.ServerError(e, nil)
+		}
+	}
+
+	return e.NoContent(200)
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/labstack/echo/v4"
+)
+
+type ComAtprotoAdminEnableAccountInvitesRequest struct {
+	Account string  `json:"account" validate:"required,atproto-did"`
+	Note    *string `json:"note,omitempty"`
+}
+
+func (s *Server) handleAdminEnableAccountInvites(e echo.Context) error {
+	var req ComAtprotoAdminEnableAccountInvitesRequest
+	if err := e.Bind(&req); err != nil {
+		s.logger.Error("error binding", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := e.Validate(req); err != nil {
+		return helpers.InputError(e, nil)
+	}
+
+	res := s.db.Exec("UPDATE repos SET invites_disabled = false, invite_note = ? WHERE did = ?", req.Note, req.Account)
+	if res.Error != nil {
+		s.logger.Error("error enabling account invites", "error", res.Error)
+		return helpers.ServerError(e, nil)
+	}
+
+	if res.RowsAffected == 0 {
+		return helpers.InputError(e, to.StringPtr("AccountNotFound"))
+	}
+
+	return e.NoContent(200)
+}
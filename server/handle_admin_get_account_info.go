@@ -0,0 +1,32 @@
+package server
+
+import (
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/labstack/echo/v4"
+)
+
+func (s *Server) handleAdminGetAccountInfo(e echo.Context) error {
+	did := e.QueryParam("did")
+	if did == "" {
+		return helpers.InputError(e, nil)
+	}
+
+	urepo, err := s.getRepoActorByDid(did)
+	if err != nil {
+		s.logger.Error("error looking up repo", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if urepo.Repo.Did == "" {
+		return helpers.InputError(e, to.StringPtr("AccountNotFound"))
+	}
+
+	view, err := s.getAccountView(urepo)
+	if err != nil {
+		s.logger.Error("error getting account view", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	return e.JSON(200, view)
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/labstack/echo/v4"
+)
+
+type ComAtprotoAdminGetAccountInfosResponse struct {
+	Infos []ComAtprotoAdminDefsAccountView `json:"infos"`
+}
+
+func (s *Server) handleAdminGetAccountInfos(e echo.Context) error {
+	dids := e.QueryParams()["dids"]
+	if len(dids) == 0 {
+		return helpers.InputError(e, nil)
+	}
+
+	infos := []ComAtprotoAdminDefsAccountView{}
+	for _, did := range dids {
+		urepo, err := s.getRepoActorByDid(did)
+		if err != nil {
+			s.logger.Error("error looking up repo", "error", err)
+			return helpers.ServerError(e, nil)
+		}
+
+		if urepo.Repo.Did == "" {
+			continue
+		}
+
+		view, err := s.getAccountView(urepo)
+		if err != nil {
+			s.logger.Error("error getting account view", "error", err)
+			return helpers.ServerError(e, nil)
+		}
+
+		infos = append(infos, *view)
+	}
+
+	return e.JSON(200, ComAtprotoAdminGetAccountInfosResponse{
+		Infos: infos,
+	})
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"time"
+
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+)
+
+type CocoonAdminHandleBlocklistTermView struct {
+	Term      string  `json:"term"`
+	Reason    *string `json:"reason,omitempty"`
+	CreatedAt string  `json:"createdAt"`
+}
+
+type CocoonAdminGetHandleBlocklistResponse struct {
+	Terms []CocoonAdminHandleBlocklistTermView `json:"terms"`
+}
+
+func (s *Server) handleAdminGetHandleBlocklist(e echo.Context) error {
+	var terms []models.HandleBlocklistEntry
+	if err := s.db.Raw("SELECT * FROM handle_blocklist_entries ORDER BY term ASC").Scan(&terms).Error; err != nil {
+		s.logger.Error("error getting handle blocklist", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	views := []CocoonAdminHandleBlocklistTermView{}
+	for _, t := range terms {
+		views = append(views, CocoonAdminHandleBlocklistTermView{
+			Term:      t.Term,
+			Reason:    t.Reason,
+			CreatedAt: t.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	return e.JSON(200, CocoonAdminGetHandleBlocklistResponse{
+		Terms: views,
+	})
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"strconv"
+
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+)
+
+type ComAtprotoAdminGetInviteCodesResponse struct {
+	Cursor *string                          `json:"cursor,omitempty"`
+	Codes  []ComAtprotoServerDefsInviteCode `json:"codes"`
+}
+
+func (s *Server) handleAdminGetInviteCodes(e echo.Context) error {
+	limit, err := getLimitFromContext(e, 100)
+	if err != nil {
+		return helpers.InputError(e, nil)
+	}
+
+	// cursor is just an offset here, since "usage" ordering doesn't have a stable key
+	offset := 0
+	if cursor := e.QueryParam("cursor"); cursor != "" {
+		offset, err = strconv.Atoi(cursor)
+		if err != nil {
+			return helpers.InputError(e, nil)
+		}
+	}
+
+	order := "created_at DESC"
+	if e.QueryParam("sort") == "usage" {
+		order = "(SELECT COUNT(*) FROM invite_code_uses u WHERE u.code = invite_codes.code) DESC, created_at DESC"
+	}
+
+	var ics []models.InviteCode
+	if err := s.db.Raw("SELECT * FROM invite_codes ORDER BY "+order+" LIMIT ? OFFSET ?", limit, offset).Scan(&ics).Error; err != nil {
+		s.logger.Error("error getting invite codes", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	codes, err := s.getInviteCodeViews(ics)
+	if err != nil {
+		s.logger.Error("error getting invite code uses", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	var newcursor *string
+	if len(ics) == limit {
+		c := strconv.Itoa(offset + limit)
+		newcursor = &c
+	}
+
+	return e.JSON(200, ComAtprotoAdminGetInviteCodesResponse{
+		Cursor: newcursor,
+		Codes:  codes,
+	})
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"time"
+
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+)
+
+type CocoonAdminProxyUpstreamView struct {
+	Id          uint   `json:"id"`
+	Service     string `json:"service"`
+	Url         string `json:"url"`
+	Priority    int    `json:"priority"`
+	Disabled    bool   `json:"disabled"`
+	Healthy     bool   `json:"healthy"`
+	CircuitOpen bool   `json:"circuitOpen"`
+	CreatedAt   string `json:"createdAt"`
+}
+
+type CocoonAdminGetProxyUpstreamsResponse struct {
+	Upstreams []CocoonAdminProxyUpstreamView `json:"upstreams"`
+}
+
+func (s *Server) handleAdminGetProxyUpstreams(e echo.Context) error {
+	var rows []models.ProxyUpstream
+	if err := s.db.Raw("SELECT * FROM proxy_upstreams ORDER BY service ASC, priority ASC").Scan(&rows).Error; err != nil {
+		s.logger.Error("error getting proxy upstreams", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	views := []CocoonAdminProxyUpstreamView{}
+	for _, row := range rows {
+		status, _ := s.upstreams.Status(row.ID)
+		views = append(views, CocoonAdminProxyUpstreamView{
+			Id:          row.ID,
+			Service:     row.Service,
+			Url:         row.Url,
+			Priority:    row.Priority,
+			Disabled:    row.Disabled,
+			Healthy:     status.Healthy,
+			CircuitOpen: status.CircuitOpen,
+			CreatedAt:   row.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	return e.JSON(200, CocoonAdminGetProxyUpstreamsResponse{
+		Upstreams: views,
+	})
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/ipfs/go-cid"
+	"github.com/labstack/echo/v4"
+)
+
+type ComAtprotoAdminGetSubjectStatusResponse struct {
+	Subject     ComAtprotoAdminSubject         `json:"subject"`
+	Takedown    *ComAtprotoAdminDefsStatusAttr `json:"takedown,omitempty"`
+	Deactivated *ComAtprotoAdminDefsStatusAttr `json:"deactivated,omitempty"`
+}
+
+func (s *Server) handleAdminGetSubjectStatus(e echo.Context) error {
+	did := e.QueryParam("did")
+	uri := e.QueryParam("uri")
+	blob := e.QueryParam("blob")
+
+	switch {
+	case uri != "":
+		aturi, err := syntax.ParseATURI(uri)
+		if err != nil || !aturi.Authority().IsDID() {
+			return helpers.InputError(e, to.StringPtr("InvalidRequest"))
+		}
+
+		var record models.Record
+		if err := s.db.Raw("SELECT * FROM records WHERE did = ? AND nsid = ? AND rkey = ?", aturi.Authority().String(), aturi.Collection().String(), aturi.RecordKey().String()).Scan(&record).Error; err != nil {
+			s.logger.Error("error looking up record", "error", err)
+			return helpers.ServerError(e, nil)
+		}
+
+		if record.Did == "" {
+			return helpers.InputError(e, to.StringPtr("RecordNotFound"))
+		}
+
+		return e.JSON(200, ComAtprotoAdminGetSubjectStatusResponse{
+			Subject: ComAtprotoAdminSubject{
+				Type: AdminSubjectTypeRecord,
+				Uri:  uri,
+				Cid:  record.Cid,
+			},
+			Takedown: takedownAttr(record.TakedownRef),
+		})
+	case blob != "":
+		if did == "" {
+			return helpers.InputError(e, to.StringPtr("InvalidRequest"))
+		}
+
+		c, err := cid.Parse(blob)
+		if err != nil {
+			return helpers.InputError(e, to.StringPtr("InvalidRequest"))
+		}
+
+		var b models.Blob
+		if err := s.db.Raw("SELECT * FROM blobs WHERE did = ? AND cid = ?", did, c.Bytes()).Scan(&b).Error; err != nil {
+			s.logger.Error("error looking up blob", "error", err)
+			return helpers.ServerError(e, nil)
+		}
+
+		if b.ID == 0 {
+			return helpers.InputError(e, to.StringPtr("BlobNotFound"))
+		}
+
+		return e.JSON(200, ComAtprotoAdminGetSubjectStatusResponse{
+			Subject: ComAtprotoAdminSubject{
+				Type: AdminSubjectTypeBlob,
+				Did:  did,
+				Cid:  c.String(),
+			},
+			Takedown: takedownAttr(b.TakedownRef),
+		})
+	case did != "":
+		urepo, err := s.getRepoActorByDid(did)
+		if err != nil {
+			s.logger.Error("error looking up repo", "error", err)
+			return helpers.ServerError(e, nil)
+		}
+
+		if urepo.Repo.Did == "" {
+			return helpers.InputError(e, to.StringPtr("RepoNotFound"))
+		}
+
+		return e.JSON(200, ComAtprotoAdminGetSubjectStatusResponse{
+			Subject: ComAtprotoAdminSubject{
+				Type: AdminSubjectTypeRepo,
+				Did:  urepo.Repo.Did,
+			},
+			Takedown:    takedownAttr(urepo.TakedownRef),
+			Deactivated: deactivatedAttr(&urepo.Repo),
+		})
+	default:
+		return helpers.InputError(e, to.StringPtr("InvalidRequest"))
+	}
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+)
+
+type CocoonAdminGetWaitlistResponse struct {
+	Cursor  *string                        `json:"cursor,omitempty"`
+	Entries []CocoonAdminWaitlistEntryView `json:"entries"`
+}
+
+type CocoonAdminWaitlistEntryView struct {
+	Email      string  `json:"email"`
+	Handle     string  `json:"handle,omitempty"`
+	CreatedAt  string  `json:"createdAt"`
+	ApprovedAt *string `json:"approvedAt,omitempty"`
+}
+
+func (s *Server) handleAdminGetWaitlist(e echo.Context) error {
+	cursor := e.QueryParam("cursor")
+	includeApproved := e.QueryParam("includeApproved") == "true"
+	limit, err := getLimitFromContext(e, 50)
+	if err != nil {
+		return helpers.InputError(e, nil)
+	}
+
+	query := ""
+	params := []any{}
+
+	if !includeApproved {
+		query += " AND approved_at IS NULL"
+	}
+
+	if cursor != "" {
+		t, err := time.Parse(time.RFC3339Nano, cursor)
+		if err != nil {
+			return helpers.InputError(e, nil)
+		}
+		query += " AND created_at > ?"
+		params = append(params, t)
+	}
+	params = append(params, limit)
+
+	var entries []models.WaitlistEntry
+	if err := s.db.Raw("SELECT * FROM waitlist_entries WHERE 1 = 1"+query+" ORDER BY created_at ASC LIMIT ?", params...).Scan(&entries).Error; err != nil {
+		s.logger.Error("error getting waitlist", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	views := []CocoonAdminWaitlistEntryView{}
+	for _, we := range entries {
+		view := CocoonAdminWaitlistEntryView{
+			Email:     we.Email,
+			Handle:    we.Handle,
+			CreatedAt: we.CreatedAt.Format(time.RFC3339Nano),
+		}
+
+		if we.ApprovedAt != nil {
+			view.ApprovedAt = to.StringPtr(we.ApprovedAt.Format(time.RFC3339Nano))
+		}
+
+		views = append(views, view)
+	}
+
+	var newcursor *string
+	if len(entries) == limit {
+		newcursor = to.StringPtr(entries[len(entries)-1].CreatedAt.Format(time.RFC3339Nano))
+	}
+
+	return e.JSON(200, CocoonAdminGetWaitlistResponse{
+		Cursor:  newcursor,
+		Entries: views,
+	})
+}
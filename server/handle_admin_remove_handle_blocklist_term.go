@@ -0,0 +1,29 @@
+package server
+
+import (
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/labstack/echo/v4"
+)
+
+type CocoonAdminRemoveHandleBlocklistTermRequest struct {
+	Term string `json:"term" validate:"required"`
+}
+
+func (s *Server) handleAdminRemoveHandleBlocklistTerm(e echo.Context) error {
+	var req CocoonAdminRemoveHandleBlocklistTermRequest
+	if err := e.Bind(&req); err != nil {
+		s.logger.Error("error binding", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := e.Validate(req); err != nil {
+		return helpers.InputError(e, nil)
+	}
+
+	if err := s.db.Exec("DELETE FROM handle_blocklist_entries WHERE term = ?", normalizeBlocklistTerm(req.Term)).Error; err != nil {
+		s.logger.Error("error removing handle blocklist term", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	return e.NoContent(200)
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/labstack/echo/v4"
+)
+
+type CocoonAdminRemoveProxyUpstreamRequest struct {
+	Id uint `json:"id" validate:"required"`
+}
+
+func (s *Server) handleAdminRemoveProxyUpstream(e echo.Context) error {
+	var req CocoonAdminRemoveProxyUpstreamRequest
+	if err := e.Bind(&req); err != nil {
+		s.logger.Error("error binding", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := e.Validate(req); err != nil {
+		return helpers.InputError(e, nil)
+	}
+
+	if err := s.db.Exec("DELETE FROM proxy_upstreams WHERE id = ?", req.Id).Error; err != nil {
+		s.logger.Error("error removing proxy upstream", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := s.upstreams.Load(); err != nil {
+		s.logger.Error("error reloading proxy upstreams", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	return e.NoContent(200)
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+)
+
+type ComAtprotoAdminSearchAccountsResponse struct {
+	Cursor   *string                          `json:"cursor,omitempty"`
+	Accounts []ComAtprotoAdminDefsAccountView `json:"accounts"`
+}
+
+func (s *Server) handleAdminSearchAccounts(e echo.Context) error {
+	email := e.QueryParam("email")
+	cursor := e.QueryParam("cursor")
+	limit, err := getLimitFromContext(e, 50)
+	if err != nil {
+		return helpers.InputError(e, nil)
+	}
+
+	query := ""
+	params := []any{}
+
+	if email != "" {
+		query += " AND r.email LIKE ?"
+		params = append(params, "%"+email+"%")
+	}
+
+	if cursor != "" {
+		query += " AND r.did > ?"
+		params = append(params, cursor)
+	}
+	params = append(params, limit)
+
+	var repos []models.RepoActor
+	if err := s.db.Raw("SELECT r.*, a.* FROM repos r LEFT JOIN actors a ON r.did = a.did WHERE 1 = 1"+query+" ORDER BY r.did ASC LIMIT ?", params...).Scan(&repos).Error; err != nil {
+		s.logger.Error("error searching accounts", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	accounts := []ComAtprotoAdminDefsAccountView{}
+	for i := range repos {
+		view, err := s.getAccountView(&repos[i])
+		if err != nil {
+			s.logger.Error("error getting account view", "error", err)
+			return helpers.ServerError(e, nil)
+		}
+
+		accounts = append(accounts, *view)
+	}
+
+	var newcursor *string
+	if len(repos) == limit {
+		newcursor = to.StringPtr(repos[len(repos)-1].Repo.Did)
+	}
+
+	return e.JSON(200, ComAtprotoAdminSearchAccountsResponse{
+		Cursor:   newcursor,
+		Accounts: accounts,
+	})
+}
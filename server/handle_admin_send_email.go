@@ -0,0 +1,60 @@
+package server
+
+import (
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/labstack/echo/v4"
+)
+
+type ComAtprotoAdminSendEmailRequest struct {
+	RecipientDid string  `json:"recipientDid" validate:"required,atproto-did"`
+	SenderDid    string  `json:"senderDid" validate:"required"`
+	Content      string  `json:"content" validate:"required"`
+	Subject      *string `json:"subject,omitempty"`
+	Comment      *string `json:"comment,omitempty"`
+}
+
+type ComAtprotoAdminSendEmailResponse struct {
+	Sent bool `json:"sent"`
+}
+
+func (s *Server) handleAdminSendEmail(e echo.Context) error {
+	var req ComAtprotoAdminSendEmailRequest
+	if err := e.Bind(&req); err != nil {
+		s.logger.Error("error binding", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := e.Validate(req); err != nil {
+		return helpers.InputError(e, nil)
+	}
+
+	urepo, err := s.getRepoActorByDid(req.RecipientDid)
+	if err != nil {
+		s.logger.Error("error looking up repo", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if urepo.Repo.Did == "" {
+		return helpers.InputError(e, to.StringPtr("AccountNotFound"))
+	}
+
+	if s.mail == nil {
+		s.logger.Warn("admin tried to send an email but mailing is not configured")
+		return e.JSON(200, ComAtprotoAdminSendEmailResponse{Sent: false})
+	}
+
+	subject := "Message from " + s.config.Hostname
+	if req.Subject != nil && *req.Subject != "" {
+		subject = *req.Subject
+	}
+
+	if err := s.sendAdminEmail(urepo.Email, subject, req.Content); err != nil {
+		s.logger.Error("error sending email", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	s.logger.Info("admin email sent", "recipient", urepo.Repo.Did, "sender", req.SenderDid, "comment", req.Comment)
+
+	return e.JSON(200, ComAtprotoAdminSendEmailResponse{Sent: true})
+}
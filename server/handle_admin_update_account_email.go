@@ -0,0 +1,46 @@
+package server
+
+import (
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type ComAtprotoAdminUpdateAccountEmailRequest struct {
+	Account string `json:"account" validate:"required,atproto-did"`
+	Email   string `json:"email" validate:"required,email"`
+}
+
+func (s *Server) handleAdminUpdateAccountEmail(e echo.Context) error {
+	var req ComAtprotoAdminUpdateAccountEmailRequest
+	if err := e.Bind(&req); err != nil {
+		s.logger.Error("error binding", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := e.Validate(req); err != nil {
+		return helpers.InputError(e, nil)
+	}
+
+	existing, err := s.getRepoByEmail(req.Email)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		s.logger.Error("error looking up email in db", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+	if err == nil && existing.Did != req.Account {
+		return helpers.InputError(e, to.StringPtr("EmailNotAvailable"))
+	}
+
+	res := s.db.Exec("UPDATE repos SET email = ?, email_confirmed_at = NULL, email_update_code = NULL, email_update_code_expires_at = NULL WHERE did = ?", req.Email, req.Account)
+	if res.Error != nil {
+		s.logger.Error("error updating email", "error", res.Error)
+		return helpers.ServerError(e, nil)
+	}
+
+	if res.RowsAffected == 0 {
+		return helpers.InputError(e, to.StringPtr("AccountNotFound"))
+	}
+
+	return e.NoContent(200)
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type ComAtprotoAdminUpdateAccountHandleRequest struct {
+	Did    string `json:"did" validate:"required,atproto-did"`
+	Handle string `json:"handle" validate:"required,atproto-handle"`
+}
+
+func (s *Server) handleAdminUpdateAccountHandle(e echo.Context) error {
+	var req ComAtprotoAdminUpdateAccountHandleRequest
+	if err := e.Bind(&req); err != nil {
+		s.logger.Error("error binding", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	req.Handle = strings.ToLower(req.Handle)
+
+	if err := e.Validate(req); err != nil {
+		return helpers.InputError(e, nil)
+	}
+
+	urepo, err := s.getRepoActorByDid(req.Did)
+	if err != nil {
+		s.logger.Error("error looking up repo", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if urepo.Repo.Did == "" {
+		return helpers.InputError(e, to.StringPtr("AccountNotFound"))
+	}
+
+	if s.isLocalDidWeb(urepo.Repo.Did) && req.Handle != urepo.Handle {
+		return helpers.InputErrorWithMessage(e, "InvalidHandle", "The handle of a did:web hosted here is its domain, and can't be changed")
+	}
+
+	actor, err := s.getActorByHandle(req.Handle)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		s.logger.Error("error looking up handle in db", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+	if err == nil && actor.Did != urepo.Repo.Did {
+		return helpers.InputError(e, to.StringPtr("HandleNotAvailable"))
+	}
+
+	if err := s.updateHandle(e.Request().Context(), urepo, req.Handle); err != nil {
+		s.logger.Error("error updating handle", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	return e.NoContent(200)
+}
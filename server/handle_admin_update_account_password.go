@@ -0,0 +1,48 @@
+package server
+
+import (
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type ComAtprotoAdminUpdateAccountPasswordRequest struct {
+	Did      string `json:"did" validate:"required,atproto-did"`
+	Password string `json:"password" validate:"required"`
+}
+
+func (s *Server) handleAdminUpdateAccountPassword(e echo.Context) error {
+	var req ComAtprotoAdminUpdateAccountPasswordRequest
+	if err := e.Bind(&req); err != nil {
+		s.logger.Error("error binding", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := e.Validate(req); err != nil {
+		return helpers.InputError(e, nil)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), 10)
+	if err != nil {
+		s.logger.Error("error creating hash", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	res := s.db.Exec("UPDATE repos SET password = ?, password_reset_code = NULL, password_reset_code_expires_at = NULL WHERE did = ?", hash, req.Did)
+	if res.Error != nil {
+		s.logger.Error("error updating password", "error", res.Error)
+		return helpers.ServerError(e, nil)
+	}
+
+	if res.RowsAffected == 0 {
+		return helpers.InputError(e, to.StringPtr("AccountNotFound"))
+	}
+
+	if err := s.revokeSessions(req.Did); err != nil {
+		s.logger.Error("error revoking sessions", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	return e.NoContent(200)
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/labstack/echo/v4"
+)
+
+type CocoonAdminUpdateProxyUpstreamRequest struct {
+	Id       uint  `json:"id" validate:"required"`
+	Priority *int  `json:"priority,omitempty"`
+	Disabled *bool `json:"disabled,omitempty"`
+}
+
+// changing priorities or disabling upstreams is how traffic gets shifted between them
+func (s *Server) handleAdminUpdateProxyUpstream(e echo.Context) error {
+	var req CocoonAdminUpdateProxyUpstreamRequest
+	if err := e.Bind(&req); err != nil {
+		s.logger.Error("error binding", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := e.Validate(req); err != nil {
+		return helpers.InputError(e, nil)
+	}
+
+	if req.Priority != nil {
+		if err := s.db.Exec("UPDATE proxy_upstreams SET priority = ? WHERE id = ?", *req.Priority, req.Id).Error; err != nil {
+			s.logger.Error("error updating proxy upstream", "error", err)
+			return helpers.ServerError(e, nil)
+		}
+	}
+
+	if req.Disabled != nil {
+		if err := s.db.Exec("UPDATE proxy_upstreams SET disabled = ? WHERE id = ?", *req.Disabled, req.Id).Error; err != nil {
+			s.logger.Error("error updating proxy upstream", "error", err)
+			return helpers.ServerError(e, nil)
+		}
+	}
+
+	if err := s.upstreams.Load(); err != nil {
+		s.logger.Error("error reloading proxy upstreams", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	return e.NoContent(200)
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/ipfs/go-cid"
+	"github.com/labstack/echo/v4"
+)
+
+type ComAtprotoAdminUpdateSubjectStatusRequest struct {
+	Subject     ComAtprotoAdminSubject         `json:"subject"`
+	Takedown    *ComAtprotoAdminDefsStatusAttr `json:"takedown,omitempty"`
+	Deactivated *ComAtprotoAdminDefsStatusAttr `json:"deactivated,omitempty"`
+}
+
+type ComAtprotoAdminUpdateSubjectStatusResponse struct {
+	Subject  ComAtprotoAdminSubject         `json:"subject"`
+	Takedown *ComAtprotoAdminDefsStatusAttr `json:"takedown,omitempty"`
+}
+
+func (s *Server) handleAdminUpdateSubjectStatus(e echo.Context) error {
+	var req ComAtprotoAdminUpdateSubjectStatusRequest
+	if err := e.Bind(&req); err != nil {
+		s.logger.Error("error binding", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	var takedownRef *string
+	if req.Takedown != nil && req.Takedown.Applied {
+		takedownRef = to.StringPtr(defaultTakedownRef)
+		if req.Takedown.Ref != nil && *req.Takedown.Ref != "" {
+			takedownRef = req.Takedown.Ref
+		}
+	}
+
+	switch req.Subject.Type {
+	case AdminSubjectTypeRepo:
+		urepo, err := s.getRepoActorByDid(req.Subject.Did)
+		if err != nil {
+			s.logger.Error("error looking up repo", "error", err)
+			return helpers.ServerError(e, nil)
+		}
+
+		if urepo.Repo.Did == "" {
+			return helpers.InputError(e, to.StringPtr("RepoNotFound"))
+		}
+
+		if req.Takedown != nil {
+			if err := s.db.Exec("UPDATE repos SET takedown_ref = ? WHERE did = ?", takedownRef, urepo.Repo.Did).Error; err != nil {
+				s.logger.Error("error updating repo takedown", "error", err)
+				return helpers.ServerError(e, nil)
+			}
+
+			if takedownRef != nil {
+				if err := s.revokeSessions(urepo.Repo.Did); err != nil {
+					s.logger.Error("error revoking sessions", "error", err)
+					return helpers.ServerError(e, nil)
+				}
+			}
+
+			urepo.TakedownRef = takedownRef
+		}
+
+		if req.Deactivated != nil && req.Deactivated.Applied != (urepo.DeactivatedAt != nil) {
+			var deactivatedAt *time.Time
+			if req.Deactivated.Applied {
+				now := time.Now().UTC()
+				deactivatedAt = &now
+			}
+
+			if err := s.db.Exec("UPDATE repos SET deactivated_at = ? WHERE did = ?", deactivatedAt, urepo.Repo.Did).Error; err != nil {
+				s.logger.Error("error updating repo deactivation", "error", err)
+				return helpers.ServerError(e, nil)
+			}
+
+			urepo.DeactivatedAt = deactivatedAt
+		}
+
+		if req.Takedown != nil || req.Deactivated != nil {
+			s.sendAccountEvent(urepo.Repo.Did, urepo.Status())
+		}
+
+		return e.JSON(200, ComAtprotoAdminUpdateSubjectStatusResponse{
+			Subject:  req.Subject,
+			Takedown: takedownAttr(urepo.TakedownRef),
+		})
+	case AdminSubjectTypeRecord:
+		aturi, err := syntax.ParseATURI(req.Subject.Uri)
+		if err != nil || !aturi.Authority().IsDID() {
+			return helpers.InputError(e, to.StringPtr("InvalidRequest"))
+		}
+
+		if req.Takedown != nil {
+			res := s.db.Exec("UPDATE records SET takedown_ref = ? WHERE did = ? AND nsid = ? AND rkey = ?", takedownRef, aturi.Authority().String(), aturi.Collection().String(), aturi.RecordKey().String())
+			if res.Error != nil {
+				s.logger.Error("error updating record takedown", "error", res.Error)
+				return helpers.ServerError(e, nil)
+			}
+
+			if res.RowsAffected == 0 {
+				return helpers.InputError(e, to.StringPtr("RecordNotFound"))
+			}
+		}
+
+		return e.JSON(200, ComAtprotoAdminUpdateSubjectStatusResponse{
+			Subject:  req.Subject,
+			Takedown: takedownAttr(takedownRef),
+		})
+	case AdminSubjectTypeBlob:
+		c, err := cid.Parse(req.Subject.Cid)
+		if err != nil {
+			return helpers.InputError(e, to.StringPtr("InvalidRequest"))
+		}
+
+		if req.Takedown != nil {
+			res := s.db.Exec("UPDATE blobs SET takedown_ref = ? WHERE did = ? AND cid = ?", takedownRef, req.Subject.Did, c.Bytes())
+			if res.Error != nil {
+				s.logger.Error("error updating blob takedown", "error", res.Error)
+				return helpers.ServerError(e, nil)
+			}
+
+			if res.RowsAffected == 0 {
+				return helpers.InputError(e, to.StringPtr("BlobNotFound"))
+			}
+		}
+
+		return e.JSON(200, ComAtprotoAdminUpdateSubjectStatusResponse{
+			Subject:  req.Subject,
+			Takedown: takedownAttr(takedownRef),
+		})
+	default:
+		return helpers.InputError(e, to.StringPtr("InvalidRequest"))
+	}
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+)
+
+func (s *Server) handleIdentityGetRecommendedDidCredentials(e echo.Context) error {
+	urepo := e.Get("repo").(*models.RepoActor)
+
+	if !strings.HasPrefix(urepo.Repo.Did, "did:plc:") {
+		return helpers.InputErrorWithMessage(e, "InvalidRequest", "Only did:plc accounts have PLC operations")
+	}
+
+	k, err := crypto.ParsePrivateBytesK256(urepo.SigningKey)
+	if err != nil {
+		s.logger.Error("error parsing signing key", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	creds, err := s.plcClient.RecommendedCredentials(k, urepo.Handle)
+	if err != nil {
+		s.logger.Error("error getting recommended credentials", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	return e.JSON(200, creds)
+}
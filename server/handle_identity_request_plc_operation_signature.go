@@ -0,0 +1,38 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+)
+
+func (s *Server) handleIdentityRequestPlcOperationSignature(e echo.Context) error {
+	urepo := e.Get("repo").(*models.RepoActor)
+
+	if !strings.HasPrefix(urepo.Repo.Did, "did:plc:") {
+		return helpers.InputErrorWithMessage(e, "InvalidRequest", "Only did:plc accounts have PLC operations")
+	}
+
+	if s.rateLimitByAccount(e, urepo.Repo.Did, rateLimitEmailAccount) {
+		return helpers.RateLimitError(e, "Too many emails sent to this account, try again later")
+	}
+
+	code := fmt.Sprintf("%s-%s", helpers.RandomVarchar(5), helpers.RandomVarchar(5))
+	eat := time.Now().Add(10 * time.Minute).UTC()
+
+	if err := s.db.Exec("UPDATE repos SET plc_operation_code = ?, plc_operation_code_expires_at = ? WHERE did = ?", code, eat, urepo.Repo.Did).Error; err != nil {
+		s.logger.Error("error updating repo", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := s.sendPlcOperation(urepo.Email, urepo.Handle, code); err != nil {
+		s.logger.Error("error sending email", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	return e.NoContent(200)
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/haileyok/cocoon/identity"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/haileyok/cocoon/plc"
+	"github.com/labstack/echo/v4"
+)
+
+type ComAtprotoIdentitySignPlcOperationRequest struct {
+	Token               string                               `json:"token" validate:"required"`
+	RotationKeys        []string                             `json:"rotationKeys"`
+	AlsoKnownAs         []string                             `json:"alsoKnownAs"`
+	VerificationMethods map[string]string                    `json:"verificationMethods"`
+	Services            map[string]identity.OperationService `json:"services"`
+}
+
+type ComAtprotoIdentitySignPlcOperationResponse struct {
+	Operation plc.Operation `json:"operation"`
+}
+
+func (s *Server) handleIdentitySignPlcOperation(e echo.Context) error {
+	urepo := e.Get("repo").(*models.RepoActor)
+
+	if !strings.HasPrefix(urepo.Repo.Did, "did:plc:") {
+		return helpers.InputErrorWithMessage(e, "InvalidRequest", "Only did:plc accounts have PLC operations")
+	}
+
+	var req ComAtprotoIdentitySignPlcOperationRequest
+	if err := e.Bind(&req); err != nil {
+		s.logger.Error("error binding", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := e.Validate(req); err != nil {
+		return helpers.InputError(e, nil)
+	}
+
+	if urepo.PlcOperationCode == nil || urepo.PlcOperationCodeExpiresAt == nil {
+		return helpers.InputError(e, to.StringPtr("InvalidToken"))
+	}
+
+	if *urepo.PlcOperationCode != req.Token {
+		return helpers.InputError(e, to.StringPtr("InvalidToken"))
+	}
+
+	if time.Now().UTC().After(*urepo.PlcOperationCodeExpiresAt) {
+		return helpers.InputError(e, to.StringPtr("ExpiredToken"))
+	}
+
+	ctx := e.Request().Context()
+
+	latest, err := s.getLatestPlcOperation(ctx, urepo.Repo.Did)
+	if err != nil {
+		s.logger.Error("error fetching latest plc operation", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	rotationKey, err := s.plcClient.RotationKeyDid()
+	if err != nil {
+		s.logger.Error("error getting rotation key", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if !slices.Contains(latest.Operation.RotationKeys, rotationKey) {
+		return helpers.InputErrorWithMessage(e, "InvalidRequest", "This server's rotation key is no longer a rotation key for your DID, so it can't sign operations for it")
+	}
+
+	// anything the user didn't give us stays the same as it is now
+	op := plc.Operation{
+		Type:                "plc_operation",
+		RotationKeys:        latest.Operation.RotationKeys,
+		AlsoKnownAs:         latest.Operation.AlsoKnownAs,
+		VerificationMethods: latest.Operation.VerificationMethods,
+		Services:            latest.Operation.Services,
+		Prev:                &latest.Cid,
+	}
+
+	if req.RotationKeys != nil {
+		op.RotationKeys = req.RotationKeys
+	}
+
+	if req.AlsoKnownAs != nil {
+		op.AlsoKnownAs = req.AlsoKnownAs
+	}
+
+	if req.VerificationMethods != nil {
+		op.VerificationMethods = req.VerificationMethods
+	}
+
+	if req.Services != nil {
+		op.Services = req.Services
+	}
+
+	k, err := crypto.ParsePrivateBytesK256(urepo.SigningKey)
+	if err != nil {
+		s.logger.Error("error parsing signing key", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := s.plcClient.SignOp(k, &op); err != nil {
+		s.logger.Error("error signing plc operation", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := s.db.Exec("UPDATE repos SET plc_operation_code = NULL, plc_operation_code_expires_at = NULL WHERE did = ?", urepo.Repo.Did).Error; err != nil {
+		s.logger.Error("error updating repo", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	return e.JSON(200, ComAtprotoIdentitySignPlcOperationResponse{
+		Operation: op,
+	})
+}
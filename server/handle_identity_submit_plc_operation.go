@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/util"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/haileyok/cocoon/plc"
+	"github.com/labstack/echo/v4"
+)
+
+type ComAtprotoIdentitySubmitPlcOperationRequest struct {
+	Operation *plc.Operation `json:"operation" validate:"required"`
+}
+
+func (s *Server) handleIdentitySubmitPlcOperation(e echo.Context) error {
+	urepo := e.Get("repo").(*models.RepoActor)
+
+	if !strings.HasPrefix(urepo.Repo.Did, "did:plc:") {
+		return helpers.InputErrorWithMessage(e, "InvalidRequest", "Only did:plc accounts have PLC operations")
+	}
+
+	var req ComAtprotoIdentitySubmitPlcOperationRequest
+	if err := e.Bind(&req); err != nil {
+		s.logger.Error("error binding", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := e.Validate(req); err != nil {
+		return helpers.InputError(e, nil)
+	}
+
+	if err := s.validatePlcOperation(urepo, req.Operation); err != nil {
+		return helpers.InputErrorWithMessage(e, "InvalidRequest", err.Error())
+	}
+
+	ctx := e.Request().Context()
+
+	if err := s.plcClient.SendOperation(ctx, urepo.Repo.Did, req.Operation); err != nil {
+		s.logger.Error("error submitting plc operation", "did", urepo.Repo.Did, "error", err)
+		return helpers.InputErrorWithMessage(e, "InvalidRequest", "PLC rejected the operation")
+	}
+
+	if err := s.passport.BustDoc(ctx, urepo.Repo.Did); err != nil {
+		s.logger.Warn("error busting did doc", "error", err)
+	}
+
+	s.evtman.AddEvent(context.TODO(), &events.XRPCStreamEvent{
+		RepoIdentity: &atproto.SyncSubscribeRepos_Identity{
+			Did:    urepo.Repo.Did,
+			Handle: to.StringPtr(urepo.Handle),
+			Seq:    time.Now().UnixMicro(), // TODO: no
+			Time:   time.Now().Format(util.ISO8601),
+		},
+	})
+
+	return e.NoContent(200)
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"errors"
 	"strings"
 	"time"
 
@@ -10,11 +11,11 @@ import (
 	"github.com/bluesky-social/indigo/atproto/crypto"
 	"github.com/bluesky-social/indigo/events"
 	"github.com/bluesky-social/indigo/util"
-	"github.com/haileyok/cocoon/identity"
 	"github.com/haileyok/cocoon/internal/helpers"
 	"github.com/haileyok/cocoon/models"
 	"github.com/haileyok/cocoon/plc"
 	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
 )
 
 type ComAtprotoIdentityUpdateHandleRequest struct {
@@ -36,16 +37,72 @@ func (s *Server) handleIdentityUpdateHandle(e echo.Context) error {
 		return helpers.InputError(e, nil)
 	}
 
-	ctx := context.WithValue(e.Request().Context(), "skip-cache", true)
+	if err := s.validateHandle(req.Handle); err != nil {
+		var herr *HandleError
+		if errors.As(err, &herr) {
+			return helpers.InputErrorWithMessage(e, herr.Name, herr.Message)
+		}
 
-	if strings.HasPrefix(repo.Repo.Did, "did:plc:") {
-		log, err := identity.FetchDidAuditLog(ctx, nil, repo.Repo.Did)
-		if err != nil {
-			s.logger.Error("error fetching doc", "error", err)
+		s.logger.Error("error validating handle", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	actor, err := s.getActorByHandle(req.Handle)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		s.logger.Error("error looking up handle in db", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+	if err == nil && actor.Did != repo.Repo.Did {
+		return helpers.InputError(e, to.StringPtr("HandleNotAvailable"))
+	}
+
+	if req.Handle == repo.Handle {
+		return nil
+	}
+
+	// our did.json for the did keeps being served from its domain, so that handle can't be handed to someone else
+	if s.isLocalDidWeb(repo.Repo.Did) {
+		return helpers.InputErrorWithMessage(e, "InvalidHandle", "The handle of a did:web hosted here is its domain, and can't be changed")
+	}
+
+	if err := s.checkHandleChangeAllowed(repo.Repo.Did); err != nil {
+		var herr *HandleError
+		if errors.As(err, &herr) {
+			return helpers.RateLimitError(e, herr.Message)
+		}
+
+		s.logger.Error("error checking handle change limits", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if !s.isLocalHandle(req.Handle) {
+		if err := s.verifyExternalHandle(e.Request().Context(), req.Handle, repo.Repo.Did); err != nil {
+			var herr *HandleError
+			if errors.As(err, &herr) {
+				return helpers.InputErrorWithMessage(e, herr.Name, herr.Message)
+			}
+
+			s.logger.Error("error verifying handle", "error", err)
 			return helpers.ServerError(e, nil)
 		}
+	}
+
+	if err := s.updateHandle(e.Request().Context(), repo, req.Handle); err != nil {
+		s.logger.Error("error updating handle", "error", err)
+		return helpers.ServerError(e, nil)
+	}
 
-		latest := log[len(log)-1]
+	return nil
+}
+
+func (s *Server) updateHandle(ctx context.Context, repo *models.RepoActor, handle string) error {
+	ctx = context.WithValue(ctx, "skip-cache", true)
+
+	if strings.HasPrefix(repo.Repo.Did, "did:plc:") {
+		latest, err := s.getLatestPlcOperation(ctx, repo.Repo.Did)
+		if err != nil {
+			return err
+		}
 
 		var newAka []string
 		for _, aka := range latest.Operation.AlsoKnownAs {
@@ -55,7 +112,7 @@ func (s *Server) handleIdentityUpdateHandle(e echo.Context) error {
 			newAka = append(newAka, aka)
 		}
 
-		newAka = append(newAka, "at://"+req.Handle)
+		newAka = append(newAka, "at://"+handle)
 
 		op := plc.Operation{
 			Type:                "plc_operation",
@@ -68,15 +125,14 @@ func (s *Server) handleIdentityUpdateHandle(e echo.Context) error {
 
 		k, err := crypto.ParsePrivateBytesK256(repo.SigningKey)
 		if err != nil {
-			s.logger.Error("error parsing signing key", "error", err)
-			return helpers.ServerError(e, nil)
+			return err
 		}
 
 		if err := s.plcClient.SignOp(k, &op); err != nil {
 			return err
 		}
 
-		if err := s.plcClient.SendOperation(e.Request().Context(), repo.Repo.Did, &op); err != nil {
+		if err := s.plcClient.SendOperation(ctx, repo.Repo.Did, &op); err != nil {
 			return err
 		}
 	}
@@ -84,7 +140,7 @@ func (s *Server) handleIdentityUpdateHandle(e echo.Context) error {
 	s.evtman.AddEvent(context.TODO(), &events.XRPCStreamEvent{
 		RepoHandle: &atproto.SyncSubscribeRepos_Handle{
 			Did:    repo.Repo.Did,
-			Handle: req.Handle,
+			Handle: handle,
 			Seq:    time.Now().UnixMicro(), // TODO: no
 			Time:   time.Now().Format(util.ISO8601),
 		},
@@ -93,15 +149,25 @@ func (s *Server) handleIdentityUpdateHandle(e echo.Context) error {
 	s.evtman.AddEvent(context.TODO(), &events.XRPCStreamEvent{
 		RepoIdentity: &atproto.SyncSubscribeRepos_Identity{
 			Did:    repo.Repo.Did,
-			Handle: to.StringPtr(req.Handle),
+			Handle: to.StringPtr(handle),
 			Seq:    time.Now().UnixMicro(), // TODO: no
 			Time:   time.Now().Format(util.ISO8601),
 		},
 	})
 
-	if err := s.db.Exec("UPDATE actors SET handle = ? WHERE did = ?", req.Handle, repo.Repo.Did).Error; err != nil {
-		s.logger.Error("error updating handle in db", "error", err)
-		return helpers.ServerError(e, nil)
+	if err := s.db.Exec("UPDATE actors SET handle = ? WHERE did = ?", handle, repo.Repo.Did).Error; err != nil {
+		return err
+	}
+
+	if err := s.passport.BustDoc(ctx, repo.Repo.Did); err != nil {
+		return err
+	}
+
+	if err := s.db.Create(&models.HandleChange{
+		Did:    repo.Repo.Did,
+		Handle: handle,
+	}).Error; err != nil {
+		return err
 	}
 
 	return nil
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"crypto/hmac"
+	"errors"
+	"html/template"
+	"net/url"
+	"time"
+
+	"github.com/haileyok/cocoon/models"
+	"github.com/haileyok/cocoon/oauth"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var errOAuthRequestInvalid = errors.New("This sign in request is invalid or has expired.")
+
+// looks up the pending request a page or form is for. forms also have to echo back the request's csrf token
+func (s *Server) loadOAuthAuthorization(e echo.Context, clientId, requestUri string, checkCsrf bool) (*models.OAuthAuthorizationRequest, *oauth.ClientMetadata, error) {
+	req, err := s.getOAuthRequest(requestUri)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if req == nil || req.ClientId != clientId || req.Code != nil {
+		return nil, nil, errOAuthRequestInvalid
+	}
+
+	if checkCsrf && !hmac.Equal([]byte(e.FormValue("csrf")), []byte(req.Csrf)) {
+		return nil, nil, errOAuthRequestInvalid
+	}
+
+	client, err := s.oauthClients.Resolve(e.Request().Context(), clientId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return req, client, nil
+}
+
+func (s *Server) oauthAuthorizationFailed(e echo.Context, err error) error {
+	if errors.Is(err, errOAuthRequestInvalid) {
+		return s.renderOAuthError(e, 400, err.Error())
+	}
+
+	var oerr *oauth.Error
+	if errors.As(err, &oerr) {
+		return s.renderOAuthError(e, 400, "The app you came from has an invalid configuration: "+oerr.Description)
+	}
+
+	s.logger.Error("error loading oauth request", "error", err)
+	return s.renderOAuthError(e, 500, "Something went wrong on our end.")
+}
+
+func oauthAuthorizeUrl(clientId, requestUri string) string {
+	return "/oauth/authorize?" + url.Values{
+		"client_id":   {clientId},
+		"request_uri": {requestUri},
+	}.Encode()
+}
+
+func (s *Server) handleOAuthAuthorize(e echo.Context) error {
+	req, client, err := s.loadOAuthAuthorization(e, e.QueryParam("client_id"), e.QueryParam("request_uri"), false)
+	if err != nil {
+		return s.oauthAuthorizationFailed(e, err)
+	}
+
+	urepo, err := s.getOAuthLogin(e)
+	if err != nil {
+		s.logger.Error("error getting oauth login", "error", err)
+		return s.renderOAuthError(e, 500, "Something went wrong on our end.")
+	}
+
+	if urepo == nil {
+		return s.renderOAuthPage(e, 200, "oauth_login.html", s.newOAuthPageData("Sign in", req, client))
+	}
+
+	data := s.newOAuthPageData("Authorize", req, client)
+	data.Handle = urepo.Handle
+
+	return s.renderOAuthPage(e, 200, "oauth_consent.html", data)
+}
+
+func (s *Server) handleOAuthAuthorizeSignIn(e echo.Context) error {
+	req, client, err := s.loadOAuthAuthorization(e, e.FormValue("client_id"), e.FormValue("request_uri"), true)
+	if err != nil {
+		return s.oauthAuthorizationFailed(e, err)
+	}
+
+	loginFailed := func(status int, msg string) error {
+		data := s.newOAuthPageData("Sign in", req, client)
+		data.LoginHint = e.FormValue("identifier")
+		data.AuthFactor = e.FormValue("code") != ""
+		data.Error = msg
+		return s.renderOAuthPage(e, status, "oauth_login.html", data)
+	}
+
+	if s.rateLimited(e, "ip:"+e.RealIP(), rateLimitLoginIp) {
+		return loginFailed(429, "Too many sign in attempts. Try again later.")
+	}
+
+	urepo, err := s.getRepoActorByIdentifier(e.FormValue("identifier"))
+	if err != nil {
+		s.logger.Error("error looking up repo", "error", err)
+		return s.renderOAuthError(e, 500, "Something went wrong on our end.")
+	}
+
+	account := urepo.Repo.Did
+	if account == "" {
+		account = e.FormValue("identifier")
+	}
+
+	if s.rateLimitByAccount(e, account, rateLimitLoginAccount) {
+		return loginFailed(429, "Too many sign in attempts for this account. Try again later.")
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(urepo.Password), []byte(e.FormValue("password"))); err != nil {
+		if err != bcrypt.ErrMismatchedHashAndPassword {
+			s.logger.Error("erorr comparing hash and password", "error", err)
+		}
+		return loginFailed(400, "Wrong handle, email or password.")
+	}
+
+	if urepo.TakedownRef != nil {
+		return loginFailed(400, "This account has been taken down.")
+	}
+
+	if err := s.checkAuthFactor(urepo, e.FormValue("code")); err != nil {
+		if !isAuthFactorError(err) {
+			s.logger.Error("error checking auth factor", "error", err)
+			return s.renderOAuthError(e, 500, "Something went wrong on our end.")
+		}
+
+		data := s.newOAuthPageData("Sign in", req, client)
+		data.LoginHint = e.FormValue("identifier")
+		data.AuthFactor = true
+
+		if errors.Is(err, errAuthFactorRequired) {
+			data.Notice = "We emailed you a sign in code. Enter it along with your password to continue."
+			return s.renderOAuthPage(e, 200, "oauth_login.html", data)
+		}
+
+		if errors.Is(err, errTotpRequired) {
+			data.Notice = "Enter the code from your authenticator app, or one of your recovery codes, along with your password to continue."
+			return s.renderOAuthPage(e, 200, "oauth_login.html", data)
+		}
+
+		data.Error = "That sign in code is wrong or has expired."
+		return s.renderOAuthPage(e, 400, "oauth_login.html", data)
+	}
+
+	if err := s.setOAuthLoginCookie(e, urepo.Repo.Did); err != nil {
+		s.logger.Error("error setting oauth login cookie", "error", err)
+		return s.renderOAuthError(e, 500, "Something went wrong on our end.")
+	}
+
+	return e.Redirect(303, oauthAuthorizeUrl(req.ClientId, oauthRequestUriPrefix+req.ID))
+}
+
+func (s *Server) handleOAuthAuthorizeSignOut(e echo.Context) error {
+	req, _, err := s.loadOAuthAuthorization(e, e.FormValue("client_id"), e.FormValue("request_uri"), true)
+	if err != nil {
+		return s.oauthAuthorizationFailed(e, err)
+	}
+
+	s.clearOAuthLoginCookie(e)
+
+	return e.Redirect(303, oauthAuthorizeUrl(req.ClientId, oauthRequestUriPrefix+req.ID))
+}
+
+func (s *Server) handleOAuthAuthorizeAccept(e echo.Context) error {
+	req, client, err := s.loadOAuthAuthorization(e, e.FormValue("client_id"), e.FormValue("request_uri"), true)
+	if err != nil {
+		return s.oauthAuthorizationFailed(e, err)
+	}
+
+	urepo, err := s.getOAuthLogin(e)
+	if err != nil {
+		s.logger.Error("error getting oauth login", "error", err)
+		return s.renderOAuthError(e, 500, "Something went wrong on our end.")
+	}
+
+	if urepo == nil {
+		return s.renderOAuthPage(e, 200, "oauth_login.html", s.newOAuthPageData("Sign in", req, client))
+	}
+
+	code := oauth.RandomToken("cod-")
+	if err := s.db.Exec("UPDATE oauth_authorization_requests SET did = ?, code = ?, expires_at = ? WHERE id = ?", urepo.Repo.Did, code, time.Now().Add(oauthCodeExpiry), req.ID).Error; err != nil {
+		s.logger.Error("error saving oauth code", "error", err)
+		return s.renderOAuthError(e, 500, "Something went wrong on our end.")
+	}
+
+	return s.oauthRedirect(e, req, url.Values{
+		"code":  {code},
+		"state": {req.State},
+		"iss":   {s.oauthIssuer()},
+	})
+}
+
+func (s *Server) handleOAuthAuthorizeReject(e echo.Context) error {
+	req, _, err := s.loadOAuthAuthorization(e, e.FormValue("client_id"), e.FormValue("request_uri"), true)
+	if err != nil {
+		return s.oauthAuthorizationFailed(e, err)
+	}
+
+	if err := s.db.Exec("DELETE FROM oauth_authorization_requests WHERE id = ?", req.ID).Error; err != nil {
+		s.logger.Error("error deleting oauth request", "error", err)
+	}
+
+	return s.oauthRedirect(e, req, url.Values{
+		"error":             {"access_denied"},
+		"error_description": {"The user denied the request"},
+		"state":             {req.State},
+		"iss":               {s.oauthIssuer()},
+	})
+}
+
+// sends the user back to the client the way it asked for in its authorization request
+func (s *Server) oauthRedirect(e echo.Context, req *models.OAuthAuthorizationRequest, params url.Values) error {
+	switch req.ResponseMode {
+	case "fragment":
+		return e.Redirect(303, req.RedirectUri+"#"+params.Encode())
+	case "form_post":
+		data := s.newOAuthPageData("Redirecting", nil, nil)
+		data.RedirectUri = template.URL(req.RedirectUri)
+		data.Params = map[string]string{}
+		for k := range params {
+			data.Params[k] = params.Get(k)
+		}
+		return s.renderOAuthPage(e, 200, "oauth_form_post.html", data)
+	default:
+		u, err := url.Parse(req.RedirectUri)
+		if err != nil {
+			return s.renderOAuthError(e, 400, "The app you came from has an invalid redirect uri.")
+		}
+		q := u.Query()
+		for k := range params {
+			q.Set(k, params.Get(k))
+		}
+		u.RawQuery = q.Encode()
+		return e.Redirect(303, u.String())
+	}
+}
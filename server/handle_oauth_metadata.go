@@ -0,0 +1,70 @@
+package server
+
+import (
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/oauth"
+	"github.com/labstack/echo/v4"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+)
+
+func (s *Server) handleOAuthProtectedResource(e echo.Context) error {
+	return e.JSON(200, map[string]any{
+		"resource":                 s.oauthIssuer(),
+		"authorization_servers":    []string{s.oauthIssuer()},
+		"scopes_supported":         oauth.SupportedScopes,
+		"bearer_methods_supported": []string{"header"},
+		"resource_documentation":   "https://atproto.com",
+	})
+}
+
+func (s *Server) handleOAuthAuthorizationServer(e echo.Context) error {
+	issuer := s.oauthIssuer()
+
+	return e.JSON(200, map[string]any{
+		"issuer":                                           issuer,
+		"scopes_supported":                                 oauth.SupportedScopes,
+		"subject_types_supported":                          []string{"public"},
+		"response_types_supported":                         []string{"code"},
+		"response_modes_supported":                         []string{"query", "fragment", "form_post"},
+		"grant_types_supported":                            []string{"authorization_code", "refresh_token"},
+		"code_challenge_methods_supported":                 []string{"S256"},
+		"ui_locales_supported":                             []string{"en-US"},
+		"display_values_supported":                         []string{"page"},
+		"authorization_response_iss_parameter_supported":   true,
+		"request_parameter_supported":                      false,
+		"request_uri_parameter_supported":                  true,
+		"require_request_uri_registration":                 true,
+		"jwks_uri":                                         issuer + "/oauth/jwks",
+		"authorization_endpoint":                           issuer + "/oauth/authorize",
+		"token_endpoint":                                   issuer + "/oauth/token",
+		"token_endpoint_auth_methods_supported":            []string{"none", "private_key_jwt"},
+		"token_endpoint_auth_signing_alg_values_supported": []string{"ES256"},
+		"revocation_endpoint":                              issuer + "/oauth/revoke",
+		"pushed_authorization_request_endpoint":            issuer + "/oauth/par",
+		"require_pushed_authorization_requests":            true,
+		"dpop_signing_alg_values_supported":                []string{"ES256"},
+		"client_id_metadata_document_supported":            true,
+		"protected_resources":                              []string{issuer},
+	})
+}
+
+func (s *Server) handleOAuthJwks(e echo.Context) error {
+	key, err := jwk.FromRaw(&s.privateKey.PublicKey)
+	if err != nil {
+		s.logger.Error("error building jwk", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	key.Set(jwk.AlgorithmKey, jwa.ES256)
+	key.Set(jwk.KeyUsageKey, jwk.ForSignature)
+	if err := jwk.AssignKeyID(key); err != nil {
+		s.logger.Error("error assigning key id", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	set := jwk.NewSet()
+	set.AddKey(key)
+
+	return e.JSON(200, set)
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"time"
+
+	"github.com/haileyok/cocoon/models"
+	"github.com/haileyok/cocoon/oauth"
+	"github.com/labstack/echo/v4"
+)
+
+type OAuthParResponse struct {
+	RequestUri string `json:"request_uri"`
+	ExpiresIn  int64  `json:"expires_in"`
+}
+
+// https://datatracker.ietf.org/doc/html/rfc9126
+func (s *Server) handleOAuthPar(e echo.Context) error {
+	proof, err := s.verifyDpop(e, "")
+	if err != nil {
+		return s.oauthError(e, 400, err)
+	}
+
+	client, err := s.oauthClients.Resolve(e.Request().Context(), e.FormValue("client_id"))
+	if err != nil {
+		return s.oauthError(e, 400, err)
+	}
+
+	clientAuth, err := s.authenticateOAuthClient(e, client)
+	if err != nil {
+		return s.oauthError(e, 401, err)
+	}
+
+	if e.FormValue("request") != "" {
+		return s.oauthError(e, 400, oauth.NewError("request_not_supported", "request objects are not supported"))
+	}
+
+	if e.FormValue("response_type") != "code" {
+		return s.oauthError(e, 400, oauth.NewError("unsupported_response_type", "response_type must be code"))
+	}
+
+	if e.FormValue("code_challenge") == "" || e.FormValue("code_challenge_method") != "S256" {
+		return s.oauthError(e, 400, oauth.NewError("invalid_request", "an S256 code_challenge is required"))
+	}
+
+	if jkt := e.FormValue("dpop_jkt"); jkt != "" && jkt != proof.Jkt {
+		return s.oauthError(e, 400, oauth.NewError("invalid_request", "dpop_jkt does not match the dpop proof"))
+	}
+
+	redirectUri := e.FormValue("redirect_uri")
+	if redirectUri == "" && len(client.RedirectUris) == 1 {
+		redirectUri = client.RedirectUris[0]
+	}
+
+	if !client.AllowsRedirectUri(redirectUri) {
+		return s.oauthError(e, 400, oauth.NewError("invalid_request", "redirect_uri is not registered for this client"))
+	}
+
+	scope := e.FormValue("scope")
+	if !oauth.HasScope(scope, oauth.ScopeAtproto) || !client.AllowsScope(scope) {
+		return s.oauthError(e, 400, oauth.NewError("invalid_scope", "scope must include atproto and only scopes the client registered"))
+	}
+
+	if e.FormValue("state") == "" {
+		return s.oauthError(e, 400, oauth.NewError("invalid_request", "state is required"))
+	}
+
+	responseMode := e.FormValue("response_mode")
+	switch responseMode {
+	case "":
+		responseMode = "query"
+	case "query", "fragment", "form_post":
+	default:
+		return s.oauthError(e, 400, oauth.NewError("invalid_request", "unsupported response_mode"))
+	}
+
+	if err := s.deleteExpiredOAuthRequests(); err != nil {
+		s.logger.Error("error deleting expired oauth requests", "error", err)
+	}
+
+	now := time.Now()
+	req := models.OAuthAuthorizationRequest{
+		ID:            oauth.RandomToken("req-"),
+		ClientId:      client.ClientId,
+		ClientAuth:    clientAuth,
+		DpopJkt:       proof.Jkt,
+		RedirectUri:   redirectUri,
+		Scope:         scope,
+		State:         e.FormValue("state"),
+		ResponseMode:  responseMode,
+		CodeChallenge: e.FormValue("code_challenge"),
+		LoginHint:     e.FormValue("login_hint"),
+		Csrf:          oauth.RandomToken(""),
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(oauthRequestExpiry),
+	}
+
+	if err := s.db.Create(&req).Error; err != nil {
+		s.logger.Error("error creating oauth request", "error", err)
+		return s.oauthError(e, 500, oauth.NewError("server_error", "could not save the request"))
+	}
+
+	e.Response().Header().Set("DPoP-Nonce", s.dpop.Nonce())
+	e.Response().Header().Set("Cache-Control", "no-store")
+
+	return e.JSON(201, OAuthParResponse{
+		RequestUri: oauthRequestUriPrefix + req.ID,
+		ExpiresIn:  int64(oauthRequestExpiry.Seconds()),
+	})
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+)
+
+// https://datatracker.ietf.org/doc/html/rfc7009. accepts either kind of token and ends the whole session
+func (s *Server) handleOAuthRevoke(e echo.Context) error {
+	tokenstr := e.FormValue("token")
+
+	if err := s.db.Exec("DELETE FROM oauth_tokens WHERE refresh_token = ?", tokenstr).Error; err != nil {
+		s.logger.Error("error revoking oauth token", "error", err)
+		return s.oauthError(e, 500, err)
+	}
+
+	// access tokens may already be expired by the time they're revoked, so only the signature is checked
+	token, err := jwt.NewParser(jwt.WithoutClaimsValidation()).Parse(tokenstr, func(t *jwt.Token) (any, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodECDSA); !ok {
+			return nil, fmt.Errorf("unsupported signing method: %v", t.Header["alg"])
+		}
+
+		return s.privateKey.Public(), nil
+	})
+	if err == nil && token.Header["typ"] == "at+jwt" {
+		claims, _ := token.Claims.(jwt.MapClaims)
+		if jti, ok := claims["jti"].(string); ok {
+			if err := s.db.Exec("DELETE FROM oauth_tokens WHERE id = ?", jti).Error; err != nil {
+				s.logger.Error("error revoking oauth token", "error", err)
+				return s.oauthError(e, 500, err)
+			}
+		}
+	}
+
+	return e.NoContent(200)
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"time"
+
+	"github.com/haileyok/cocoon/models"
+	"github.com/haileyok/cocoon/oauth"
+	"github.com/labstack/echo/v4"
+)
+
+func (s *Server) handleOAuthToken(e echo.Context) error {
+	proof, err := s.verifyDpop(e, "")
+	if err != nil {
+		return s.oauthError(e, 400, err)
+	}
+
+	client, err := s.oauthClients.Resolve(e.Request().Context(), e.FormValue("client_id"))
+	if err != nil {
+		return s.oauthError(e, 400, err)
+	}
+
+	clientAuth, err := s.authenticateOAuthClient(e, client)
+	if err != nil {
+		return s.oauthError(e, 401, err)
+	}
+
+	var token *models.OAuthToken
+	switch e.FormValue("grant_type") {
+	case "authorization_code":
+		token, err = s.exchangeOAuthCode(e, client, clientAuth, proof)
+	case "refresh_token":
+		token, err = s.refreshOAuthToken(e, client, clientAuth, proof)
+	default:
+		err = oauth.NewError("unsupported_grant_type", "grant_type must be authorization_code or refresh_token")
+	}
+
+	if err != nil {
+		return s.oauthError(e, 400, err)
+	}
+
+	resp, err := s.issueOAuthAccessToken(token)
+	if err != nil {
+		s.logger.Error("error issuing oauth access token", "error", err)
+		return s.oauthError(e, 500, oauth.NewError("server_error", "could not issue an access token"))
+	}
+
+	e.Response().Header().Set("DPoP-Nonce", s.dpop.Nonce())
+	e.Response().Header().Set("Cache-Control", "no-store")
+
+	return e.JSON(200, resp)
+}
+
+func (s *Server) exchangeOAuthCode(e echo.Context, client *oauth.ClientMetadata, clientAuth string, proof *oauth.DpopProof) (*models.OAuthToken, error) {
+	code := e.FormValue("code")
+	if code == "" {
+		return nil, oauth.NewError("invalid_request", "code is required")
+	}
+
+	// codes only work once, whether or not this exchange succeeds. taking it out in one statement means two
+	// exchanges at once can't both get it
+	var req models.OAuthAuthorizationRequest
+	if err := s.db.Raw("DELETE FROM oauth_authorization_requests WHERE code = ? RETURNING *", code).Scan(&req).Error; err != nil {
+		return nil, err
+	}
+
+	if req.ID == "" || req.Did == nil {
+		return nil, oauth.NewError("invalid_grant", "invalid code")
+	}
+
+	if time.Now().After(req.ExpiresAt) {
+		return nil, oauth.NewError("invalid_grant", "code has expired")
+	}
+
+	if req.ClientId != client.ClientId || req.ClientAuth != clientAuth {
+		return nil, oauth.NewError("invalid_grant", "code was issued to a different client")
+	}
+
+	if req.RedirectUri != e.FormValue("redirect_uri") {
+		return nil, oauth.NewError("invalid_grant", "redirect_uri does not match the authorization request")
+	}
+
+	if req.DpopJkt != proof.Jkt {
+		return nil, oauth.NewError("invalid_dpop_proof", "dpop key does not match the authorization request")
+	}
+
+	if !oauth.VerifyPkce(req.CodeChallenge, "S256", e.FormValue("code_verifier")) {
+		return nil, oauth.NewError("invalid_grant", "invalid code_verifier")
+	}
+
+	expiry := oauthPublicSessionExpiry
+	if client.IsConfidential() {
+		expiry = oauthConfidentialSessionExpiry
+	}
+
+	now := time.Now()
+	token := models.OAuthToken{
+		ID:           oauth.RandomToken("tok-"),
+		Did:          *req.Did,
+		ClientId:     client.ClientId,
+		ClientAuth:   clientAuth,
+		Scope:        req.Scope,
+		DpopJkt:      proof.Jkt,
+		RefreshToken: oauth.RandomToken("ref-"),
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		ExpiresAt:    now.Add(expiry),
+	}
+
+	if err := s.db.Create(&token).Error; err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// refresh tokens rotate on every use, so a stolen one stops working as soon as the real client refreshes
+func (s *Server) refreshOAuthToken(e echo.Context, client *oauth.ClientMetadata, clientAuth string, proof *oauth.DpopProof) (*models.OAuthToken, error) {
+	refresh := e.FormValue("refresh_token")
+	if refresh == "" {
+		return nil, oauth.NewError("invalid_request", "refresh_token is required")
+	}
+
+	var token models.OAuthToken
+	if err := s.db.Raw("SELECT * FROM oauth_tokens WHERE refresh_token = ?", refresh).Scan(&token).Error; err != nil {
+		return nil, err
+	}
+
+	if token.ID == "" || time.Now().After(token.ExpiresAt) {
+		return nil, oauth.NewError("invalid_grant", "invalid refresh token")
+	}
+
+	if token.ClientId != client.ClientId || token.ClientAuth != clientAuth {
+		return nil, oauth.NewError("invalid_grant", "refresh token was issued to a different client")
+	}
+
+	if token.DpopJkt != proof.Jkt {
+		return nil, oauth.NewError("invalid_dpop_proof", "dpop key does not match the session")
+	}
+
+	urepo, err := s.getRepoActorByDid(token.Did)
+	if err != nil {
+		return nil, err
+	}
+
+	if urepo.Repo.Did == "" || urepo.TakedownRef != nil {
+		return nil, oauth.NewError("invalid_grant", "account is not available")
+	}
+
+	token.RefreshToken = oauth.RandomToken("ref-")
+	token.UpdatedAt = time.Now()
+
+	res := s.db.Exec("UPDATE oauth_tokens SET refresh_token = ?, updated_at = ? WHERE id = ? AND refresh_token = ?", token.RefreshToken, token.UpdatedAt, token.ID, refresh)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	// another request rotated this refresh token first
+	if res.RowsAffected == 0 {
+		return nil, oauth.NewError("invalid_grant", "invalid refresh token")
+	}
+
+	return &token, nil
+}
@@ -1,144 +1,176 @@
 package server
 
 import (
-	"crypto/rand"
-	"crypto/sha256"
-	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/haileyok/cocoon/internal/helpers"
 	"github.com/haileyok/cocoon/models"
 	"github.com/labstack/echo/v4"
-	secp256k1secec "gitlab.com/yawning/secp256k1-voi/secec"
 )
 
 func (s *Server) handleProxy(e echo.Context) error {
 	repo, isAuthed := e.Get("repo").(*models.RepoActor)
 
-	pts := strings.Split(e.Request().URL.Path, "/")
-	if len(pts) != 3 {
-		return fmt.Errorf("incorrect number of parts")
+	nsid := strings.TrimPrefix(e.Request().URL.Path, "/xrpc/")
+	if _, err := syntax.ParseNSID(nsid); err != nil {
+		return helpers.InputErrorWithMessage(e, "InvalidRequest", "Invalid method")
 	}
 
-	svc := e.Request().Header.Get("atproto-proxy")
-	if svc == "" {
-		svc = "did:web:api.bsky.app#bsky_appview" // TODO: should be a config var probably
+	if handler, ok := localAppviewMethods[nsid]; ok && s.config.LocalAppview == LocalAppviewOnly {
+		return handler(s, e)
 	}
 
-	svcPts := strings.Split(svc, "#")
-	if len(svcPts) != 2 {
-		return fmt.Errorf("invalid service header")
+	svcDid, svcId, err := s.proxyServiceFor(nsid, e.Request().Header.Get("atproto-proxy"))
+	if err != nil {
+		return s.proxyError(e, err)
 	}
 
-	svcDid := svcPts[0]
-	svcId := "#" + svcPts[1]
+	service := svcDid + svcId
 
-	doc, err := s.passport.FetchDoc(e.Request().Context(), svcDid)
-	if err != nil {
-		return err
-	}
+	var token string
+	if isAuthed {
+		token, err = s.signServiceAuth(repo, svcDid, nsid, time.Now().Add(serviceAuthDefaultExpiry))
+		if err != nil {
+			var serr *ServiceAuthError
+			if errors.As(err, &serr) {
+				return helpers.InputErrorWithMessage(e, serr.Name, serr.Message)
+			}
 
-	var endpoint string
-	for _, s := range doc.Service {
-		if s.Id == svcId {
-			endpoint = s.ServiceEndpoint
+			s.logger.Error("error signing service auth", "error", err)
+			return helpers.ServerError(e, nil)
 		}
 	}
 
-	requrl := e.Request().URL
-	requrl.Host = strings.TrimPrefix(endpoint, "https://")
-	requrl.Scheme = "https"
+	candidates := s.upstreams.Candidates(service)
+	if len(candidates) == 0 {
+		endpoint, err := s.resolveProxyEndpoint(e.Request().Context(), svcDid, svcId)
+		if err != nil {
+			var perr *ProxyError
+			if handler, ok := s.localAppviewFallback(nsid); ok && errors.As(err, &perr) && perr.Name == "UpstreamFailure" {
+				s.logger.Warn("serving request from local appview", "service", service, "method", nsid, "error", err)
+				return handler(s, e)
+			}
+			return s.proxyError(e, err)
+		}
 
-	body := e.Request().Body
-	if e.Request().Method == "GET" {
-		body = nil
-	}
+		resp, err := s.sendProxyRequest(e, service, endpoint, nsid, token)
+		if err != nil {
+			s.logger.Warn("error proxying request", "service", service, "method", nsid, "error", err)
+			if handler, ok := s.localAppviewFallback(nsid); ok {
+				return handler(s, e)
+			}
+			return helpers.UpstreamError(e, "Upstream service unreachable")
+		}
+		defer resp.Body.Close()
 
-	req, err := http.NewRequest(e.Request().Method, requrl.String(), body)
-	if err != nil {
-		return err
+		if handler, ok := s.localAppviewFallback(nsid); ok && resp.StatusCode >= 500 {
+			s.logger.Warn("serving request from local appview", "service", service, "method", nsid, "status", resp.StatusCode)
+			return handler(s, e)
+		}
+
+		return s.writeProxyResponse(e, repo, nsid, resp)
 	}
 
-	req.Header = e.Request().Header.Clone()
+	// a request with a body can only be sent once, so only reads fail over to the next upstream
+	retryable := e.Request().Method == "GET"
 
-	if isAuthed {
-		// this is a little dumb. i should probably figure out a better way to do this, and use
-		// a single way of creating/signing jwts throughout the pds. kinda limited here because
-		// im using the atproto crypto lib for this though. will come back to it
-
-		header := map[string]string{
-			"alg": "ES256K",
-			"crv": "secp256k1",
-			"typ": "JWT",
-		}
-		hj, err := json.Marshal(header)
+	for i, u := range candidates {
+		last := !retryable || i == len(candidates)-1
+
+		resp, err := s.sendProxyRequest(e, service, u.url, nsid, token)
 		if err != nil {
-			s.logger.Error("error marshaling header", "error", err)
-			return helpers.ServerError(e, nil)
+			u.recordResult(false)
+			s.logger.Warn("error proxying request", "service", service, "upstream", u.url.String(), "method", nsid, "error", err)
+			if last {
+				if handler, ok := s.localAppviewFallback(nsid); ok {
+					return handler(s, e)
+				}
+				return helpers.UpstreamError(e, "Upstream service unreachable")
+			}
+			continue
 		}
 
-		encheader := strings.TrimRight(base64.RawURLEncoding.EncodeToString(hj), "=")
-
-		payload := map[string]any{
-			"iss": repo.Repo.Did,
-			"aud": svcDid,
-			"lxm": pts[2],
-			"jti": uuid.NewString(),
-			"exp": time.Now().Add(1 * time.Minute).UTC().Unix(),
+		u.recordResult(resp.StatusCode < 500)
+		if resp.StatusCode >= 500 && !last {
+			resp.Body.Close()
+			continue
 		}
-		pj, err := json.Marshal(payload)
-		if err != nil {
-			s.logger.Error("error marashaling payload", "error", err)
-			return helpers.ServerError(e, nil)
+
+		defer resp.Body.Close()
+
+		if handler, ok := s.localAppviewFallback(nsid); ok && resp.StatusCode >= 500 {
+			s.logger.Warn("serving request from local appview", "service", service, "upstream", u.url.String(), "method", nsid, "status", resp.StatusCode)
+			return handler(s, e)
 		}
 
-		encpayload := strings.TrimRight(base64.RawURLEncoding.EncodeToString(pj), "=")
+		return s.writeProxyResponse(e, repo, nsid, resp)
+	}
 
-		input := fmt.Sprintf("%s.%s", encheader, encpayload)
-		hash := sha256.Sum256([]byte(input))
+	return helpers.UpstreamError(e, "Upstream service unreachable")
+}
 
-		sk, err := secp256k1secec.NewPrivateKey(repo.SigningKey)
-		if err != nil {
-			s.logger.Error("can't load private key", "error", err)
-			return err
-		}
+func (s *Server) writeProxyResponse(e echo.Context, repo *models.RepoActor, nsid string, resp *http.Response) error {
+	copyHeaders(e.Response().Header(), resp.Header, proxyResponseHeaders)
 
-		R, S, _, err := sk.SignRaw(rand.Reader, hash[:])
-		if err != nil {
-			s.logger.Error("error signing", "error", err)
-		}
+	if repo != nil && readAfterWriteMethods[nsid] {
+		return s.readAfterWrite(e, repo, nsid, resp)
+	}
 
-		rBytes := R.Bytes()
-		sBytes := S.Bytes()
+	return e.Stream(resp.StatusCode, resp.Header.Get("content-type"), resp.Body)
+}
 
-		rPadded := make([]byte, 32)
-		sPadded := make([]byte, 32)
-		copy(rPadded[32-len(rBytes):], rBytes)
-		copy(sPadded[32-len(sBytes):], sBytes)
+func (s *Server) sendProxyRequest(e echo.Context, service string, endpoint *url.URL, nsid string, token string) (*http.Response, error) {
+	requrl := *e.Request().URL
+	requrl.Scheme = endpoint.Scheme
+	requrl.Host = endpoint.Host
+	requrl.Path = strings.TrimSuffix(endpoint.Path, "/") + "/xrpc/" + nsid
 
-		rawsig := append(rPadded, sPadded...)
-		encsig := strings.TrimRight(base64.RawURLEncoding.EncodeToString(rawsig), "=")
-		token := fmt.Sprintf("%s.%s", input, encsig)
+	body := e.Request().Body
+	if e.Request().Method == "GET" {
+		body = nil
+	}
+
+	req, err := http.NewRequestWithContext(e.Request().Context(), e.Request().Method, requrl.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.ContentLength = e.Request().ContentLength
+	copyHeaders(req.Header, e.Request().Header, proxyRequestHeaders)
 
+	if token != "" {
 		req.Header.Set("authorization", "Bearer "+token)
-	} else {
-		req.Header.Del("authorization")
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	upstream := endpoint.String()
+	start := time.Now()
+
+	resp, err := s.proxyClient.Do(req)
+	proxyUpstreamDuration.WithLabelValues(service, upstream).Observe(time.Since(start).Seconds())
 	if err != nil {
-		return err
+		proxyUpstreamRequests.WithLabelValues(service, upstream, "error").Inc()
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	for k, v := range resp.Header {
-		e.Response().Header().Set(k, strings.Join(v, ","))
+	proxyUpstreamRequests.WithLabelValues(service, upstream, fmt.Sprintf("%dxx", resp.StatusCode/100)).Inc()
+
+	return resp, nil
+}
+
+func (s *Server) proxyError(e echo.Context, err error) error {
+	var perr *ProxyError
+	if errors.As(err, &perr) {
+		if perr.Name == "UpstreamFailure" {
+			return helpers.UpstreamError(e, perr.Message)
+		}
+		return helpers.InputErrorWithMessage(e, perr.Name, perr.Message)
 	}
 
-	return e.Stream(resp.StatusCode, e.Response().Header().Get("content-type"), resp.Body)
+	return helpers.InputErrorWithMessage(e, "InvalidRequest", err.Error())
 }
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/haileyok/cocoon/internal/helpers"
 	"github.com/haileyok/cocoon/models"
 	"github.com/labstack/echo/v4"
@@ -44,6 +45,10 @@ func (s *Server) handleApplyWrites(e echo.Context) error {
 		return helpers.InputError(e, nil)
 	}
 
+	if !repo.Active() {
+		return helpers.InputError(e, to.StringPtr("AccountDeactivated"))
+	}
+
 	ops := []Op{}
 	for _, item := range req.Writes {
 		ops = append(ops, Op{
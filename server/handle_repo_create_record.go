@@ -1,6 +1,7 @@
 package server
 
 import (
+	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/haileyok/cocoon/internal/helpers"
 	"github.com/haileyok/cocoon/models"
 	"github.com/labstack/echo/v4"
@@ -35,6 +36,10 @@ func (s *Server) handleCreateRecord(e echo.Context) error {
 		return helpers.InputError(e, nil)
 	}
 
+	if !repo.Active() {
+		return helpers.InputError(e, to.StringPtr("AccountDeactivated"))
+	}
+
 	optype := OpTypeCreate
 	if req.SwapRecord != nil {
 		optype = OpTypeUpdate
@@ -1,8 +1,10 @@
 package server
 
 import (
+	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/bluesky-social/indigo/atproto/data"
 	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/haileyok/cocoon/internal/helpers"
 	"github.com/haileyok/cocoon/models"
 	"github.com/labstack/echo/v4"
 )
@@ -19,6 +21,16 @@ func (s *Server) handleRepoGetRecord(e echo.Context) error {
 	rkey := e.QueryParam("rkey")
 	cidstr := e.QueryParam("cid")
 
+	urepo, err := s.getRepoActorByDid(repo)
+	if err != nil {
+		s.logger.Error("error looking up repo", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if status := urepo.Status(); status != nil {
+		return helpers.InputError(e, to.StringPtr(repoStatusError(*status)))
+	}
+
 	params := []any{repo, collection, rkey}
 	cidquery := ""
 
@@ -37,6 +49,10 @@ func (s *Server) handleRepoGetRecord(e echo.Context) error {
 		return err
 	}
 
+	if record.TakedownRef != nil {
+		return helpers.InputError(e, to.StringPtr("RecordNotFound"))
+	}
+
 	val, err := data.UnmarshalCBOR(record.Value)
 	if err != nil {
 		return s.handleProxy(e) // TODO: this should be getting handled like...if we don't find it in the db. why doesn't it throw error up there?
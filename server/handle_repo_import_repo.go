@@ -0,0 +1,26 @@
+package server
+
+import (
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+)
+
+func (s *Server) handleRepoImportRepo(e echo.Context) error {
+	urepo := e.Get("repo").(*models.RepoActor)
+
+	// importing replaces the whole repo, so only allow it while nothing can be writing to it
+	if urepo.Active() {
+		return helpers.InputErrorWithMessage(e, "InvalidRequest", "Repos can only be imported into deactivated accounts")
+	}
+
+	root, rev, err := s.repoman.importRepo(e.Request().Context(), urepo.Repo, e.Request().Body)
+	if err != nil {
+		s.logger.Error("error importing repo", "did", urepo.Repo.Did, "error", err)
+		return helpers.InputErrorWithMessage(e, "InvalidRequest", "Could not import repo: "+err.Error())
+	}
+
+	s.logger.Info("imported repo", "did", urepo.Repo.Did, "root", root.String(), "rev", rev)
+
+	return e.NoContent(200)
+}
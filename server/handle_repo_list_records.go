@@ -47,6 +47,16 @@ func (s *Server) handleListRecords(e echo.Context) error {
 		return helpers.InputError(e, nil)
 	}
 
+	urepo, err := s.getRepoActorByDid(did)
+	if err != nil {
+		s.logger.Error("error looking up repo", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if status := urepo.Status(); status != nil {
+		return helpers.InputError(e, to.StringPtr(repoStatusError(*status)))
+	}
+
 	sort := "DESC"
 	dir := "<"
 	cursorquery := ""
@@ -64,7 +74,7 @@ func (s *Server) handleListRecords(e echo.Context) error {
 	params = append(params, limit)
 
 	var records []models.Record
-	if err := s.db.Raw("SELECT * FROM records WHERE did = ? AND nsid = ? "+cursorquery+" ORDER BY created_at "+sort+" limit ?", params...).Scan(&records).Error; err != nil {
+	if err := s.db.Raw("SELECT * FROM records WHERE did = ? AND nsid = ? AND takedown_ref IS NULL "+cursorquery+" ORDER BY created_at "+sort+" limit ?", params...).Scan(&records).Error; err != nil {
 		s.logger.Error("error getting records", "error", err)
 		return helpers.ServerError(e, nil)
 	}
@@ -29,6 +29,9 @@ type ComAtprotoRepoUploadBlobResponse struct {
 func (s *Server) handleRepoUploadBlob(e echo.Context) error {
 	urepo := e.Get("repo").(*models.RepoActor)
 
+	// deactivated accounts can still upload blobs, since that's how a migrated account brings its blobs
+	// over before it is activated
+
 	mime := e.Request().Header.Get("content-type")
 	if mime == "" {
 		mime = "application/octet-stream"
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/util"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+)
+
+func (s *Server) handleServerActivateAccount(e echo.Context) error {
+	urepo := e.Get("repo").(*models.RepoActor)
+
+	if urepo.DeactivatedAt == nil {
+		return helpers.InputError(e, to.StringPtr("InvalidRequest"))
+	}
+
+	// accounts that were migrated here have no repo until they import one
+	if urepo.Root == nil {
+		return helpers.InputErrorWithMessage(e, "InvalidRequest", "Import your repo with com.atproto.repo.importRepo before activating your account")
+	}
+
+	validDid, err := s.validDid(e.Request().Context(), urepo.Repo.Did, urepo.SigningKey)
+	if err != nil {
+		s.logger.Error("error checking did doc", "did", urepo.Repo.Did, "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if !validDid {
+		return helpers.InputErrorWithMessage(e, "InvalidRequest", "Your DID document must list this server as your PDS with its signing key before your account can be activated")
+	}
+
+	// the account's blobs may have been uploaded after its repo was imported
+	if err := s.repoman.recountBlobRefs(urepo.Repo.Did); err != nil {
+		s.logger.Error("error counting blob refs", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := s.db.Exec("UPDATE repos SET deactivated_at = NULL WHERE did = ?", urepo.Repo.Did).Error; err != nil {
+		s.logger.Error("error activating repo", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	urepo.DeactivatedAt = nil
+
+	s.evtman.AddEvent(context.TODO(), &events.XRPCStreamEvent{
+		RepoIdentity: &atproto.SyncSubscribeRepos_Identity{
+			Did:    urepo.Repo.Did,
+			Handle: to.StringPtr(urepo.Handle),
+			Seq:    time.Now().UnixMicro(), // TODO: no
+			Time:   time.Now().Format(util.ISO8601),
+		},
+	})
+
+	s.sendAccountEvent(urepo.Repo.Did, nil)
+
+	// relays have nothing for the account's repo yet, or only what it looked like on its old pds
+	if err := s.repoman.sendSyncEvent(urepo.Repo); err != nil {
+		s.logger.Error("error sending sync event", "did", urepo.Repo.Did, "error", err)
+	}
+
+	return e.NoContent(200)
+}
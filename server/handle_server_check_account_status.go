@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/ipfs/go-cid"
+	"github.com/labstack/echo/v4"
+)
+
+type ComAtprotoServerCheckAccountStatusResponse struct {
+	Activated          bool   `json:"activated"`
+	ValidDid           bool   `json:"validDid"`
+	RepoCommit         string `json:"repoCommit"`
+	RepoRev            string `json:"repoRev"`
+	RepoBlocks         int64  `json:"repoBlocks"`
+	IndexedRecords     int64  `json:"indexedRecords"`
+	PrivateStateValues int64  `json:"privateStateValues"`
+	ExpectedBlobs      int64  `json:"expectedBlobs"`
+	ImportedBlobs      int64  `json:"importedBlobs"`
+}
+
+func (s *Server) handleServerCheckAccountStatus(e echo.Context) error {
+	urepo := e.Get("repo").(*models.RepoActor)
+
+	resp := ComAtprotoServerCheckAccountStatusResponse{
+		Activated: urepo.Active(),
+		RepoRev:   urepo.Rev,
+	}
+
+	if c, err := cid.Cast(urepo.Root); err == nil {
+		resp.RepoCommit = c.String()
+	}
+
+	validDid, err := s.validDid(e.Request().Context(), urepo.Repo.Did, urepo.SigningKey)
+	if err != nil {
+		s.logger.Warn("error checking did doc", "did", urepo.Repo.Did, "error", err)
+	}
+	resp.ValidDid = validDid
+
+	if err := s.db.Raw("SELECT COUNT(*) FROM blocks WHERE did = ?", urepo.Repo.Did).Scan(&resp.RepoBlocks).Error; err != nil {
+		s.logger.Error("error counting blocks", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := s.db.Raw("SELECT COUNT(*) FROM blobs WHERE did = ? AND cid IS NOT NULL", urepo.Repo.Did).Scan(&resp.ImportedBlobs).Error; err != nil {
+		s.logger.Error("error counting blobs", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	var records []models.Record
+	if err := s.db.Raw("SELECT value FROM records WHERE did = ?", urepo.Repo.Did).Scan(&records).Error; err != nil {
+		s.logger.Error("error getting records", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	resp.IndexedRecords = int64(len(records))
+
+	expected := map[string]struct{}{}
+	for _, r := range records {
+		val, err := data.UnmarshalCBOR(r.Value)
+		if err != nil {
+			continue
+		}
+
+		for _, b := range data.ExtractBlobs(val) {
+			expected[b.Ref.String()] = struct{}{}
+		}
+	}
+	resp.ExpectedBlobs = int64(len(expected))
+
+	var prefs struct {
+		Preferences []any `json:"preferences"`
+	}
+	if err := json.Unmarshal(urepo.Preferences, &prefs); err == nil {
+		resp.PrivateStateValues = int64(len(prefs.Preferences))
+	}
+
+	return e.JSON(200, resp)
+}
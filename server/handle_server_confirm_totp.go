@@ -0,0 +1,69 @@
+package server
+
+import (
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+)
+
+type CocoonServerConfirmTotpRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type CocoonServerTotpRecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+func (s *Server) handleServerConfirmTotp(e echo.Context) error {
+	urepo := e.Get("repo").(*models.RepoActor)
+
+	var req CocoonServerConfirmTotpRequest
+	if err := e.Bind(&req); err != nil {
+		s.logger.Error("error binding", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := e.Validate(req); err != nil {
+		return helpers.InputError(e, nil)
+	}
+
+	if s.rateLimitByAccount(e, urepo.Repo.Did, rateLimitTotpAccount) {
+		return helpers.RateLimitError(e, "Too many authenticator code attempts, try again later")
+	}
+
+	if urepo.TotpEnabledAt != nil {
+		return helpers.InputErrorWithMessage(e, "TotpAlreadyEnabled", "Your authenticator app is already set up.")
+	}
+
+	if len(urepo.TotpSecret) == 0 {
+		return helpers.InputErrorWithMessage(e, "InvalidRequest", "Call cocoon.server.enrollTotp first.")
+	}
+
+	ok, err := s.checkTotpCode(urepo, req.Code)
+	if err != nil {
+		s.logger.Error("error checking totp code", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if !ok {
+		return helpers.InputError(e, to.StringPtr("InvalidToken"))
+	}
+
+	if err := s.db.Exec("UPDATE repos SET totp_enabled_at = ? WHERE did = ?", time.Now().UTC(), urepo.Repo.Did).Error; err != nil {
+		s.logger.Error("error updating repo", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	codes, err := s.createTotpRecoveryCodes(urepo.Repo.Did)
+	if err != nil {
+		s.logger.Error("error creating recovery codes", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	return e.JSON(200, CocoonServerTotpRecoveryCodesResponse{
+		RecoveryCodes: codes,
+	})
+}
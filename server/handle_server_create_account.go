@@ -24,9 +24,9 @@ import (
 type ComAtprotoServerCreateAccountRequest struct {
 	Email      string  `json:"email" validate:"required,email"`
 	Handle     string  `json:"handle" validate:"required,atproto-handle"`
-	Did        *string `json:"did" validate:"atproto-did"`
+	Did        *string `json:"did" validate:"omitempty,atproto-did"`
 	Password   string  `json:"password" validate:"required"`
-	InviteCode string  `json:"inviteCode" validate:"required"`
+	InviteCode string  `json:"inviteCode"`
 }
 
 type ComAtprotoServerCreateAccountResponse struct {
@@ -44,6 +44,10 @@ func (s *Server) handleCreateAccount(e echo.Context) error {
 		return helpers.ServerError(e, nil)
 	}
 
+	if s.config.RegistrationPolicy == RegistrationPolicyClosed {
+		return helpers.InputErrorWithMessage(e, "InvalidRequest", "Account registration is currently closed on this server.")
+	}
+
 	request.Handle = strings.ToLower(request.Handle)
 
 	if err := e.Validate(request); err != nil {
@@ -67,9 +71,63 @@ func (s *Server) handleCreateAccount(e echo.Context) error {
 			if verr.Field == "InviteCode" {
 				return helpers.InputError(e, to.StringPtr("InvalidInviteCode"))
 			}
+
+			if verr.Field == "Did" {
+				return helpers.InputError(e, to.StringPtr("InvalidRequest"))
+			}
+		}
+	}
+
+	var migrating bool
+	if request.Did != nil {
+		authheader := strings.TrimPrefix(e.Request().Header.Get("authorization"), "Bearer ")
+
+		if s.isLocalDidWeb(*request.Did) {
+			// we serve the document for these ourselves, so the did's domain is also the account's handle
+			if request.Handle != strings.TrimPrefix(*request.Did, "did:web:") {
+				return helpers.InputErrorWithMessage(e, "InvalidHandle", "The handle for a did:web under "+strings.Join(s.config.UserDomains, ", ")+" must be the did's domain")
+			}
+		} else {
+			// the did is hosted somewhere else, either on another pds or as a did:web. the caller has to prove
+			// they control it with a service auth token signed by the did's current key, since a did document
+			// that lists us can be read (and raced) by anyone
+			if authheader == "" {
+				return helpers.InputErrorWithMessage(e, "InvalidToken", "A service auth token signed by the DID's current key is required to create an account for "+*request.Did)
+			}
+
+			claims, err := s.verifyServiceAuth(e.Request().Context(), authheader, s.config.Did, "com.atproto.server.createAccount", nil)
+			if err != nil {
+				return helpers.InputErrorWithMessage(e, "InvalidToken", err.Error())
+			}
+
+			if claims.Iss != *request.Did {
+				return helpers.InputErrorWithMessage(e, "InvalidToken", "Service auth token was not issued by "+*request.Did)
+			}
+
+			migrating = true
+		}
+
+		existing, err := s.getRepoActorByDid(*request.Did)
+		if err != nil {
+			s.logger.Error("error looking up repo", "endpoint", "com.atproto.server.createAccount", "error", err)
+			return helpers.ServerError(e, nil)
+		}
+
+		if existing.Repo.Did != "" {
+			return helpers.InputErrorWithMessage(e, "AlreadyExists", "An account with this DID already exists on this server")
 		}
 	}
 
+	if err := s.validateHandle(request.Handle); err != nil {
+		var herr *HandleError
+		if errors.As(err, &herr) {
+			return helpers.InputErrorWithMessage(e, herr.Name, herr.Message)
+		}
+
+		s.logger.Error("error validating handle", "endpoint", "com.atproto.server.createAccount", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
 	// see if the handle is already taken
 	_, err := s.getActorByHandle(request.Handle)
 	if err != nil && err != gorm.ErrRecordNotFound {
@@ -80,23 +138,47 @@ func (s *Server) handleCreateAccount(e echo.Context) error {
 		return helpers.InputError(e, to.StringPtr("HandleNotAvailable"))
 	}
 
-	if did, err := s.passport.ResolveHandle(e.Request().Context(), request.Handle); err == nil && did != "" {
-		return helpers.InputError(e, to.StringPtr("HandleNotAvailable"))
-	}
+	if s.isLocalHandle(request.Handle) {
+		if did, err := s.passport.ResolveHandle(e.Request().Context(), request.Handle); err == nil && did != "" {
+			return helpers.InputError(e, to.StringPtr("HandleNotAvailable"))
+		}
+	} else {
+		// a handle on someone else's domain can only be used if it already points at the did being
+		// brought here, since that's the only way to prove control of the domain before the account exists
+		if request.Did == nil {
+			return helpers.InputErrorWithMessage(e, "UnsupportedDomain", "Handles must be under one of: "+strings.Join(s.config.UserDomains, ", "))
+		}
 
-	var ic models.InviteCode
-	if err := s.db.Raw("SELECT * FROM invite_codes WHERE code = ?", request.InviteCode).Scan(&ic).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return helpers.InputError(e, to.StringPtr("InvalidInviteCode"))
+		if err := s.verifyExternalHandle(e.Request().Context(), request.Handle, *request.Did); err != nil {
+			var herr *HandleError
+			if errors.As(err, &herr) {
+				return helpers.InputErrorWithMessage(e, "HandleNotAvailable", herr.Message)
+			}
+
+			s.logger.Error("error verifying handle", "endpoint", "com.atproto.server.createAccount", "error", err)
+			return helpers.ServerError(e, nil)
 		}
-		s.logger.Error("error getting invite code from db", "error", err)
-		return helpers.ServerError(e, nil)
 	}
 
-	if ic.RemainingUseCount < 1 {
+	if request.InviteCode == "" && s.inviteCodeRequired() {
 		return helpers.InputError(e, to.StringPtr("InvalidInviteCode"))
 	}
 
+	var ic models.InviteCode
+	if request.InviteCode != "" {
+		if err := s.db.Raw("SELECT * FROM invite_codes WHERE code = ?", request.InviteCode).Scan(&ic).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return helpers.InputError(e, to.StringPtr("InvalidInviteCode"))
+			}
+			s.logger.Error("error getting invite code from db", "error", err)
+			return helpers.ServerError(e, nil)
+		}
+
+		if !inviteCodeUsable(&ic) {
+			return helpers.InputError(e, to.StringPtr("InvalidInviteCode"))
+		}
+	}
+
 	// see if the email is already taken
 	_, err = s.getRepoByEmail(request.Email)
 	if err != nil && err != gorm.ErrRecordNotFound {
@@ -107,25 +189,47 @@ func (s *Server) handleCreateAccount(e echo.Context) error {
 		return helpers.InputError(e, to.StringPtr("EmailNotAvailable"))
 	}
 
-	// TODO: unsupported domains
-
-	// TODO: did stuff
-
 	k, err := crypto.GeneratePrivateKeyK256()
 	if err != nil {
 		s.logger.Error("error creating signing key", "endpoint", "com.atproto.server.createAccount", "error", err)
 		return helpers.ServerError(e, nil)
 	}
 
-	did, op, err := s.plcClient.CreateDID(k, "", request.Handle)
-	if err != nil {
-		s.logger.Error("error creating operation", "endpoint", "com.atproto.server.createAccount", "error", err)
-		return helpers.ServerError(e, nil)
-	}
+	var did string
+	var deactivatedAt *time.Time
+	if request.Did != nil {
+		did = *request.Did
+
+		// the migration tool, or whoever wrote the did.json for a did:web, has most likely already put
+		// this key into the did document
+		rk, err := s.getReservedSigningKey(did)
+		if err != nil {
+			s.logger.Error("error getting reserved key", "endpoint", "com.atproto.server.createAccount", "error", err)
+			return helpers.ServerError(e, nil)
+		}
 
-	if err := s.plcClient.SendOperation(e.Request().Context(), did, op); err != nil {
-		s.logger.Error("error sending plc op", "endpoint", "com.atproto.server.createAccount", "error", err)
-		return helpers.ServerError(e, nil)
+		if rk != nil {
+			k = rk
+		}
+
+		if migrating {
+			// these stay deactivated until their did document points here with the account's signing key
+			now := time.Now()
+			deactivatedAt = &now
+		}
+	} else {
+		newDid, op, err := s.plcClient.CreateDID(k, "", request.Handle)
+		if err != nil {
+			s.logger.Error("error creating operation", "endpoint", "com.atproto.server.createAccount", "error", err)
+			return helpers.ServerError(e, nil)
+		}
+
+		if err := s.plcClient.SendOperation(e.Request().Context(), newDid, op); err != nil {
+			s.logger.Error("error sending plc op", "endpoint", "com.atproto.server.createAccount", "error", err)
+			return helpers.ServerError(e, nil)
+		}
+
+		did = newDid
 	}
 
 	hashed, err := bcrypt.GenerateFromPassword([]byte(request.Password), 10)
@@ -141,6 +245,7 @@ func (s *Server) handleCreateAccount(e echo.Context) error {
 		EmailVerificationCode: to.StringPtr(fmt.Sprintf("%s-%s", helpers.RandomVarchar(6), helpers.RandomVarchar(6))),
 		Password:              string(hashed),
 		SigningKey:            k.Bytes(),
+		DeactivatedAt:         deactivatedAt,
 	}
 
 	actor := models.Actor{
@@ -148,54 +253,74 @@ func (s *Server) handleCreateAccount(e echo.Context) error {
 		Handle: request.Handle,
 	}
 
-	if err := s.db.Create(&urepo).Error; err != nil {
-		s.logger.Error("error inserting new repo", "error", err)
-		return helpers.ServerError(e, nil)
-	}
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&urepo).Error; err != nil {
+			return err
+		}
 
-	bs := blockstore.New(did, s.db)
-	r := repo.NewRepo(context.TODO(), did, bs)
+		if err := tx.Create(&actor).Error; err != nil {
+			return err
+		}
 
-	root, rev, err := r.Commit(context.TODO(), urepo.SignFor)
-	if err != nil {
-		s.logger.Error("error committing", "error", err)
-		return helpers.ServerError(e, nil)
-	}
+		if err := tx.Exec("DELETE FROM reserved_keys WHERE did = ?", did).Error; err != nil {
+			return err
+		}
+
+		if request.InviteCode != "" {
+			return useInviteCode(tx, request.InviteCode, did)
+		}
 
-	if err := bs.UpdateRepo(context.TODO(), root, rev); err != nil {
-		s.logger.Error("error updating repo after commit", "error", err)
+		return nil
+	}); err != nil {
+		if errors.Is(err, errInviteCodeUnusable) {
+			return helpers.InputError(e, to.StringPtr("InvalidInviteCode"))
+		}
+
+		s.logger.Error("error inserting new account", "error", err)
 		return helpers.ServerError(e, nil)
 	}
 
-	s.evtman.AddEvent(context.TODO(), &events.XRPCStreamEvent{
-		RepoHandle: &atproto.SyncSubscribeRepos_Handle{
-			Did:    urepo.Did,
-			Handle: request.Handle,
-			Seq:    time.Now().UnixMicro(), // TODO: no
-			Time:   time.Now().Format(util.ISO8601),
-		},
-	})
+	// a did:web that was never on another pds has nothing to import, so it starts with an empty repo like a
+	// new account. importing one later replaces it
+	if !migrating || strings.HasPrefix(did, "did:web:") {
+		bs := blockstore.New(did, s.db)
+		r := repo.NewRepo(context.TODO(), did, bs)
 
-	s.evtman.AddEvent(context.TODO(), &events.XRPCStreamEvent{
-		RepoIdentity: &atproto.SyncSubscribeRepos_Identity{
-			Did:    urepo.Did,
-			Handle: to.StringPtr(request.Handle),
-			Seq:    time.Now().UnixMicro(), // TODO: no
-			Time:   time.Now().Format(util.ISO8601),
-		},
-	})
+		root, rev, err := r.Commit(context.TODO(), urepo.SignFor)
+		if err != nil {
+			s.logger.Error("error committing", "error", err)
+			return helpers.ServerError(e, nil)
+		}
 
-	if err := s.db.Create(&actor).Error; err != nil {
-		s.logger.Error("error inserting new actor", "error", err)
-		return helpers.ServerError(e, nil)
+		if err := bs.UpdateRepo(context.TODO(), root, rev); err != nil {
+			s.logger.Error("error updating repo after commit", "error", err)
+			return helpers.ServerError(e, nil)
+		}
 	}
 
-	if err := s.db.Raw("UPDATE invite_codes SET remaining_use_count = remaining_use_count - 1 WHERE code = ?", request.InviteCode).Scan(&ic).Error; err != nil {
-		s.logger.Error("error decrementing use count", "error", err)
-		return helpers.ServerError(e, nil)
+	if !migrating {
+		s.evtman.AddEvent(context.TODO(), &events.XRPCStreamEvent{
+			RepoHandle: &atproto.SyncSubscribeRepos_Handle{
+				Did:    urepo.Did,
+				Handle: request.Handle,
+				Seq:    time.Now().UnixMicro(), // TODO: no
+				Time:   time.Now().Format(util.ISO8601),
+			},
+		})
+
+		s.evtman.AddEvent(context.TODO(), &events.XRPCStreamEvent{
+			RepoIdentity: &atproto.SyncSubscribeRepos_Identity{
+				Did:    urepo.Did,
+				Handle: to.StringPtr(request.Handle),
+				Seq:    time.Now().UnixMicro(), // TODO: no
+				Time:   time.Now().Format(util.ISO8601),
+			},
+		})
+	} else {
+		s.sendAccountEvent(urepo.Did, urepo.Status())
 	}
 
-	sess, err := s.createSession(&urepo)
+	sess, err := s.createSession(&urepo, newSessionInfo(e))
 	if err != nil {
 		s.logger.Error("error creating new session", "error", err)
 		return helpers.ServerError(e, nil)
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/haileyok/cocoon/models"
+)
+
+func TestCreateAccountDidWeb(t *testing.T) {
+	s, cache := newTestServer(t)
+	s.config.RegistrationPolicy = RegistrationPolicyOpen
+
+	// hosted somewhere else, so creating an account for it needs a token signed by its current key
+	external := newServiceAuthTestAccount(t, s, cache, "did:web:alice.example.com")
+	other := newServiceAuthTestAccount(t, s, cache, "did:web:mallory.example.com")
+
+	sign := func(urepo *models.RepoActor) string {
+		token, err := s.signServiceAuth(urepo, s.config.Did, "com.atproto.server.createAccount", time.Now().Add(serviceAuthDefaultExpiry))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	tests := []struct {
+		name   string
+		did    string
+		handle string
+		token  string
+		// an empty wantErr means the account should be created
+		wantErr         string
+		wantDeactivated bool
+	}{
+		{
+			name:    "external without a token",
+			did:     external.Repo.Did,
+			handle:  "alice.pds.example.com",
+			wantErr: "InvalidToken",
+		},
+		{
+			name:    "external with a token from another did",
+			did:     external.Repo.Did,
+			handle:  "alice.pds.example.com",
+			token:   sign(other),
+			wantErr: "InvalidToken",
+		},
+		{
+			name:            "external with a token",
+			did:             external.Repo.Did,
+			handle:          "alice.pds.example.com",
+			token:           sign(external),
+			wantDeactivated: true,
+		},
+		{
+			name:    "local with another handle",
+			did:     "did:web:bob.pds.example.com",
+			handle:  "robert.pds.example.com",
+			wantErr: "InvalidHandle",
+		},
+		{
+			name:   "local",
+			did:    "did:web:carol.pds.example.com",
+			handle: "carol.pds.example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// nothing resolves to these handles yet
+			cache.PutDid(tt.handle, "")
+
+			body, err := json.Marshal(ComAtprotoServerCreateAccountRequest{
+				Email:    tt.handle + "@example.com",
+				Handle:   tt.handle,
+				Did:      &tt.did,
+				Password: "hunter2",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			e, rec := newTestContext(http.MethodPost, "/xrpc/com.atproto.server.createAccount", string(body))
+			if tt.token != "" {
+				e.Request().Header.Set("authorization", "Bearer "+tt.token)
+			}
+
+			if err := s.handleCreateAccount(e); err != nil {
+				t.Fatal(err)
+			}
+
+			var res map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.wantErr != "" {
+				if rec.Code != 400 || res["error"] != tt.wantErr {
+					t.Fatalf("expected 400 %s, got %d: %v", tt.wantErr, rec.Code, res)
+				}
+
+				if urepo, err := s.getRepoActorByDid(tt.did); err != nil || urepo.Repo.Did != "" {
+					t.Fatalf("account was created anyway")
+				}
+
+				return
+			}
+
+			if rec.Code != 200 || res["did"] != tt.did || res["handle"] != tt.handle {
+				t.Fatalf("expected the account to be created, got %d: %v", rec.Code, res)
+			}
+
+			urepo, err := s.getRepoActorByDid(tt.did)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if (urepo.Repo.DeactivatedAt != nil) != tt.wantDeactivated {
+				t.Fatalf("expected deactivated %v, got %v", tt.wantDeactivated, urepo.Repo.DeactivatedAt)
+			}
+
+			// did:webs never have a repo to import, so they start with an empty one either way
+			if len(urepo.Repo.Root) == 0 || urepo.Repo.Rev == "" {
+				t.Fatal("expected an empty repo to be committed")
+			}
+
+			if urepo.Actor.Handle != tt.handle {
+				t.Fatalf("expected handle %s, got %s", tt.handle, urepo.Actor.Handle)
+			}
+		})
+	}
+}
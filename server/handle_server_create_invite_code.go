@@ -3,7 +3,6 @@ package server
 import (
 	"github.com/google/uuid"
 	"github.com/haileyok/cocoon/internal/helpers"
-	"github.com/haileyok/cocoon/models"
 	"github.com/labstack/echo/v4"
 )
 
@@ -37,11 +36,8 @@ func (s *Server) handleCreateInviteCode(e echo.Context) error {
 		acc = *req.ForAccount
 	}
 
-	if err := s.db.Create(&models.InviteCode{
-		Code:              ic,
-		Did:               acc,
-		RemainingUseCount: req.UseCount,
-	}).Error; err != nil {
+	icm := s.newInviteCodeModel(ic, acc, "admin", req.UseCount)
+	if err := s.db.Create(&icm).Error; err != nil {
 		s.logger.Error("error creating invite code", "error", err)
 		return helpers.ServerError(e, nil)
 	}
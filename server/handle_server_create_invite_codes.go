@@ -4,7 +4,6 @@ import (
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/google/uuid"
 	"github.com/haileyok/cocoon/internal/helpers"
-	"github.com/haileyok/cocoon/models"
 	"github.com/labstack/echo/v4"
 )
 
@@ -14,7 +13,9 @@ type ComAtprotoServerCreateInviteCodesRequest struct {
 	ForAccounts *[]string `json:"forAccounts,omitempty"`
 }
 
-type ComAtprotoServerCreateInviteCodesResponse []ComAtprotoServerCreateInviteCodesItem
+type ComAtprotoServerCreateInviteCodesResponse struct {
+	Codes []ComAtprotoServerCreateInviteCodesItem `json:"codes"`
+}
 
 type ComAtprotoServerCreateInviteCodesItem struct {
 	Account string   `json:"account"`
@@ -41,7 +42,7 @@ func (s *Server) handleCreateInviteCodes(e echo.Context) error {
 		req.ForAccounts = to.StringSlicePtr([]string{"admin"})
 	}
 
-	var codes []ComAtprotoServerCreateInviteCodesItem
+	codes := []ComAtprotoServerCreateInviteCodesItem{}
 
 	for _, did := range *req.ForAccounts {
 		var ics []string
@@ -50,11 +51,8 @@ func (s *Server) handleCreateInviteCodes(e echo.Context) error {
 			ic := uuid.NewString()
 			ics = append(ics, ic)
 
-			if err := s.db.Create(&models.InviteCode{
-				Code:              ic,
-				Did:               did,
-				RemainingUseCount: req.UseCount,
-			}).Error; err != nil {
+			icm := s.newInviteCodeModel(ic, did, "admin", req.UseCount)
+			if err := s.db.Create(&icm).Error; err != nil {
 				s.logger.Error("error creating invite code", "error", err)
 				return helpers.ServerError(e, nil)
 			}
@@ -66,5 +64,7 @@ func (s *Server) handleCreateInviteCodes(e echo.Context) error {
 		})
 	}
 
-	return e.JSON(200, codes)
+	return e.JSON(200, ComAtprotoServerCreateInviteCodesResponse{
+		Codes: codes,
+	})
 }
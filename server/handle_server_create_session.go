@@ -2,15 +2,11 @@ package server
 
 import (
 	"errors"
-	"strings"
 
 	"github.com/Azure/go-autorest/autorest/to"
-	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/haileyok/cocoon/internal/helpers"
-	"github.com/haileyok/cocoon/models"
 	"github.com/labstack/echo/v4"
 	"golang.org/x/crypto/bcrypt"
-	"gorm.io/gorm"
 )
 
 type ComAtprotoServerCreateSessionRequest struct {
@@ -51,34 +47,21 @@ func (s *Server) handleCreateSession(e echo.Context) error {
 		}
 	}
 
-	req.Identifier = strings.ToLower(req.Identifier)
-	var idtype string
-	if _, err := syntax.ParseDID(req.Identifier); err == nil {
-		idtype = "did"
-	} else if _, err := syntax.ParseHandle(req.Identifier); err == nil {
-		idtype = "handle"
-	} else {
-		idtype = "email"
+	// an identifier that doesn't match an account comes back empty, and fails the password check below after
+	// being counted against the limits like any other attempt
+	repo, err := s.getRepoActorByIdentifier(req.Identifier)
+	if err != nil {
+		s.logger.Error("erorr looking up repo", "endpoint", "com.atproto.server.createSession", "error", err)
+		return helpers.ServerError(e, nil)
 	}
 
-	var repo models.RepoActor
-	var err error
-	switch idtype {
-	case "did":
-		err = s.db.Raw("SELECT r.*, a.* FROM repos r LEFT JOIN actors a ON r.did = a.did WHERE r.did = ?", req.Identifier).Scan(&repo).Error
-	case "handle":
-		err = s.db.Raw("SELECT r.*, a.* FROM actors a LEFT JOIN repos r ON a.did = r.did WHERE a.handle = ?", req.Identifier).Scan(&repo).Error
-	case "email":
-		err = s.db.Raw("SELECT r.*, a.* FROM repos r LEFT JOIN actors a ON r.did = a.did WHERE a.email = ?", req.Identifier).Scan(&repo).Error
+	account := repo.Repo.Did
+	if account == "" {
+		account = req.Identifier
 	}
 
-	if err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return helpers.InputError(e, to.StringPtr("InvalidRequest"))
-		}
-
-		s.logger.Error("erorr looking up repo", "endpoint", "com.atproto.server.createSession", "error", err)
-		return helpers.ServerError(e, nil)
+	if s.rateLimitByAccount(e, account, rateLimitLoginAccount) {
+		return helpers.RateLimitError(e, "Too many sign in attempts for this account, try again later")
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(repo.Password), []byte(req.Password)); err != nil {
@@ -88,7 +71,25 @@ func (s *Server) handleCreateSession(e echo.Context) error {
 		return helpers.InputError(e, to.StringPtr("InvalidRequest"))
 	}
 
-	sess, err := s.createSession(&repo.Repo)
+	if repo.TakedownRef != nil {
+		return helpers.InputError(e, to.StringPtr("AccountTakedown"))
+	}
+
+	if err := s.checkAuthFactor(repo, to.String(req.AuthFactorToken)); err != nil {
+		switch {
+		case errors.Is(err, errAuthFactorRequired), errors.Is(err, errTotpRequired):
+			return e.JSON(401, map[string]string{"error": "AuthFactorTokenRequired", "message": err.Error()})
+		case errors.Is(err, errAuthFactorInvalid):
+			return e.JSON(401, map[string]string{"error": "InvalidToken", "message": err.Error()})
+		case errors.Is(err, errAuthFactorExpired):
+			return e.JSON(401, map[string]string{"error": "ExpiredToken", "message": err.Error()})
+		}
+
+		s.logger.Error("error checking auth factor", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	sess, err := s.createSession(&repo.Repo, newSessionInfo(e))
 	if err != nil {
 		s.logger.Error("error creating session", "error", err)
 		return helpers.ServerError(e, nil)
@@ -101,8 +102,8 @@ func (s *Server) handleCreateSession(e echo.Context) error {
 		Did:             repo.Repo.Did,
 		Email:           repo.Email,
 		EmailConfirmed:  repo.EmailConfirmedAt != nil,
-		EmailAuthFactor: false,
-		Active:          true, // TODO: eventually do takedowns
-		Status:          nil,  // TODO eventually do takedowns
+		EmailAuthFactor: repo.EmailAuthFactor,
+		Active:          repo.Active(),
+		Status:          repo.Status(),
 	})
 }
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+)
+
+type CocoonServerCreateTotpRecoveryCodesRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// swaps out all of the account's recovery codes for new ones, i.e. after most of them have been used
+func (s *Server) handleServerCreateTotpRecoveryCodes(e echo.Context) error {
+	urepo := e.Get("repo").(*models.RepoActor)
+
+	var req CocoonServerCreateTotpRecoveryCodesRequest
+	if err := e.Bind(&req); err != nil {
+		s.logger.Error("error binding", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := e.Validate(req); err != nil {
+		return helpers.InputError(e, nil)
+	}
+
+	if s.rateLimitByAccount(e, urepo.Repo.Did, rateLimitTotpAccount) {
+		return helpers.RateLimitError(e, "Too many authenticator code attempts, try again later")
+	}
+
+	if urepo.TotpEnabledAt == nil {
+		return helpers.InputErrorWithMessage(e, "TotpNotEnabled", "You don't have an authenticator app set up.")
+	}
+
+	ok, err := s.checkTotpCode(urepo, req.Code)
+	if err != nil {
+		s.logger.Error("error checking totp code", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if !ok {
+		return helpers.InputError(e, to.StringPtr("InvalidToken"))
+	}
+
+	codes, err := s.createTotpRecoveryCodes(urepo.Repo.Did)
+	if err != nil {
+		s.logger.Error("error creating recovery codes", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	return e.JSON(200, CocoonServerTotpRecoveryCodesResponse{
+		RecoveryCodes: codes,
+	})
+}
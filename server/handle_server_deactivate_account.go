@@ -0,0 +1,40 @@
+package server
+
+import (
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+)
+
+type ComAtprotoServerDeactivateAccountRequest struct {
+	DeleteAfter *string `json:"deleteAfter,omitempty"` // TODO: actually schedule the deletion
+}
+
+func (s *Server) handleServerDeactivateAccount(e echo.Context) error {
+	urepo := e.Get("repo").(*models.RepoActor)
+
+	var req ComAtprotoServerDeactivateAccountRequest
+	if err := e.Bind(&req); err != nil {
+		s.logger.Error("error binding", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if !urepo.Active() {
+		return helpers.InputError(e, to.StringPtr("InvalidRequest"))
+	}
+
+	now := time.Now().UTC()
+
+	if err := s.db.Exec("UPDATE repos SET deactivated_at = ? WHERE did = ?", now, urepo.Repo.Did).Error; err != nil {
+		s.logger.Error("error deactivating repo", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	urepo.DeactivatedAt = &now
+	s.sendAccountEvent(urepo.Repo.Did, urepo.Status())
+
+	return e.NoContent(200)
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type ComAtprotoServerDeleteAccountRequest struct {
+	Did      string `json:"did" validate:"required,atproto-did"`
+	Password string `json:"password" validate:"required"`
+	Token    string `json:"token" validate:"required"`
+}
+
+func (s *Server) handleServerDeleteAccount(e echo.Context) error {
+	var req ComAtprotoServerDeleteAccountRequest
+	if err := e.Bind(&req); err != nil {
+		s.logger.Error("error binding", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := e.Validate(req); err != nil {
+		return helpers.InputError(e, nil)
+	}
+
+	// the password is checked here too, so this is limited like signing in
+	if s.rateLimitByAccount(e, req.Did, rateLimitLoginAccount) {
+		return helpers.RateLimitError(e, "Too many attempts for this account, try again later")
+	}
+
+	urepo, err := s.getRepoActorByDid(req.Did)
+	if err != nil {
+		s.logger.Error("error looking up repo", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	// a wrong password and a wrong token look the same, so this can't be used to check passwords
+	if urepo.Repo.Did == "" || urepo.AccountDeleteCode == nil || urepo.AccountDeleteCodeExpiresAt == nil || *urepo.AccountDeleteCode != req.Token {
+		return helpers.InputErrorWithMessage(e, "InvalidToken", "Invalid password or token")
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(urepo.Password), []byte(req.Password)); err != nil {
+		if err != bcrypt.ErrMismatchedHashAndPassword {
+			s.logger.Error("error comparing hash and password", "error", err)
+		}
+		return helpers.InputErrorWithMessage(e, "InvalidToken", "Invalid password or token")
+	}
+
+	if time.Now().UTC().After(*urepo.AccountDeleteCodeExpiresAt) {
+		return helpers.InputError(e, to.StringPtr("ExpiredToken"))
+	}
+
+	if err := s.deleteAccount(e.Request().Context(), urepo.Repo.Did); err != nil {
+		s.logger.Error("error deleting account", "did", urepo.Repo.Did, "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	return e.NoContent(200)
+}
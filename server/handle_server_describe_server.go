@@ -22,9 +22,9 @@ type ComAtprotoServerDescribeServerResponse struct {
 
 func (s *Server) handleDescribeServer(e echo.Context) error {
 	return e.JSON(200, ComAtprotoServerDescribeServerResponse{
-		InviteCodeRequired:        true,
+		InviteCodeRequired:        s.inviteCodeRequired(),
 		PhoneVerificationRequired: false,
-		AvailableUserDomains:      []string{"." + s.config.Hostname}, // TODO: more
+		AvailableUserDomains:      s.config.UserDomains,
 		Links: ComAtprotoServerDescribeServerResponseLinks{
 			PrivacyPolicy:  nil,
 			TermsOfService: nil,
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type CocoonServerDisableTotpRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+func (s *Server) handleServerDisableTotp(e echo.Context) error {
+	urepo := e.Get("repo").(*models.RepoActor)
+
+	var req CocoonServerDisableTotpRequest
+	if err := e.Bind(&req); err != nil {
+		s.logger.Error("error binding", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := e.Validate(req); err != nil {
+		return helpers.InputError(e, nil)
+	}
+
+	if s.rateLimitByAccount(e, urepo.Repo.Did, rateLimitTotpAccount) {
+		return helpers.RateLimitError(e, "Too many authenticator code attempts, try again later")
+	}
+
+	if urepo.TotpEnabledAt == nil {
+		return helpers.InputErrorWithMessage(e, "TotpNotEnabled", "You don't have an authenticator app set up.")
+	}
+
+	ok, err := s.checkTotpOrRecoveryCode(urepo, req.Code)
+	if err != nil {
+		s.logger.Error("error checking totp code", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if !ok {
+		return helpers.InputError(e, to.StringPtr("InvalidToken"))
+	}
+
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("UPDATE repos SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0 WHERE did = ?", urepo.Repo.Did).Error; err != nil {
+			return err
+		}
+
+		return tx.Exec("DELETE FROM totp_recovery_codes WHERE did = ?", urepo.Repo.Did).Error
+	}); err != nil {
+		s.logger.Error("error disabling totp", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	return e.NoContent(200)
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+	"github.com/pquerna/otp/totp"
+)
+
+type CocoonServerEnrollTotpResponse struct {
+	Uri    string `json:"uri"`
+	Secret string `json:"secret"`
+}
+
+// starts setting up an authenticator app. it isn't used for signing in until cocoon.server.confirmTotp gets a
+// code from it, and enrolling again before then just replaces the secret
+func (s *Server) handleServerEnrollTotp(e echo.Context) error {
+	urepo := e.Get("repo").(*models.RepoActor)
+
+	if urepo.TotpEnabledAt != nil {
+		return helpers.InputErrorWithMessage(e, "TotpAlreadyEnabled", "Turn off your current authenticator app before setting up a new one.")
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      s.config.Hostname,
+		AccountName: urepo.Handle,
+		Period:      totpPeriod,
+		Digits:      totpOpts.Digits,
+		Algorithm:   totpOpts.Algorithm,
+	})
+	if err != nil {
+		s.logger.Error("error generating totp key", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	secret, err := s.encryptTotpSecret(urepo.Repo.Did, key.Secret())
+	if err != nil {
+		s.logger.Error("error encrypting totp secret", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := s.db.Exec("UPDATE repos SET totp_secret = ?, totp_last_step = 0 WHERE did = ?", secret, urepo.Repo.Did).Error; err != nil {
+		s.logger.Error("error updating repo", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	return e.JSON(200, CocoonServerEnrollTotpResponse{
+		Uri:    key.URL(),
+		Secret: key.Secret(),
+	})
+}
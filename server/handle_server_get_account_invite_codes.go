@@ -0,0 +1,51 @@
+package server
+
+import (
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+)
+
+type ComAtprotoServerGetAccountInviteCodesResponse struct {
+	Codes []ComAtprotoServerDefsInviteCode `json:"codes"`
+}
+
+func (s *Server) handleServerGetAccountInviteCodes(e echo.Context) error {
+	urepo := e.Get("repo").(*models.RepoActor)
+
+	includeUsed := e.QueryParam("includeUsed") != "false"
+	createAvailable := e.QueryParam("createAvailable") != "false"
+
+	if createAvailable {
+		if err := s.grantEarnedInviteCodes(urepo); err != nil {
+			s.logger.Error("error granting invite codes", "error", err)
+			return helpers.ServerError(e, nil)
+		}
+	}
+
+	ics, err := s.getInviteCodesForAccount(urepo.Repo.Did)
+	if err != nil {
+		s.logger.Error("error getting invite codes", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if !includeUsed {
+		var unused []models.InviteCode
+		for _, ic := range ics {
+			if ic.RemainingUseCount > 0 {
+				unused = append(unused, ic)
+			}
+		}
+		ics = unused
+	}
+
+	codes, err := s.getInviteCodeViews(ics)
+	if err != nil {
+		s.logger.Error("error getting invite code uses", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	return e.JSON(200, ComAtprotoServerGetAccountInviteCodesResponse{
+		Codes: codes,
+	})
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+)
+
+type ComAtprotoServerGetServiceAuthResponse struct {
+	Token string `json:"token"`
+}
+
+func (s *Server) handleServerGetServiceAuth(e echo.Context) error {
+	urepo := e.Get("repo").(*models.RepoActor)
+
+	aud := e.QueryParam("aud")
+	if _, err := syntax.ParseDID(aud); err != nil {
+		return helpers.InputErrorWithMessage(e, "InvalidRequest", "aud must be a DID")
+	}
+
+	lxm := e.QueryParam("lxm")
+	if lxm != "" {
+		if _, err := syntax.ParseNSID(lxm); err != nil {
+			return helpers.InputErrorWithMessage(e, "InvalidRequest", "lxm must be an NSID")
+		}
+	}
+
+	// an oauth app could otherwise get a createAccount token and move the account to another pds, or reach
+	// methods its scopes don't cover through another service
+	if scope, ok := e.Get("oauthScope").(string); ok {
+		if _, privileged := serviceAuthPrivilegedMethods[lxm]; privileged || (lxm != "" && !oauthScopeAllows(scope, lxm)) {
+			return helpers.InputErrorWithMessage(e, "InvalidRequest", "OAuth sessions can't create service auth tokens for "+lxm)
+		}
+	}
+
+	exp := time.Now().Add(serviceAuthDefaultExpiry)
+	if expstr := e.QueryParam("exp"); expstr != "" {
+		expint, err := strconv.ParseInt(expstr, 10, 64)
+		if err != nil {
+			return helpers.InputErrorWithMessage(e, "InvalidRequest", "exp must be a unix timestamp")
+		}
+		exp = time.Unix(expint, 0)
+	}
+
+	token, err := s.signServiceAuth(urepo, aud, lxm, exp)
+	if err != nil {
+		var serr *ServiceAuthError
+		if errors.As(err, &serr) {
+			return helpers.InputErrorWithMessage(e, serr.Name, serr.Message)
+		}
+
+		s.logger.Error("error signing service auth", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	return e.JSON(200, ComAtprotoServerGetServiceAuthResponse{
+		Token: token,
+	})
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"github.com/haileyok/cocoon/models"
+	"github.com/haileyok/cocoon/oauth"
 	"github.com/labstack/echo/v4"
 )
 
@@ -18,13 +19,21 @@ type ComAtprotoServerGetSessionResponse struct {
 func (s *Server) handleGetSession(e echo.Context) error {
 	repo := e.Get("repo").(*models.RepoActor)
 
-	return e.JSON(200, ComAtprotoServerGetSessionResponse{
+	resp := ComAtprotoServerGetSessionResponse{
 		Handle:          repo.Handle,
 		Did:             repo.Repo.Did,
 		Email:           repo.Email,
 		EmailConfirmed:  repo.EmailConfirmedAt != nil,
-		EmailAuthFactor: false, // TODO: todo todo
-		Active:          true,
-		Status:          nil,
-	})
+		EmailAuthFactor: repo.EmailAuthFactor,
+		Active:          repo.Active(),
+		Status:          repo.Status(),
+	}
+
+	// oauth clients only get to see the email if the user granted them that scope
+	if scope, ok := e.Get("oauthScope").(string); ok && !oauth.HasScope(scope, oauth.ScopeTransitionEmail) {
+		resp.Email = ""
+		resp.EmailConfirmed = false
+	}
+
+	return e.JSON(200, resp)
 }
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm/clause"
+)
+
+type CocoonServerJoinWaitlistRequest struct {
+	Email  string `json:"email" validate:"required,email"`
+	Handle string `json:"handle,omitempty"`
+}
+
+func (s *Server) handleServerJoinWaitlist(e echo.Context) error {
+	if s.config.RegistrationPolicy != RegistrationPolicyWaitlist {
+		return helpers.InputErrorWithMessage(e, "InvalidRequest", "This server does not have a waitlist.")
+	}
+
+	var req CocoonServerJoinWaitlistRequest
+	if err := e.Bind(&req); err != nil {
+		s.logger.Error("error binding", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	req.Email = strings.ToLower(req.Email)
+
+	if err := e.Validate(req); err != nil {
+		return helpers.InputError(e, to.StringPtr("InvalidEmail"))
+	}
+
+	// joining twice shouldn't tell anyone whether an email is already on the list
+	if err := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.WaitlistEntry{
+		Email:     req.Email,
+		Handle:    strings.ToLower(req.Handle),
+		CreatedAt: time.Now(),
+	}).Error; err != nil {
+		s.logger.Error("error adding waitlist entry", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	return e.NoContent(200)
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"time"
+
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+)
+
+type CocoonServerSessionView struct {
+	Id         string `json:"id"`
+	Type       string `json:"type"`
+	ClientId   string `json:"clientId,omitempty"`
+	CreatedAt  string `json:"createdAt"`
+	LastUsedAt string `json:"lastUsedAt"`
+	UserAgent  string `json:"userAgent,omitempty"`
+	Ip         string `json:"ip,omitempty"`
+	Current    bool   `json:"current"`
+}
+
+type CocoonServerListSessionsResponse struct {
+	Sessions []CocoonServerSessionView `json:"sessions"`
+}
+
+// password sessions come from refresh_tokens, which hold one row per session. sessions signed in before session
+// ids were recorded show up once they next refresh. oauth grants are listed alongside them
+func (s *Server) handleServerListSessions(e echo.Context) error {
+	urepo := e.Get("repo").(*models.RepoActor)
+	current, _ := e.Get("sessionId").(string)
+	now := time.Now()
+
+	var reftoks []models.RefreshToken
+	if err := s.db.Raw("SELECT * FROM refresh_tokens WHERE did = ? AND session_id != '' AND expires_at > ? ORDER BY last_used_at DESC", urepo.Repo.Did, now).Scan(&reftoks).Error; err != nil {
+		s.logger.Error("error getting sessions", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	var otoks []models.OAuthToken
+	if err := s.db.Raw("SELECT * FROM oauth_tokens WHERE did = ? AND expires_at > ? ORDER BY updated_at DESC", urepo.Repo.Did, now).Scan(&otoks).Error; err != nil {
+		s.logger.Error("error getting oauth sessions", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	views := []CocoonServerSessionView{}
+	for _, rt := range reftoks {
+		views = append(views, CocoonServerSessionView{
+			Id:         rt.SessionId,
+			Type:       "password",
+			CreatedAt:  rt.SessionCreatedAt.Format(time.RFC3339),
+			LastUsedAt: rt.LastUsedAt.Format(time.RFC3339),
+			UserAgent:  rt.UserAgent,
+			Ip:         rt.Ip,
+			Current:    rt.SessionId == current,
+		})
+	}
+
+	for _, ot := range otoks {
+		views = append(views, CocoonServerSessionView{
+			Id:         ot.ID,
+			Type:       "oauth",
+			ClientId:   ot.ClientId,
+			CreatedAt:  ot.CreatedAt.Format(time.RFC3339),
+			LastUsedAt: ot.UpdatedAt.Format(time.RFC3339),
+		})
+	}
+
+	return e.JSON(200, CocoonServerListSessionsResponse{
+		Sessions: views,
+	})
+}
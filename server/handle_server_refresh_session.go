@@ -1,6 +1,7 @@
 package server
 
 import (
+	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/haileyok/cocoon/internal/helpers"
 	"github.com/haileyok/cocoon/models"
 	"github.com/labstack/echo/v4"
@@ -19,17 +20,34 @@ func (s *Server) handleRefreshSession(e echo.Context) error {
 	token := e.Get("token").(string)
 	repo := e.Get("repo").(*models.RepoActor)
 
-	if err := s.db.Exec("DELETE FROM refresh_tokens WHERE token = ?", token).Error; err != nil {
+	var reftok models.RefreshToken
+	if err := s.db.Raw("DELETE FROM refresh_tokens WHERE token = ? RETURNING *", token).Scan(&reftok).Error; err != nil {
 		s.logger.Error("error getting refresh token from db", "error", err)
 		return helpers.ServerError(e, nil)
 	}
 
+	// someone else refreshed with this token first, or the session was revoked after the middleware checked it
+	if reftok.Token == "" {
+		return helpers.InputError(e, to.StringPtr("InvalidToken"))
+	}
+
 	if err := s.db.Exec("DELETE FROM tokens WHERE refresh_token = ?", token).Error; err != nil {
 		s.logger.Error("error deleting access token from db", "error", err)
 		return helpers.ServerError(e, nil)
 	}
 
-	sess, err := s.createSession(&repo.Repo)
+	// sessions from before session ids were recorded start being tracked here
+	info := newSessionInfo(e)
+	if reftok.SessionId != "" {
+		info = &sessionInfo{
+			Id:        reftok.SessionId,
+			CreatedAt: reftok.SessionCreatedAt,
+			UserAgent: reftok.UserAgent,
+			Ip:        reftok.Ip,
+		}
+	}
+
+	sess, err := s.createSession(&repo.Repo, info)
 	if err != nil {
 		s.logger.Error("error creating new session for refresh", "error", err)
 		return helpers.ServerError(e, nil)
@@ -40,7 +58,7 @@ func (s *Server) handleRefreshSession(e echo.Context) error {
 		RefreshJwt: sess.RefreshToken,
 		Handle:     repo.Handle,
 		Did:        repo.Repo.Did,
-		Active:     true,
-		Status:     nil,
+		Active:     repo.Active(),
+		Status:     repo.Status(),
 	})
 }
@@ -33,6 +33,10 @@ func (s *Server) handleServerRequestPasswordReset(e echo.Context) error {
 		urepo = murepo
 	}
 
+	if s.rateLimitByAccount(e, urepo.Repo.Did, rateLimitEmailAccount) {
+		return helpers.RateLimitError(e, "Too many emails sent to this account, try again later")
+	}
+
 	code := fmt.Sprintf("%s-%s", helpers.RandomVarchar(5), helpers.RandomVarchar(5))
 	eat := time.Now().Add(10 * time.Minute).UTC()
 
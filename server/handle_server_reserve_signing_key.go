@@ -0,0 +1,71 @@
+package server
+
+import (
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+)
+
+type ComAtprotoServerReserveSigningKeyRequest struct {
+	Did string `json:"did" validate:"required,atproto-did"`
+}
+
+type ComAtprotoServerReserveSigningKeyResponse struct {
+	SigningKey string `json:"signingKey"`
+}
+
+func (s *Server) handleServerReserveSigningKey(e echo.Context) error {
+	var req ComAtprotoServerReserveSigningKeyRequest
+	if err := e.Bind(&req); err != nil {
+		s.logger.Error("error binding", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := e.Validate(req); err != nil {
+		return helpers.InputError(e, to.StringPtr("InvalidDid"))
+	}
+
+	if err := s.deleteExpiredReservedKeys(); err != nil {
+		s.logger.Error("error deleting expired reserved keys", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	// asking again before the account is created gives back the same key, since the caller may
+	// have already put it in a plc operation
+	k, err := s.getReservedSigningKey(req.Did)
+	if err != nil {
+		s.logger.Error("error getting reserved key", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if k == nil {
+		k, err = crypto.GeneratePrivateKeyK256()
+		if err != nil {
+			s.logger.Error("error creating signing key", "error", err)
+			return helpers.ServerError(e, nil)
+		}
+
+		if err := s.db.Create(&models.ReservedKey{
+			Did:        req.Did,
+			PrivateKey: k.Bytes(),
+			CreatedAt:  time.Now(),
+		}).Error; err != nil {
+			s.logger.Error("error saving reserved key", "error", err)
+			return helpers.ServerError(e, nil)
+		}
+	}
+
+	pubkey, err := k.PublicKey()
+	if err != nil {
+		s.logger.Error("error getting public key", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	return e.JSON(200, ComAtprotoServerReserveSigningKeyResponse{
+		SigningKey: pubkey.DIDKey(),
+	})
+}
@@ -28,6 +28,10 @@ func (s *Server) handleServerResetPassword(e echo.Context) error {
 		return helpers.InputError(e, nil)
 	}
 
+	if s.rateLimitByAccount(e, urepo.Repo.Did, rateLimitResetPasswordAccount) {
+		return helpers.RateLimitError(e, "Too many password reset attempts, try again later")
+	}
+
 	if urepo.PasswordResetCode == nil || urepo.PasswordResetCodeExpiresAt == nil {
 		return helpers.InputError(e, to.StringPtr("InvalidToken"))
 	}
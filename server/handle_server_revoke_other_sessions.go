@@ -0,0 +1,38 @@
+package server
+
+import (
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// ends every session but the one making the request, including oauth grants and sessions from before session
+// ids were recorded. the current session is found through its refresh token, so this works for those too
+func (s *Server) handleServerRevokeOtherSessions(e echo.Context) error {
+	urepo := e.Get("repo").(*models.RepoActor)
+	token := e.Get("token").(string)
+
+	var acctok models.Token
+	if err := s.db.Raw("SELECT * FROM tokens WHERE token = ?", token).Scan(&acctok).Error; err != nil {
+		s.logger.Error("error getting current session", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM tokens WHERE did = ? AND refresh_token != ?", urepo.Repo.Did, acctok.RefreshToken).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec("DELETE FROM refresh_tokens WHERE did = ? AND token != ?", urepo.Repo.Did, acctok.RefreshToken).Error; err != nil {
+			return err
+		}
+
+		return tx.Exec("DELETE FROM oauth_tokens WHERE did = ?", urepo.Repo.Did).Error
+	}); err != nil {
+		s.logger.Error("error revoking other sessions", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	return e.NoContent(200)
+}
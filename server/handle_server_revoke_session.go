@@ -0,0 +1,59 @@
+package server
+
+import (
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type CocoonServerRevokeSessionRequest struct {
+	Id string `json:"id" validate:"required"`
+}
+
+// takes either a password session id or an oauth grant id, as returned by cocoon.server.listSessions
+func (s *Server) handleServerRevokeSession(e echo.Context) error {
+	urepo := e.Get("repo").(*models.RepoActor)
+
+	var req CocoonServerRevokeSessionRequest
+	if err := e.Bind(&req); err != nil {
+		s.logger.Error("error binding", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := e.Validate(req); err != nil {
+		return helpers.InputError(e, nil)
+	}
+
+	var revoked int64
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM tokens WHERE did = ? AND session_id = ?", urepo.Repo.Did, req.Id).Error; err != nil {
+			return err
+		}
+
+		res := tx.Exec("DELETE FROM refresh_tokens WHERE did = ? AND session_id = ?", urepo.Repo.Did, req.Id)
+		if res.Error != nil {
+			return res.Error
+		}
+		revoked += res.RowsAffected
+
+		res = tx.Exec("DELETE FROM oauth_tokens WHERE did = ? AND id = ?", urepo.Repo.Did, req.Id)
+		if res.Error != nil {
+			return res.Error
+		}
+		revoked += res.RowsAffected
+
+		return nil
+	}); err != nil {
+		s.logger.Error("error revoking session", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if revoked == 0 {
+		return helpers.InputErrorWithMessage(e, "SessionNotFound", "That session doesn't exist or has already ended.")
+	}
+
+	s.sessionTouches.forget(req.Id)
+
+	return e.NoContent(200)
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/haileyok/cocoon/models"
+)
+
+// password sessions for alice and bob, and an oauth grant for alice
+func newRevokeTestServer(t *testing.T) (*Server, *models.RepoActor, []*sessionInfo, *sessionInfo) {
+	t.Helper()
+
+	s, _ := newTestServer(t)
+
+	alice := &models.RepoActor{Repo: models.Repo{Did: "did:plc:alice"}}
+	bob := &models.Repo{Did: "did:plc:bob"}
+
+	var sessions []*sessionInfo
+	for range 3 {
+		info := &sessionInfo{Id: uuid.NewString(), CreatedAt: time.Now()}
+		if _, err := s.createSession(&alice.Repo, info); err != nil {
+			t.Fatal(err)
+		}
+		sessions = append(sessions, info)
+	}
+
+	bobSession := &sessionInfo{Id: uuid.NewString(), CreatedAt: time.Now()}
+	if _, err := s.createSession(bob, bobSession); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.db.Create(&models.OAuthToken{
+		ID:           "oauth-grant",
+		Did:          alice.Repo.Did,
+		RefreshToken: "oauth-refresh",
+		ExpiresAt:    time.Now().Add(time.Hour),
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return s, alice, sessions, bobSession
+}
+
+func countRows(t *testing.T, s *Server, query string, args ...any) int64 {
+	t.Helper()
+
+	var n int64
+	if err := s.db.Raw(query, args...).Scan(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return n
+}
+
+func TestRevokeSession(t *testing.T) {
+	s, alice, sessions, bobSession := newRevokeTestServer(t)
+
+	revoke := func(id string) (int, map[string]string) {
+		e, rec := newTestContext(http.MethodPost, "/xrpc/com.atproto.server.revokeSession", `{"id":"`+id+`"}`)
+		e.Set("repo", alice)
+		if err := s.handleServerRevokeSession(e); err != nil {
+			t.Fatal(err)
+		}
+
+		var body map[string]string
+		if rec.Body.Len() > 0 {
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return rec.Code, body
+	}
+
+	if code, body := revoke(sessions[1].Id); code != 200 {
+		t.Fatalf("expected the session to be revoked, got %d: %v", code, body)
+	}
+
+	if n := countRows(t, s, "SELECT COUNT(*) FROM refresh_tokens WHERE session_id = ?", sessions[1].Id); n != 0 {
+		t.Fatalf("revoked session still has %d refresh tokens", n)
+	}
+	if n := countRows(t, s, "SELECT COUNT(*) FROM tokens WHERE session_id = ?", sessions[1].Id); n != 0 {
+		t.Fatalf("revoked session still has %d access tokens", n)
+	}
+	if n := countRows(t, s, "SELECT COUNT(*) FROM refresh_tokens WHERE did = ?", alice.Repo.Did); n != 2 {
+		t.Fatalf("expected alice's other 2 sessions to be left alone, got %d", n)
+	}
+
+	if code, body := revoke("oauth-grant"); code != 200 {
+		t.Fatalf("expected the oauth grant to be revoked, got %d: %v", code, body)
+	}
+	if n := countRows(t, s, "SELECT COUNT(*) FROM oauth_tokens WHERE did = ?", alice.Repo.Did); n != 0 {
+		t.Fatalf("revoked oauth grant is still there")
+	}
+
+	for _, id := range []string{sessions[1].Id, "oauth-grant", bobSession.Id, "nope"} {
+		if code, body := revoke(id); code != 400 || body["error"] != "SessionNotFound" {
+			t.Fatalf("revoking %s: expected SessionNotFound, got %d: %v", id, code, body)
+		}
+	}
+
+	if n := countRows(t, s, "SELECT COUNT(*) FROM refresh_tokens WHERE session_id = ?", bobSession.Id); n != 1 {
+		t.Fatalf("bob's session was touched")
+	}
+}
+
+func TestRevokeOtherSessions(t *testing.T) {
+	s, alice, sessions, bobSession := newRevokeTestServer(t)
+
+	var current models.Token
+	if err := s.db.Raw("SELECT * FROM tokens WHERE session_id = ?", sessions[0].Id).Scan(&current).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	e, rec := newTestContext(http.MethodPost, "/xrpc/com.atproto.server.revokeOtherSessions", "")
+	e.Set("repo", alice)
+	e.Set("token", current.Token)
+	if err := s.handleServerRevokeOtherSessions(e); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if n := countRows(t, s, "SELECT COUNT(*) FROM refresh_tokens WHERE did = ? AND session_id != ?", alice.Repo.Did, sessions[0].Id); n != 0 {
+		t.Fatalf("%d of alice's other sessions are left", n)
+	}
+	if n := countRows(t, s, "SELECT COUNT(*) FROM tokens WHERE did = ? AND session_id != ?", alice.Repo.Did, sessions[0].Id); n != 0 {
+		t.Fatalf("%d of alice's other access tokens are left", n)
+	}
+	if n := countRows(t, s, "SELECT COUNT(*) FROM oauth_tokens WHERE did = ?", alice.Repo.Did); n != 0 {
+		t.Fatalf("alice's oauth grant is left")
+	}
+	if n := countRows(t, s, "SELECT COUNT(*) FROM refresh_tokens WHERE session_id = ?", sessions[0].Id); n != 1 {
+		t.Fatalf("the current session was revoked")
+	}
+	if n := countRows(t, s, "SELECT COUNT(*) FROM refresh_tokens WHERE session_id = ?", bobSession.Id); n != 1 {
+		t.Fatalf("bob's session was revoked")
+	}
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"strings"
 	"time"
 
 	"github.com/Azure/go-autorest/autorest/to"
@@ -11,7 +12,7 @@ import (
 
 type ComAtprotoServerUpdateEmailRequest struct {
 	Email           string `json:"email" validate:"required"`
-	EmailAuthFactor bool   `json:"emailAuthFactor"`
+	EmailAuthFactor *bool  `json:"emailAuthFactor"`
 	Token           string `json:"token" validate:"required"`
 }
 
@@ -40,9 +41,29 @@ func (s *Server) handleServerUpdateEmail(e echo.Context) error {
 		return helpers.InputError(e, to.StringPtr("ExpiredToken"))
 	}
 
-	if err := s.db.Exec("UPDATE repos SET email_update_code = NULL, email_update_code_expires_at = NULL, email_confirmed_at = NULL,  email = ? WHERE did = ?", req.Email, urepo.Repo.Did).Error; err != nil {
-		s.logger.Error("error updating repo", "error", err)
-		return helpers.ServerError(e, nil)
+	// the same email can be sent back just to turn the second factor on or off, which shouldn't unconfirm it
+	emailChanged := !strings.EqualFold(req.Email, urepo.Email)
+
+	// leaving emailAuthFactor out keeps whatever the account already has
+	emailAuthFactor := urepo.EmailAuthFactor
+	if req.EmailAuthFactor != nil {
+		emailAuthFactor = *req.EmailAuthFactor
+	}
+
+	if req.EmailAuthFactor != nil && *req.EmailAuthFactor && (emailChanged || urepo.EmailConfirmedAt == nil) {
+		return helpers.InputErrorWithMessage(e, "InvalidRequest", "Your email must be confirmed before it can be used as a second factor")
+	}
+
+	if emailChanged {
+		if err := s.db.Exec("UPDATE repos SET email_update_code = NULL, email_update_code_expires_at = NULL, email_confirmed_at = NULL, email_auth_factor = ?, email = ? WHERE did = ?", emailAuthFactor, req.Email, urepo.Repo.Did).Error; err != nil {
+			s.logger.Error("error updating repo", "error", err)
+			return helpers.ServerError(e, nil)
+		}
+	} else {
+		if err := s.db.Exec("UPDATE repos SET email_update_code = NULL, email_update_code_expires_at = NULL, email_auth_factor = ? WHERE did = ?", emailAuthFactor, urepo.Repo.Did).Error; err != nil {
+			s.logger.Error("error updating repo", "error", err)
+			return helpers.ServerError(e, nil)
+		}
 	}
 
 	return e.NoContent(200)
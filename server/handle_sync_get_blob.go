@@ -3,6 +3,7 @@ package server
 import (
 	"bytes"
 
+	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/haileyok/cocoon/internal/helpers"
 	"github.com/haileyok/cocoon/models"
 	"github.com/ipfs/go-cid"
@@ -25,12 +26,33 @@ func (s *Server) handleSyncGetBlob(e echo.Context) error {
 		return helpers.InputError(e, nil)
 	}
 
+	urepo, err := s.getRepoActorByDid(did)
+	if err != nil {
+		s.logger.Error("error looking up repo", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if urepo.Repo.Did == "" {
+		return helpers.InputError(e, to.StringPtr("RepoNotFound"))
+	}
+
+	// moderation services can still see taken down content, so that they can review it
+	isModerator := s.requestIsFromModerator(e)
+
+	if status := urepo.Status(); status != nil && !isModerator {
+		return helpers.InputError(e, to.StringPtr(repoStatusError(*status)))
+	}
+
 	var blob models.Blob
 	if err := s.db.Raw("SELECT * FROM blobs WHERE did = ? AND cid = ?", did, c.Bytes()).Scan(&blob).Error; err != nil {
 		s.logger.Error("error looking up blob", "error", err)
 		return helpers.ServerError(e, nil)
 	}
 
+	if blob.ID == 0 || (blob.TakedownRef != nil && !isModerator) {
+		return helpers.InputError(e, to.StringPtr("BlobNotFound"))
+	}
+
 	buf := new(bytes.Buffer)
 
 	var parts []models.BlobPart
@@ -5,6 +5,7 @@ import (
 	"context"
 	"strings"
 
+	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/bluesky-social/indigo/carstore"
 	"github.com/haileyok/cocoon/blockstore"
 	"github.com/haileyok/cocoon/internal/helpers"
@@ -38,6 +39,10 @@ func (s *Server) handleGetBlocks(e echo.Context) error {
 		return helpers.ServerError(e, nil)
 	}
 
+	if status := urepo.Status(); status != nil {
+		return helpers.InputError(e, to.StringPtr(repoStatusError(*status)))
+	}
+
 	buf := new(bytes.Buffer)
 	rc, err := cid.Cast(urepo.Root)
 	if err != nil {
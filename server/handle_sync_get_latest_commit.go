@@ -1,6 +1,7 @@
 package server
 
 import (
+	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/haileyok/cocoon/internal/helpers"
 	"github.com/ipfs/go-cid"
 	"github.com/labstack/echo/v4"
@@ -22,6 +23,10 @@ func (s *Server) handleSyncGetLatestCommit(e echo.Context) error {
 		return err
 	}
 
+	if status := urepo.Status(); status != nil {
+		return helpers.InputError(e, to.StringPtr(repoStatusError(*status)))
+	}
+
 	c, err := cid.Cast(urepo.Root)
 	if err != nil {
 		return err
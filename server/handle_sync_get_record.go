@@ -3,6 +3,7 @@ package server
 import (
 	"bytes"
 
+	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/bluesky-social/indigo/carstore"
 	"github.com/haileyok/cocoon/internal/helpers"
 	"github.com/haileyok/cocoon/models"
@@ -23,6 +24,23 @@ func (s *Server) handleSyncGetRecord(e echo.Context) error {
 		return helpers.ServerError(e, nil)
 	}
 
+	// moderation services can still see taken down content, so that they can review it
+	isModerator := s.requestIsFromModerator(e)
+
+	if status := urepo.Status(); status != nil && !isModerator {
+		return helpers.InputError(e, to.StringPtr(repoStatusError(*status)))
+	}
+
+	var record models.Record
+	if err := s.db.Raw("SELECT * FROM records WHERE did = ? AND nsid = ? AND rkey = ?", did, collection, rkey).Scan(&record).Error; err != nil {
+		s.logger.Error("error getting record", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if record.TakedownRef != nil && !isModerator {
+		return helpers.InputError(e, to.StringPtr("RecordNotFound"))
+	}
+
 	root, blocks, err := s.repoman.getRecordProof(urepo, collection, rkey)
 	if err != nil {
 		return err
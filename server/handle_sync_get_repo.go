@@ -3,6 +3,7 @@ package server
 import (
 	"bytes"
 
+	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/bluesky-social/indigo/carstore"
 	"github.com/haileyok/cocoon/internal/helpers"
 	"github.com/haileyok/cocoon/models"
@@ -23,6 +24,10 @@ func (s *Server) handleSyncGetRepo(e echo.Context) error {
 		return err
 	}
 
+	if status := urepo.Status(); status != nil {
+		return helpers.InputError(e, to.StringPtr(repoStatusError(*status)))
+	}
+
 	rc, err := cid.Cast(urepo.Root)
 	if err != nil {
 		return err
@@ -12,7 +12,6 @@ type ComAtprotoSyncGetRepoStatusResponse struct {
 	Rev    *string `json:"rev,omitempty"`
 }
 
-// TODO: make this actually do the right thing
 func (s *Server) handleSyncGetRepoStatus(e echo.Context) error {
 	did := e.QueryParam("did")
 	if did == "" {
@@ -26,8 +25,8 @@ func (s *Server) handleSyncGetRepoStatus(e echo.Context) error {
 
 	return e.JSON(200, ComAtprotoSyncGetRepoStatusResponse{
 		Did:    urepo.Repo.Did,
-		Active: true,
-		Status: nil,
+		Active: urepo.Active(),
+		Status: urepo.Status(),
 		Rev:    &urepo.Rev,
 	})
 }
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/haileyok/cocoon/internal/helpers"
 	"github.com/haileyok/cocoon/models"
 	"github.com/ipfs/go-cid"
@@ -18,6 +19,20 @@ func (s *Server) handleSyncListBlobs(e echo.Context) error {
 		return helpers.InputError(e, nil)
 	}
 
+	urepo, err := s.getRepoActorByDid(did)
+	if err != nil {
+		s.logger.Error("error looking up repo", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if urepo.Repo.Did == "" {
+		return helpers.InputError(e, to.StringPtr("RepoNotFound"))
+	}
+
+	if status := urepo.Status(); status != nil {
+		return helpers.InputError(e, to.StringPtr(repoStatusError(*status)))
+	}
+
 	// TODO: add tid param
 	cursor := e.QueryParam("cursor")
 	limit, err := getLimitFromContext(e, 50)
@@ -35,7 +50,7 @@ func (s *Server) handleSyncListBlobs(e echo.Context) error {
 	params = append(params, limit)
 
 	var blobs []models.Blob
-	if err := s.db.Raw("SELECT * FROM blobs WHERE did = ? "+cursorquery+" ORDER BY created_at DESC LIMIT ?", params...).Scan(&blobs).Error; err != nil {
+	if err := s.db.Raw("SELECT * FROM blobs WHERE did = ? AND takedown_ref IS NULL "+cursorquery+" ORDER BY created_at DESC LIMIT ?", params...).Scan(&blobs).Error; err != nil {
 		s.logger.Error("error getting records", "error", err)
 		return helpers.ServerError(e, nil)
 	}
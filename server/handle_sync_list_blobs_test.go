@@ -0,0 +1,40 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestSyncBlobsUnknownRepo(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	tests := []struct {
+		name    string
+		target  string
+		handler func(echo.Context) error
+	}{
+		{"getBlob", "/xrpc/com.atproto.sync.getBlob?did=did:plc:nobody&cid=bafkreie7q3iidccmpvszul7kudcvvuavuo7u6gzlbobczuk5nqk3b4akba", s.handleSyncGetBlob},
+		{"listBlobs", "/xrpc/com.atproto.sync.listBlobs?did=did:plc:nobody", s.handleSyncListBlobs},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, rec := newTestContext(http.MethodGet, tt.target, "")
+			if err := tt.handler(e); err != nil {
+				t.Fatal(err)
+			}
+
+			var body map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+
+			if rec.Code != 400 || body["error"] != "RepoNotFound" {
+				t.Fatalf("expected 400 RepoNotFound, got %d: %v", rec.Code, body)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
 )
 
 func (s *Server) handleWellKnown(e echo.Context) error {
@@ -19,3 +20,41 @@ func (s *Server) handleWellKnown(e echo.Context) error {
 		},
 	})
 }
+
+// serves /.well-known/atproto-did for handles that live under one of our user domains. requests
+// are routed here by handleUserDomainMiddleware based on the host header
+func (s *Server) handleAtprotoDid(e echo.Context, handle string) error {
+	actor, err := s.getActorByHandle(handle)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return e.String(404, "no user by that handle exists on this server")
+		}
+
+		s.logger.Error("error looking up actor by handle", "handle", handle, "error", err)
+		return e.String(500, "internal server error")
+	}
+
+	return e.String(200, actor.Did)
+}
+
+// serves /.well-known/did.json for did:webs that live under one of our user domains. requests are
+// routed here by handleUserDomainMiddleware based on the host header
+func (s *Server) handleDidWebDocument(e echo.Context, domain string) error {
+	urepo, err := s.getRepoActorByDid("did:web:" + domain)
+	if err != nil {
+		s.logger.Error("error looking up repo", "domain", domain, "error", err)
+		return e.String(500, "internal server error")
+	}
+
+	if urepo.Repo.Did == "" {
+		return e.String(404, "no did by that name exists on this server")
+	}
+
+	doc, err := s.didWebDocument(urepo)
+	if err != nil {
+		s.logger.Error("error creating did document", "did", urepo.Repo.Did, "error", err)
+		return e.String(500, "internal server error")
+	}
+
+	return e.JSON(200, doc)
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/haileyok/cocoon/models"
+)
+
+const (
+	minLocalHandleLength = 3
+	maxLocalHandleLength = 18
+)
+
+// handles that nobody should be able to register under one of our domains
+var reservedHandles = map[string]struct{}{
+	"about": {}, "abuse": {}, "account": {}, "accounts": {}, "admin": {}, "administrator": {},
+	"api": {}, "app": {}, "atproto": {}, "auth": {}, "blog": {}, "bluesky": {}, "bsky": {},
+	"contact": {}, "dev": {}, "did": {}, "dns": {}, "docs": {}, "email": {}, "feed": {},
+	"feeds": {}, "ftp": {}, "help": {}, "helpdesk": {}, "hostmaster": {}, "info": {},
+	"legal": {}, "login": {}, "logout": {}, "mail": {}, "mod": {}, "moderation": {},
+	"moderator": {}, "news": {}, "noreply": {}, "no-reply": {}, "ns1": {}, "ns2": {},
+	"oauth": {}, "official": {}, "pds": {}, "plc": {}, "postmaster": {}, "privacy": {},
+	"register": {}, "root": {}, "safety": {}, "security": {}, "settings": {}, "signup": {},
+	"smtp": {}, "staff": {}, "status": {}, "support": {}, "sysadmin": {}, "system": {},
+	"team": {}, "terms": {}, "trust": {}, "webmaster": {}, "www": {}, "xrpc": {},
+}
+
+type HandleError struct {
+	Name    string
+	Message string
+}
+
+func (he *HandleError) Error() string {
+	return he.Message
+}
+
+// returns the configured user domain that a handle lives under, if any. when user domains are nested
+// (i.e. .example.com and .org.example.com) the longest one wins, whatever order they're configured in
+func (s *Server) handleUserDomain(handle string) (string, bool) {
+	var found string
+	for _, domain := range s.config.UserDomains {
+		if strings.HasSuffix(handle, domain) && len(domain) > len(found) {
+			found = domain
+		}
+	}
+	return found, found != ""
+}
+
+func (s *Server) isLocalHandle(handle string) bool {
+	_, ok := s.handleUserDomain(handle)
+	return ok
+}
+
+// checks a (lowercased, syntactically valid) handle against our own rules. handles under one of our
+// user domains need to be a single label of a reasonable length that isn't reserved. every handle is
+// checked against the blocklist. this does not check that external handles resolve, that needs to
+// happen separately
+func (s *Server) validateHandle(handle string) error {
+	if domain, ok := s.handleUserDomain(handle); ok {
+		front := strings.TrimSuffix(handle, domain)
+
+		if strings.Contains(front, ".") {
+			return &HandleError{Name: "InvalidHandle", Message: fmt.Sprintf("Handles under %s may not contain a period.", strings.TrimPrefix(domain, "."))}
+		}
+
+		if len(front) < minLocalHandleLength {
+			return &HandleError{Name: "InvalidHandle", Message: fmt.Sprintf("Handle too short. Must be at least %d characters.", minLocalHandleLength)}
+		}
+
+		if len(front) > maxLocalHandleLength {
+			return &HandleError{Name: "InvalidHandle", Message: fmt.Sprintf("Handle too long. Must be at most %d characters.", maxLocalHandleLength)}
+		}
+
+		if _, reserved := reservedHandles[front]; reserved {
+			return &HandleError{Name: "HandleNotAvailable", Message: "Reserved handle."}
+		}
+	}
+
+	blocked, err := s.handleIsBlocked(handle)
+	if err != nil {
+		return err
+	}
+
+	if blocked {
+		return &HandleError{Name: "InvalidHandle", Message: "Inappropriate language in handle."}
+	}
+
+	return nil
+}
+
+func (s *Server) handleIsBlocked(handle string) (bool, error) {
+	var terms []models.HandleBlocklistEntry
+	if err := s.db.Raw("SELECT * FROM handle_blocklist_entries").Scan(&terms).Error; err != nil {
+		return false, err
+	}
+
+	// only the part the user picked counts, otherwise a term that happens to be inside one of our user
+	// domains would block every handle under it
+	if domain, ok := s.handleUserDomain(handle); ok {
+		handle = strings.TrimSuffix(handle, domain)
+	}
+
+	// strip separators so that "b-a-d.example.com" still matches "bad"
+	normalized := strings.NewReplacer("-", "", ".", "").Replace(handle)
+
+	for _, t := range terms {
+		if strings.Contains(normalized, t.Term) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// makes sure that a handle on someone else's domain points at the did, either through dns or
+// well-known. for dids whose documents we don't manage ourselves, the did document also has to claim the handle
+func (s *Server) verifyExternalHandle(ctx context.Context, handle string, did string) error {
+	ctx = context.WithValue(ctx, "skip-cache", true)
+
+	hv := s.passport.VerifyHandle(ctx, handle, did)
+	if !hv.Verified() {
+		return &HandleError{
+			Name:    "InvalidHandle",
+			Message: fmt.Sprintf("Handle %s does not resolve to %s. %s", handle, did, hv.Summary()),
+		}
+	}
+
+	// we write the handle into the did document ourselves for these
+	if strings.HasPrefix(did, "did:plc:") || s.isLocalDidWeb(did) {
+		return nil
+	}
+
+	doc, err := s.passport.FetchDoc(ctx, did)
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(doc.AlsoKnownAs, "at://"+handle) {
+		return &HandleError{
+			Name:    "InvalidHandle",
+			Message: fmt.Sprintf("The DID document for %s does not list at://%s in alsoKnownAs", did, handle),
+		}
+	}
+
+	return nil
+}
+
+// enforces the cooldown between handle changes and the daily limit on them
+func (s *Server) checkHandleChangeAllowed(did string) error {
+	var last models.HandleChange
+	if err := s.db.Raw("SELECT * FROM handle_changes WHERE did = ? ORDER BY created_at DESC LIMIT 1", did).Scan(&last).Error; err != nil {
+		return err
+	}
+
+	if last.ID != 0 && s.config.HandleChangeCooldown > 0 {
+		if wait := time.Until(last.CreatedAt.Add(s.config.HandleChangeCooldown)); wait > 0 {
+			return &HandleError{
+				Name:    "RateLimitExceeded",
+				Message: fmt.Sprintf("Your handle was changed recently. Try again in %s.", wait.Round(time.Second)),
+			}
+		}
+	}
+
+	if s.config.HandleChangeLimit > 0 {
+		var count int
+		if err := s.db.Raw("SELECT COUNT(*) FROM handle_changes WHERE did = ? AND created_at > ?", did, time.Now().Add(-24*time.Hour)).Scan(&count).Error; err != nil {
+			return err
+		}
+
+		if count >= s.config.HandleChangeLimit {
+			return &HandleError{
+				Name:    "RateLimitExceeded",
+				Message: fmt.Sprintf("Handles can only be changed %d times a day.", s.config.HandleChangeLimit),
+			}
+		}
+	}
+
+	return nil
+}
+
+func normalizeUserDomains(hostname string, domains []string) []string {
+	if len(domains) == 0 {
+		return []string{"." + hostname}
+	}
+
+	var normalized []string
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if d == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(d, ".") {
+			d = "." + d
+		}
+
+		normalized = append(normalized, d)
+	}
+
+	return normalized
+}
@@ -0,0 +1,28 @@
+package server
+
+import "testing"
+
+func TestHandleUserDomain(t *testing.T) {
+	for _, domains := range [][]string{
+		{".example.com", ".org.example.com"},
+		{".org.example.com", ".example.com"},
+	} {
+		s := &Server{config: &config{UserDomains: domains}}
+
+		tests := []struct {
+			handle string
+			want   string
+		}{
+			{"alice.example.com", ".example.com"},
+			{"alice.org.example.com", ".org.example.com"},
+			{"alice.other.com", ""},
+		}
+
+		for _, tt := range tests {
+			got, ok := s.handleUserDomain(tt.handle)
+			if got != tt.want || ok != (tt.want != "") {
+				t.Errorf("handleUserDomain(%s) with %v = %q, want %q", tt.handle, domains, got, tt.want)
+			}
+		}
+	}
+}
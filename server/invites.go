@@ -0,0 +1,191 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"gorm.io/gorm"
+)
+
+type ComAtprotoServerDefsInviteCode struct {
+	Code       string                              `json:"code"`
+	Available  int                                 `json:"available"`
+	Disabled   bool                                `json:"disabled"`
+	ForAccount string                              `json:"forAccount"`
+	CreatedBy  string                              `json:"createdBy"`
+	CreatedAt  string                              `json:"createdAt"`
+	Uses       []ComAtprotoServerDefsInviteCodeUse `json:"uses"`
+}
+
+type ComAtprotoServerDefsInviteCodeUse struct {
+	UsedBy string `json:"usedBy"`
+	UsedAt string `json:"usedAt"`
+}
+
+var errInviteCodeUnusable = errors.New("invite code can't be used")
+
+func newInviteCode() string {
+	return fmt.Sprintf("%s-%s", helpers.RandomVarchar(5), helpers.RandomVarchar(5))
+}
+
+func (s *Server) newInviteCodeModel(code, forAccount, createdBy string, uses int) models.InviteCode {
+	ic := models.InviteCode{
+		Code:              code,
+		Did:               forAccount,
+		RemainingUseCount: uses,
+		CreatedBy:         createdBy,
+		CreatedAt:         time.Now(),
+	}
+
+	if s.config.InviteCodeExpiry > 0 {
+		eat := ic.CreatedAt.Add(s.config.InviteCodeExpiry)
+		ic.ExpiresAt = &eat
+	}
+
+	return ic
+}
+
+func inviteCodeUsable(ic *models.InviteCode) bool {
+	if ic.Code == "" || ic.Disabled || ic.RemainingUseCount < 1 {
+		return false
+	}
+
+	if ic.ExpiresAt != nil && time.Now().After(*ic.ExpiresAt) {
+		return false
+	}
+
+	return true
+}
+
+// takes one use from the code for did. the use is only taken if there's one left, so two signups racing for
+// the last use can't both get it
+func useInviteCode(tx *gorm.DB, code, did string) error {
+	res := tx.Exec("UPDATE invite_codes SET remaining_use_count = remaining_use_count - 1 WHERE code = ? AND remaining_use_count > 0 AND NOT disabled AND (expires_at IS NULL OR expires_at > ?)", code, time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return errInviteCodeUnusable
+	}
+
+	return tx.Create(&models.InviteCodeUse{
+		Code:   code,
+		UsedBy: did,
+		UsedAt: time.Now(),
+	}).Error
+}
+
+func (s *Server) getInviteCodeViews(codes []models.InviteCode) ([]ComAtprotoServerDefsInviteCode, error) {
+	views := []ComAtprotoServerDefsInviteCode{}
+	if len(codes) == 0 {
+		return views, nil
+	}
+
+	strs := make([]string, 0, len(codes))
+	for _, ic := range codes {
+		strs = append(strs, ic.Code)
+	}
+
+	var uses []models.InviteCodeUse
+	if err := s.db.Raw("SELECT * FROM invite_code_uses WHERE code IN ? ORDER BY used_at ASC", strs).Scan(&uses).Error; err != nil {
+		return nil, err
+	}
+
+	usesByCode := map[string][]ComAtprotoServerDefsInviteCodeUse{}
+	for _, u := range uses {
+		usesByCode[u.Code] = append(usesByCode[u.Code], ComAtprotoServerDefsInviteCodeUse{
+			UsedBy: u.UsedBy,
+			UsedAt: u.UsedAt.Format(time.RFC3339),
+		})
+	}
+
+	for _, ic := range codes {
+		createdBy := ic.CreatedBy
+		if createdBy == "" {
+			createdBy = "admin"
+		}
+
+		icuses := usesByCode[ic.Code]
+		if icuses == nil {
+			icuses = []ComAtprotoServerDefsInviteCodeUse{}
+		}
+
+		views = append(views, ComAtprotoServerDefsInviteCode{
+			Code:       ic.Code,
+			Available:  ic.RemainingUseCount,
+			Disabled:   ic.Disabled || (ic.ExpiresAt != nil && time.Now().After(*ic.ExpiresAt)),
+			ForAccount: ic.Did,
+			CreatedBy:  createdBy,
+			CreatedAt:  ic.CreatedAt.Format(time.RFC3339),
+			Uses:       icuses,
+		})
+	}
+
+	return views, nil
+}
+
+func (s *Server) getInviteCodesForAccount(did string) ([]models.InviteCode, error) {
+	var codes []models.InviteCode
+	if err := s.db.Raw("SELECT * FROM invite_codes WHERE did = ? ORDER BY created_at DESC", did).Scan(&codes).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// returns the code that the given account signed up with, if any
+func (s *Server) getInvitedBy(did string) (*models.InviteCode, error) {
+	var ic models.InviteCode
+	if err := s.db.Raw("SELECT ic.* FROM invite_codes ic JOIN invite_code_uses u ON u.code = ic.code WHERE u.used_by = ?", did).Scan(&ic).Error; err != nil {
+		return nil, err
+	}
+
+	if ic.Code == "" {
+		return nil, nil
+	}
+
+	return &ic, nil
+}
+
+// the most unused codes an account can have from grantEarnedInviteCodes at once. anything earned past
+// this is held back until some of them are used or expire
+const maxOutstandingEarnedInvites = 5
+
+// hands out any invites that an account has earned since it last asked. an account earns one
+// single-use code per InviteInterval that it has existed, but never has more than
+// maxOutstandingEarnedInvites unused ones
+func (s *Server) grantEarnedInviteCodes(urepo *models.RepoActor) error {
+	if s.config.InviteInterval <= 0 || urepo.InvitesDisabled {
+		return nil
+	}
+
+	earned := int64(time.Since(urepo.Repo.CreatedAt) / s.config.InviteInterval)
+	if earned < 1 {
+		return nil
+	}
+
+	// counting and granting in one transaction keeps two requests at once from both handing out the same codes
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var granted int64
+		if err := tx.Raw("SELECT COUNT(*) FROM invite_codes WHERE did = ? AND created_by = ?", urepo.Repo.Did, urepo.Repo.Did).Scan(&granted).Error; err != nil {
+			return err
+		}
+
+		var outstanding int64
+		if err := tx.Raw("SELECT COUNT(*) FROM invite_codes WHERE did = ? AND created_by = ? AND remaining_use_count > 0 AND NOT disabled AND (expires_at IS NULL OR expires_at > ?)", urepo.Repo.Did, urepo.Repo.Did, time.Now()).Scan(&outstanding).Error; err != nil {
+			return err
+		}
+
+		for range min(earned-granted, maxOutstandingEarnedInvites-outstanding) {
+			ic := s.newInviteCodeModel(newInviteCode(), urepo.Repo.Did, urepo.Repo.Did, 1)
+			if err := tx.Create(&ic).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUseInviteCode(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	ic := s.newInviteCodeModel(newInviteCode(), "did:plc:inviter", "did:plc:inviter", 1)
+	if err := s.db.Create(&ic).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := useInviteCode(s.db, ic.Code, "did:plc:alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := useInviteCode(s.db, ic.Code, "did:plc:bob"); !errors.Is(err, errInviteCodeUnusable) {
+		t.Fatalf("expected the used up code to be refused, got %v", err)
+	}
+
+	if n := countRows(t, s, "SELECT remaining_use_count FROM invite_codes WHERE code = ?", ic.Code); n != 0 {
+		t.Fatalf("expected no uses left, got %d", n)
+	}
+
+	if n := countRows(t, s, "SELECT COUNT(*) FROM invite_code_uses WHERE code = ?", ic.Code); n != 1 {
+		t.Fatalf("expected one recorded use, got %d", n)
+	}
+}
@@ -0,0 +1,564 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	// every app.bsky.* call goes to the appview
+	LocalAppviewOff = "off"
+	// the methods we can answer ourselves are served locally when the appview can't be reached
+	LocalAppviewFallback = "fallback"
+	// the methods we can answer ourselves never go to the appview at all
+	LocalAppviewOnly = "only"
+)
+
+// the lexicon's maximum for both depth and parentHeight in getPostThread
+const localThreadMaxDepth = 1000
+
+func validateLocalAppview(mode string) error {
+	switch mode {
+	case LocalAppviewOff, LocalAppviewFallback, LocalAppviewOnly:
+		return nil
+	default:
+		return fmt.Errorf("unknown local appview mode %q", mode)
+	}
+}
+
+// a small subset of the appview built only from records hosted here. there are no follower counts, likes,
+// reposts or anything else that needs the rest of the network, it's just enough for demos and test setups
+var localAppviewMethods = map[string]func(*Server, echo.Context) error{
+	"app.bsky.actor.getProfile":   (*Server).handleLocalGetProfile,
+	"app.bsky.feed.getAuthorFeed": (*Server).handleLocalGetAuthorFeed,
+	"app.bsky.feed.getPosts":      (*Server).handleLocalGetPosts,
+	"app.bsky.feed.getPostThread": (*Server).handleLocalGetPostThread,
+}
+
+func (s *Server) localAppviewFallback(nsid string) (func(*Server, echo.Context) error, bool) {
+	if s.config.LocalAppview != LocalAppviewFallback {
+		return nil, false
+	}
+
+	handler, ok := localAppviewMethods[nsid]
+	return handler, ok
+}
+
+// caches accounts and profiles for the length of a single request, since the same author shows up over and over
+// in feeds and threads
+type localViewer struct {
+	s        *Server
+	actors   map[string]*models.RepoActor
+	profiles map[string]*localRecord
+}
+
+func (s *Server) newLocalViewer() *localViewer {
+	return &localViewer{
+		s:        s,
+		actors:   map[string]*models.RepoActor{},
+		profiles: map[string]*localRecord{},
+	}
+}
+
+func (v *localViewer) resolveActor(actor string) (string, error) {
+	if strings.HasPrefix(actor, "did:") {
+		return actor, nil
+	}
+
+	a, err := v.s.getActorByHandle(actor)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return a.Did, nil
+}
+
+// returns nil if the did isn't an active account here
+func (v *localViewer) actor(did string) (*models.RepoActor, *localRecord, error) {
+	if urepo, ok := v.actors[did]; ok {
+		return urepo, v.profiles[did], nil
+	}
+
+	urepo, err := v.s.getRepoActorByDid(did)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if urepo.Repo.Did == "" || urepo.Status() != nil {
+		v.actors[did] = nil
+		return nil, nil, nil
+	}
+
+	var row models.Record
+	if err := v.s.db.Raw("SELECT * FROM records WHERE did = ? AND nsid = ? AND rkey = ? AND takedown_ref IS NULL", did, "app.bsky.actor.profile", "self").Scan(&row).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var profile *localRecord
+	if row.Did != "" {
+		rec, err := localRecordFromRow(row)
+		if err != nil {
+			v.s.logger.Warn("error decoding local profile", "did", did, "error", err)
+		} else {
+			profile = &rec
+		}
+	}
+
+	v.actors[did] = urepo
+	v.profiles[did] = profile
+
+	return urepo, profile, nil
+}
+
+func (v *localViewer) post(uri string) (*localRecord, error) {
+	aturi, err := syntax.ParseATURI(uri)
+	if err != nil || aturi.Collection().String() != "app.bsky.feed.post" {
+		return nil, nil
+	}
+
+	did, err := v.resolveActor(aturi.Authority().String())
+	if err != nil || did == "" {
+		return nil, err
+	}
+
+	var row models.Record
+	if err := v.s.db.Raw("SELECT * FROM records WHERE did = ? AND nsid = ? AND rkey = ? AND takedown_ref IS NULL", did, "app.bsky.feed.post", aturi.RecordKey().String()).Scan(&row).Error; err != nil {
+		return nil, err
+	}
+
+	if row.Did == "" {
+		return nil, nil
+	}
+
+	rec, err := localRecordFromRow(row)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rec, nil
+}
+
+func (v *localViewer) postView(post localRecord) (map[string]any, error) {
+	aturi, err := syntax.ParseATURI(post.Uri)
+	if err != nil {
+		return nil, err
+	}
+
+	urepo, profile, err := v.actor(aturi.Authority().String())
+	if err != nil || urepo == nil {
+		return nil, err
+	}
+
+	return v.s.localPostView(urepo, profile, post), nil
+}
+
+func notFoundPost(uri string) map[string]any {
+	return map[string]any{
+		"$type":    "app.bsky.feed.defs#notFoundPost",
+		"uri":      uri,
+		"notFound": true,
+	}
+}
+
+func (v *localViewer) postViewOrNotFound(uri string) (map[string]any, error) {
+	post, err := v.post(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if post != nil {
+		view, err := v.postView(*post)
+		if err != nil {
+			return nil, err
+		}
+		if view != nil {
+			return view, nil
+		}
+	}
+
+	return notFoundPost(uri), nil
+}
+
+func (v *localViewer) feedItem(post localRecord) (map[string]any, error) {
+	view, err := v.postView(post)
+	if err != nil || view == nil {
+		return nil, err
+	}
+
+	item := map[string]any{
+		"post": view,
+	}
+
+	if reply, ok := post.Value["reply"].(map[string]any); ok {
+		root, _ := reply["root"].(map[string]any)
+		rootUri, _ := root["uri"].(string)
+
+		rootView, err := v.postViewOrNotFound(rootUri)
+		if err != nil {
+			return nil, err
+		}
+
+		parentView, err := v.postViewOrNotFound(replyParentUri(post))
+		if err != nil {
+			return nil, err
+		}
+
+		item["reply"] = map[string]any{
+			"root":   rootView,
+			"parent": parentView,
+		}
+	}
+
+	return item, nil
+}
+
+func (s *Server) handleLocalGetProfile(e echo.Context) error {
+	v := s.newLocalViewer()
+
+	did, err := v.resolveActor(e.QueryParam("actor"))
+	if err != nil {
+		s.logger.Error("error resolving actor", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	var urepo *models.RepoActor
+	var profile *localRecord
+	if did != "" {
+		urepo, profile, err = v.actor(did)
+		if err != nil {
+			s.logger.Error("error getting local actor", "error", err)
+			return helpers.ServerError(e, nil)
+		}
+	}
+
+	if urepo == nil {
+		return helpers.InputErrorWithMessage(e, "InvalidRequest", "Profile not found")
+	}
+
+	var posts, follows int64
+	if err := s.db.Raw("SELECT COUNT(*) FROM records WHERE did = ? AND nsid = ? AND takedown_ref IS NULL", did, "app.bsky.feed.post").Scan(&posts).Error; err != nil {
+		s.logger.Error("error counting posts", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if err := s.db.Raw("SELECT COUNT(*) FROM records WHERE did = ? AND nsid = ? AND takedown_ref IS NULL", did, "app.bsky.graph.follow").Scan(&follows).Error; err != nil {
+		s.logger.Error("error counting follows", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	view := map[string]any{
+		"did":            urepo.Repo.Did,
+		"handle":         urepo.Handle,
+		"followersCount": 0,
+		"followsCount":   follows,
+		"postsCount":     posts,
+		"indexedAt":      urepo.Repo.CreatedAt.Format(time.RFC3339),
+		"createdAt":      urepo.Repo.CreatedAt.Format(time.RFC3339),
+		"labels":         []any{},
+		"viewer":         map[string]any{},
+	}
+	s.updateProfileView(view, did, profile, true)
+
+	return e.JSON(200, view)
+}
+
+func (s *Server) handleLocalGetAuthorFeed(e echo.Context) error {
+	cursor := e.QueryParam("cursor")
+	filter := e.QueryParam("filter")
+	limit, err := getLimitFromContext(e, 50)
+	if err != nil || limit < 1 || limit > 100 {
+		return helpers.InputError(e, nil)
+	}
+
+	v := s.newLocalViewer()
+
+	did, err := v.resolveActor(e.QueryParam("actor"))
+	if err != nil {
+		s.logger.Error("error resolving actor", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	var urepo *models.RepoActor
+	if did != "" {
+		urepo, _, err = v.actor(did)
+		if err != nil {
+			s.logger.Error("error getting local actor", "error", err)
+			return helpers.ServerError(e, nil)
+		}
+	}
+
+	if urepo == nil {
+		return helpers.InputErrorWithMessage(e, "InvalidRequest", "Profile not found")
+	}
+
+	params := []any{did, "app.bsky.feed.post"}
+	cursorquery := ""
+	if cursor != "" {
+		params = append(params, cursor)
+		cursorquery = "AND created_at < ? "
+	}
+	params = append(params, limit)
+
+	var rows []models.Record
+	if err := s.db.Raw("SELECT * FROM records WHERE did = ? AND nsid = ? AND takedown_ref IS NULL "+cursorquery+"ORDER BY created_at DESC LIMIT ?", params...).Scan(&rows).Error; err != nil {
+		s.logger.Error("error getting posts", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	feed := []any{}
+	for _, row := range rows {
+		post, err := localRecordFromRow(row)
+		if err != nil {
+			s.logger.Warn("error decoding local post", "did", row.Did, "rkey", row.Rkey, "error", err)
+			continue
+		}
+
+		reply, isReply := post.Value["reply"].(map[string]any)
+		switch filter {
+		case "posts_no_replies":
+			if isReply {
+				continue
+			}
+		case "posts_and_author_threads":
+			if isReply {
+				root, _ := reply["root"].(map[string]any)
+				rootUri, _ := root["uri"].(string)
+				if !strings.HasPrefix(rootUri, "at://"+did+"/") {
+					continue
+				}
+			}
+		case "posts_with_media":
+			embed, _ := post.Value["embed"].(map[string]any)
+			if embed["$type"] != "app.bsky.embed.images" {
+				continue
+			}
+		}
+
+		item, err := v.feedItem(post)
+		if err != nil {
+			s.logger.Error("error building feed item", "error", err)
+			return helpers.ServerError(e, nil)
+		}
+
+		if item != nil {
+			feed = append(feed, item)
+		}
+	}
+
+	resp := map[string]any{
+		"feed": feed,
+	}
+
+	if len(rows) == limit {
+		resp["cursor"] = rows[len(rows)-1].CreatedAt
+	}
+
+	return e.JSON(200, resp)
+}
+
+func (s *Server) handleLocalGetPosts(e echo.Context) error {
+	uris := e.QueryParams()["uris"]
+	if len(uris) > 25 {
+		return helpers.InputErrorWithMessage(e, "InvalidRequest", "Too many uris")
+	}
+
+	v := s.newLocalViewer()
+
+	posts := []any{}
+	for _, uri := range uris {
+		post, err := v.post(uri)
+		if err != nil {
+			s.logger.Error("error getting local post", "uri", uri, "error", err)
+			return helpers.ServerError(e, nil)
+		}
+
+		if post == nil {
+			continue
+		}
+
+		view, err := v.postView(*post)
+		if err != nil {
+			s.logger.Error("error building post view", "uri", uri, "error", err)
+			return helpers.ServerError(e, nil)
+		}
+
+		if view != nil {
+			posts = append(posts, view)
+		}
+	}
+
+	return e.JSON(200, map[string]any{
+		"posts": posts,
+	})
+}
+
+func (s *Server) handleLocalGetPostThread(e echo.Context) error {
+	uri := e.QueryParam("uri")
+
+	depth, err := strconv.Atoi(e.QueryParam("depth"))
+	if err != nil {
+		depth = 6
+	}
+	depth = min(max(depth, 0), localThreadMaxDepth)
+
+	parentHeight, err := strconv.Atoi(e.QueryParam("parentHeight"))
+	if err != nil {
+		parentHeight = 80
+	}
+	parentHeight = min(max(parentHeight, 0), localThreadMaxDepth)
+
+	v := s.newLocalViewer()
+
+	post, err := v.post(uri)
+	if err != nil {
+		s.logger.Error("error getting local post", "uri", uri, "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if post == nil {
+		return helpers.InputErrorWithMessage(e, "NotFound", "Post not found: "+uri)
+	}
+
+	root := replyRootUri(*post)
+	if root == "" {
+		root = post.Uri
+	}
+
+	// posts written before reply_root existed have it null. they're filled in as threads are read, so each one
+	// only gets decoded here once
+	var rows []models.Record
+	if err := s.db.Raw("SELECT * FROM records WHERE nsid = ? AND takedown_ref IS NULL AND (reply_root = ? OR reply_root IS NULL) ORDER BY created_at ASC", "app.bsky.feed.post", root).Scan(&rows).Error; err != nil {
+		s.logger.Error("error getting posts", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	children := map[string][]localRecord{}
+	for _, row := range rows {
+		if row.ReplyRoot == nil {
+			rr := recordReplyRoot(row.Nsid, row.Value)
+			if err := s.db.Exec("UPDATE records SET reply_root = ? WHERE did = ? AND nsid = ? AND rkey = ?", *rr, row.Did, row.Nsid, row.Rkey).Error; err != nil {
+				s.logger.Error("error setting reply root", "error", err)
+			}
+			if *rr != root {
+				continue
+			}
+		}
+
+		rec, err := localRecordFromRow(row)
+		if err != nil {
+			continue
+		}
+		if parent := replyParentUri(rec); parent != "" {
+			children[parent] = append(children[parent], rec)
+		}
+	}
+
+	thread, err := v.threadNode(*post, children, depth, map[string]bool{})
+	if err != nil {
+		s.logger.Error("error building thread", "uri", uri, "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if thread == nil {
+		return helpers.InputErrorWithMessage(e, "NotFound", "Post not found: "+uri)
+	}
+
+	if parentUri := replyParentUri(*post); parentUri != "" && parentHeight > 0 {
+		parent, err := v.threadParent(parentUri, parentHeight, map[string]bool{post.Uri: true})
+		if err != nil {
+			s.logger.Error("error building thread parents", "uri", uri, "error", err)
+			return helpers.ServerError(e, nil)
+		}
+		thread["parent"] = parent
+	}
+
+	return e.JSON(200, map[string]any{
+		"thread": thread,
+	})
+}
+
+// seen holds the uris already in the thread. a post can name itself (or a later post) as its parent, since the
+// rkey is known before the record is written, and that would otherwise loop forever
+func (v *localViewer) threadNode(post localRecord, children map[string][]localRecord, depth int, seen map[string]bool) (map[string]any, error) {
+	if seen[post.Uri] {
+		return nil, nil
+	}
+	seen[post.Uri] = true
+
+	view, err := v.postView(post)
+	if err != nil || view == nil {
+		return nil, err
+	}
+	view["replyCount"] = len(children[post.Uri])
+
+	replies := []any{}
+	if depth > 0 {
+		for _, child := range children[post.Uri] {
+			node, err := v.threadNode(child, children, depth-1, seen)
+			if err != nil {
+				return nil, err
+			}
+			if node != nil {
+				replies = append(replies, node)
+			}
+		}
+	}
+
+	return map[string]any{
+		"$type":   "app.bsky.feed.defs#threadViewPost",
+		"post":    view,
+		"replies": replies,
+	}, nil
+}
+
+func (v *localViewer) threadParent(uri string, height int, seen map[string]bool) (map[string]any, error) {
+	if seen[uri] {
+		return notFoundPost(uri), nil
+	}
+	seen[uri] = true
+
+	post, err := v.post(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if post == nil {
+		return notFoundPost(uri), nil
+	}
+
+	view, err := v.postView(*post)
+	if err != nil {
+		return nil, err
+	}
+
+	if view == nil {
+		return notFoundPost(uri), nil
+	}
+
+	node := map[string]any{
+		"$type": "app.bsky.feed.defs#threadViewPost",
+		"post":  view,
+	}
+
+	if parentUri := replyParentUri(*post); parentUri != "" && height > 1 {
+		parent, err := v.threadParent(parentUri, height-1, seen)
+		if err != nil {
+			return nil, err
+		}
+		node["parent"] = parent
+	}
+
+	return node, nil
+}
@@ -61,3 +61,78 @@ func (s *Server) sendEmailVerification(email, handle, code string) error {
 
 	return nil
 }
+
+func (s *Server) sendAuthFactorCode(email, handle, code string) error {
+	s.mailLk.Lock()
+	defer s.mailLk.Unlock()
+
+	s.mail.To(email)
+	s.mail.Subject("Sign in code for " + s.config.Hostname)
+	s.mail.Plain().Set(fmt.Sprintf("Hello %s. Your sign in code is %s. This code will expire in ten minutes. If you did not just try to sign in, someone else knows your password and you should change it.", handle, code))
+
+	if err := s.mail.Send(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Server) sendAccountDelete(email, handle, code string) error {
+	s.mailLk.Lock()
+	defer s.mailLk.Unlock()
+
+	s.mail.To(email)
+	s.mail.Subject("Account deletion for " + s.config.Hostname)
+	s.mail.Plain().Set(fmt.Sprintf("Hello %s. Your account deletion code is %s. This code will expire in ten minutes. If you did not request this, you can ignore this email.", handle, code))
+
+	if err := s.mail.Send(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Server) sendPlcOperation(email, handle, code string) error {
+	s.mailLk.Lock()
+	defer s.mailLk.Unlock()
+
+	s.mail.To(email)
+	s.mail.Subject("Identity change for " + s.config.Hostname)
+	s.mail.Plain().Set(fmt.Sprintf("Hello %s. A request was made to change your account's identity (DID document). Your code is %s. This code will expire in ten minutes. If you did not request this, do not share this code with anyone.", handle, code))
+
+	if err := s.mail.Send(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Server) sendAdminEmail(email, subject, content string) error {
+	s.mailLk.Lock()
+	defer s.mailLk.Unlock()
+
+	s.mail.To(email)
+	s.mail.Subject(subject)
+	s.mail.Plain().Set(content)
+
+	if err := s.mail.Send(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Server) sendWaitlistApproval(email, code string) error {
+	s.mailLk.Lock()
+	defer s.mailLk.Unlock()
+
+	s.mail.To(email)
+	s.mail.Subject("You're off the waitlist for " + s.config.Hostname)
+	s.mail.Plain().Set(fmt.Sprintf("Hello! Your request to join %s has been approved. Your invite code is %s.", s.config.Hostname, code))
+
+	if err := s.mail.Send(); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var proxyUpstreamRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "cocoon_proxy_upstream_requests_total",
+	Help: "Proxied requests sent to each upstream, by response status class",
+}, []string{"service", "upstream", "status"})
+
+var proxyUpstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "cocoon_proxy_upstream_request_duration_seconds",
+	Help:    "How long upstreams took to start responding to proxied requests",
+	Buckets: prometheus.DefBuckets,
+}, []string{"service", "upstream"})
+
+var proxyUpstreamHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "cocoon_proxy_upstream_healthy",
+	Help: "Whether the last health check of each upstream succeeded",
+}, []string{"service", "upstream"})
+
+var proxyUpstreamCircuitOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "cocoon_proxy_upstream_circuit_open",
+	Help: "Whether requests to each upstream are currently being skipped after repeated failures",
+}, []string{"service", "upstream"})
@@ -0,0 +1,344 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/haileyok/cocoon/oauth"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	oauthRequestExpiry             = 5 * time.Minute
+	oauthCodeExpiry                = time.Minute
+	oauthAccessTokenExpiry         = time.Hour
+	oauthPublicSessionExpiry       = 14 * 24 * time.Hour
+	oauthConfidentialSessionExpiry = 180 * 24 * time.Hour
+	oauthLoginExpiry               = time.Hour
+	oauthLoginCookie               = "cocoon_oauth_login"
+	oauthRequestUriPrefix          = "urn:ietf:params:oauth:request_uri:"
+)
+
+// methods an oauth session can never call, no matter its scopes. these change how the user logs in or move
+// their account around, which apps shouldn't be able to do on their behalf
+var oauthForbiddenMethods = map[string]bool{
+	"com.atproto.identity.requestPlcOperationSignature": true,
+	"com.atproto.identity.signPlcOperation":             true,
+	"com.atproto.identity.submitPlcOperation":           true,
+	"com.atproto.repo.importRepo":                       true,
+	"com.atproto.server.activateAccount":                true,
+	"com.atproto.server.deactivateAccount":              true,
+	"com.atproto.server.requestAccountDelete":           true,
+	"com.atproto.server.requestEmailUpdate":             true,
+	"com.atproto.server.requestEmailConfirmation":       true,
+	"com.atproto.server.confirmEmail":                   true,
+	"com.atproto.server.updateEmail":                    true,
+	"com.atproto.server.resetPassword":                  true,
+	"cocoon.server.enrollTotp":                          true,
+	"cocoon.server.confirmTotp":                         true,
+	"cocoon.server.disableTotp":                         true,
+	"cocoon.server.createTotpRecoveryCodes":             true,
+	"cocoon.server.listSessions":                        true,
+	"cocoon.server.revokeSession":                       true,
+	"cocoon.server.revokeOtherSessions":                 true,
+}
+
+func (s *Server) oauthIssuer() string {
+	return "https://" + s.config.Hostname
+}
+
+// an access token with just the atproto scope only tells the client who the user is. transition:generic is
+// roughly what an app password would get, and dms need their own scope
+func oauthScopeAllows(scope, nsid string) bool {
+	if nsid == "com.atproto.server.getSession" {
+		return true
+	}
+
+	if oauthForbiddenMethods[nsid] {
+		return false
+	}
+
+	if strings.HasPrefix(nsid, "chat.bsky.") {
+		return oauth.HasScope(scope, oauth.ScopeTransitionChat)
+	}
+
+	return oauth.HasScope(scope, oauth.ScopeTransitionGeneric)
+}
+
+func (s *Server) oauthError(e echo.Context, status int, err error) error {
+	e.Response().Header().Set("DPoP-Nonce", s.dpop.Nonce())
+	e.Response().Header().Set("Cache-Control", "no-store")
+
+	var oerr *oauth.Error
+	if errors.As(err, &oerr) {
+		return e.JSON(status, oerr)
+	}
+
+	if errors.Is(err, oauth.ErrUseDpopNonce) {
+		return e.JSON(400, oauth.NewError("use_dpop_nonce", "Authorization server requires nonce in DPoP proof"))
+	}
+
+	return e.JSON(status, oauth.NewError("invalid_request", err.Error()))
+}
+
+func (s *Server) verifyDpop(e echo.Context, accessToken string) (*oauth.DpopProof, error) {
+	proof, err := s.dpop.Verify(e.Request().Header.Get("DPoP"), e.Request().Method, s.oauthIssuer()+e.Request().URL.Path, accessToken)
+	if err != nil {
+		if errors.Is(err, oauth.ErrUseDpopNonce) {
+			return nil, err
+		}
+		return nil, oauth.NewError("invalid_dpop_proof", err.Error())
+	}
+
+	return proof, nil
+}
+
+// returns the auth method the client used, which has to stay the same for the whole session
+func (s *Server) authenticateOAuthClient(e echo.Context, client *oauth.ClientMetadata) (string, error) {
+	if !client.IsConfidential() {
+		if e.FormValue("client_assertion") != "" {
+			return "", oauth.NewError("invalid_client", "client is registered as a public client")
+		}
+		return "none", nil
+	}
+
+	if err := oauth.VerifyClientAssertion(e.Request().Context(), s.oauthHttp, client, e.FormValue("client_assertion_type"), e.FormValue("client_assertion"), s.oauthIssuer(), s.oauthReplay); err != nil {
+		return "", err
+	}
+
+	return client.TokenEndpointAuthMethod, nil
+}
+
+func (s *Server) getOAuthRequest(requestUri string) (*models.OAuthAuthorizationRequest, error) {
+	if !strings.HasPrefix(requestUri, oauthRequestUriPrefix) {
+		return nil, nil
+	}
+
+	var req models.OAuthAuthorizationRequest
+	if err := s.db.Raw("SELECT * FROM oauth_authorization_requests WHERE id = ? AND expires_at > ?", strings.TrimPrefix(requestUri, oauthRequestUriPrefix), time.Now()).Scan(&req).Error; err != nil {
+		return nil, err
+	}
+
+	if req.ID == "" {
+		return nil, nil
+	}
+
+	return &req, nil
+}
+
+func (s *Server) deleteExpiredOAuthRequests() error {
+	return s.db.Exec("DELETE FROM oauth_authorization_requests WHERE expires_at < ?", time.Now()).Error
+}
+
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+	Sub          string `json:"sub"`
+}
+
+func (s *Server) issueOAuthAccessToken(token *models.OAuthToken) (*OAuthTokenResponse, error) {
+	now := time.Now()
+
+	claims := jwt.MapClaims{
+		"iss":       s.oauthIssuer(),
+		"aud":       s.config.Did,
+		"sub":       token.Did,
+		"scope":     token.Scope,
+		"client_id": token.ClientId,
+		"cnf": map[string]string{
+			"jkt": token.DpopJkt,
+		},
+		"iat": now.UTC().Unix(),
+		"exp": now.Add(oauthAccessTokenExpiry).UTC().Unix(),
+		"jti": token.ID,
+	}
+
+	at := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	at.Header["typ"] = "at+jwt"
+
+	atstr, err := at.SignedString(s.privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OAuthTokenResponse{
+		AccessToken:  atstr,
+		TokenType:    "DPoP",
+		ExpiresIn:    int64(oauthAccessTokenExpiry.Seconds()),
+		RefreshToken: token.RefreshToken,
+		Scope:        token.Scope,
+		Sub:          token.Did,
+	}, nil
+}
+
+// resource servers answer nonce problems with a 401 and a WWW-Authenticate header instead of a 400
+func (s *Server) oauthResourceError(e echo.Context, err error) error {
+	e.Response().Header().Set("DPoP-Nonce", s.dpop.Nonce())
+
+	if errors.Is(err, oauth.ErrUseDpopNonce) {
+		e.Response().Header().Set("WWW-Authenticate", `DPoP error="use_dpop_nonce", error_description="Resource server requires nonce in DPoP proof"`)
+		return e.JSON(401, map[string]string{"error": "use_dpop_nonce", "message": "Resource server requires nonce in DPoP proof"})
+	}
+
+	e.Response().Header().Set("WWW-Authenticate", `DPoP error="invalid_token"`)
+	return e.JSON(401, map[string]string{"error": "InvalidToken", "message": err.Error()})
+}
+
+// the oauth half of handleSessionMiddleware, for requests with a DPoP bound access token
+func (s *Server) handleOAuthSession(e echo.Context, next echo.HandlerFunc, tokenstr string) error {
+	token, err := new(jwt.Parser).Parse(tokenstr, func(t *jwt.Token) (any, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodECDSA); !ok {
+			return nil, fmt.Errorf("unsupported signing method: %v", t.Header["alg"])
+		}
+
+		return s.privateKey.Public(), nil
+	})
+	if err != nil {
+		return s.oauthResourceError(e, fmt.Errorf("token has expired"))
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || token.Header["typ"] != "at+jwt" {
+		return s.oauthResourceError(e, fmt.Errorf("invalid access token"))
+	}
+
+	jti, _ := claims["jti"].(string)
+	scope, _ := claims["scope"].(string)
+	cnf, _ := claims["cnf"].(map[string]any)
+	jkt, _ := cnf["jkt"].(string)
+
+	proof, err := s.verifyDpop(e, tokenstr)
+	if err != nil {
+		return s.oauthResourceError(e, err)
+	}
+
+	if proof.Jkt != jkt {
+		return s.oauthResourceError(e, fmt.Errorf("dpop key does not match the access token"))
+	}
+
+	var otoken models.OAuthToken
+	if err := s.db.Raw("SELECT * FROM oauth_tokens WHERE id = ? AND expires_at > ?", jti, time.Now()).Scan(&otoken).Error; err != nil {
+		s.logger.Error("error getting oauth token from db", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if otoken.ID == "" {
+		return s.oauthResourceError(e, fmt.Errorf("session has been revoked"))
+	}
+
+	nsid := strings.TrimPrefix(e.Request().URL.Path, "/xrpc/")
+	if nsid == "com.atproto.server.refreshSession" {
+		return helpers.InputError(e, to.StringPtr("InvalidToken"))
+	}
+
+	if !oauthScopeAllows(scope, nsid) {
+		return e.JSON(403, map[string]string{"error": "InsufficientScope", "message": "This token can't be used to call " + nsid})
+	}
+
+	repo, err := s.getRepoActorByDid(otoken.Did)
+	if err != nil {
+		s.logger.Error("error fetching repo", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if repo.TakedownRef != nil {
+		return helpers.InputError(e, to.StringPtr("AccountTakedown"))
+	}
+
+	e.Response().Header().Set("DPoP-Nonce", s.dpop.Nonce())
+
+	e.Set("repo", repo)
+	e.Set("did", otoken.Did)
+	e.Set("token", tokenstr)
+	e.Set("oauthScope", scope)
+
+	return next(e)
+}
+
+// signed in users are remembered for a little while in a cookie, so authorizing another app right after doesn't
+// need the password again
+func (s *Server) setOAuthLoginCookie(e echo.Context, did string) error {
+	now := time.Now()
+	tok := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"scope": "com.atproto.oauthLogin",
+		"aud":   s.config.Did,
+		"sub":   did,
+		"iat":   now.UTC().Unix(),
+		"exp":   now.Add(oauthLoginExpiry).UTC().Unix(),
+	})
+
+	str, err := tok.SignedString(s.privateKey)
+	if err != nil {
+		return err
+	}
+
+	e.SetCookie(&http.Cookie{
+		Name:     oauthLoginCookie,
+		Value:    str,
+		Path:     "/oauth",
+		Expires:  now.Add(oauthLoginExpiry),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return nil
+}
+
+func (s *Server) clearOAuthLoginCookie(e echo.Context) {
+	e.SetCookie(&http.Cookie{
+		Name:     oauthLoginCookie,
+		Value:    "",
+		Path:     "/oauth",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// returns nil if nobody is signed in, or the account can't authorize apps right now
+func (s *Server) getOAuthLogin(e echo.Context) (*models.RepoActor, error) {
+	cookie, err := e.Cookie(oauthLoginCookie)
+	if err != nil {
+		return nil, nil
+	}
+
+	token, err := new(jwt.Parser).Parse(cookie.Value, func(t *jwt.Token) (any, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodECDSA); !ok {
+			return nil, fmt.Errorf("unsupported signing method: %v", t.Header["alg"])
+		}
+
+		return s.privateKey.Public(), nil
+	})
+	if err != nil {
+		return nil, nil
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["scope"] != "com.atproto.oauthLogin" {
+		return nil, nil
+	}
+
+	did, _ := claims["sub"].(string)
+	urepo, err := s.getRepoActorByDid(did)
+	if err != nil {
+		return nil, err
+	}
+
+	if urepo.Repo.Did == "" || urepo.TakedownRef != nil {
+		return nil, nil
+	}
+
+	return urepo, nil
+}
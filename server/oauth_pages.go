@@ -0,0 +1,90 @@
+package server
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+
+	"github.com/haileyok/cocoon/models"
+	"github.com/haileyok/cocoon/oauth"
+	"github.com/labstack/echo/v4"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var oauthTemplates = template.Must(template.ParseFS(templateFS, "templates/*.html"))
+
+var oauthScopeDescriptions = map[string]string{
+	oauth.ScopeAtproto:           "Know which account you are",
+	oauth.ScopeTransitionGeneric: "Read and write to your account, except for changing your login details",
+	oauth.ScopeTransitionChat:    "Read and send your direct messages",
+	oauth.ScopeTransitionEmail:   "See your email address",
+}
+
+type oauthPageData struct {
+	Title      string
+	Hostname   string
+	Error      string
+	Notice     string
+	ClientId   string
+	ClientName string
+	ClientUri  string
+	LogoUri    string
+	RequestUri string
+	Csrf       string
+	LoginHint  string
+	Handle     string
+	AuthFactor bool
+	Scopes     []string
+	// only for form_post responses, the redirect uri has already been checked against the client's metadata
+	RedirectUri template.URL
+	Params      map[string]string
+}
+
+func (s *Server) newOAuthPageData(title string, req *models.OAuthAuthorizationRequest, client *oauth.ClientMetadata) oauthPageData {
+	data := oauthPageData{
+		Title:    title,
+		Hostname: s.config.Hostname,
+	}
+
+	if client != nil {
+		data.ClientId = client.ClientId
+		data.ClientName = client.DisplayName()
+		data.ClientUri = client.ClientUri
+		data.LogoUri = client.LogoUri
+	}
+
+	if req != nil {
+		data.RequestUri = oauthRequestUriPrefix + req.ID
+		data.Csrf = req.Csrf
+		data.LoginHint = req.LoginHint
+
+		for _, sc := range oauth.ParseScopes(req.Scope) {
+			if desc, ok := oauthScopeDescriptions[sc]; ok {
+				data.Scopes = append(data.Scopes, desc)
+			}
+		}
+	}
+
+	return data
+}
+
+func (s *Server) renderOAuthPage(e echo.Context, status int, name string, data oauthPageData) error {
+	var buf bytes.Buffer
+	if err := oauthTemplates.ExecuteTemplate(&buf, name, data); err != nil {
+		s.logger.Error("error rendering oauth page", "page", name, "error", err)
+		return e.String(500, "internal server error")
+	}
+
+	e.Response().Header().Set("Cache-Control", "no-store")
+	e.Response().Header().Set("X-Frame-Options", "DENY")
+
+	return e.HTMLBlob(status, buf.Bytes())
+}
+
+func (s *Server) renderOAuthError(e echo.Context, status int, msg string) error {
+	data := s.newOAuthPageData("Error", nil, nil)
+	data.Error = msg
+	return s.renderOAuthPage(e, status, "oauth_error.html", data)
+}
@@ -0,0 +1,353 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/haileyok/cocoon/models"
+	"github.com/haileyok/cocoon/oauth"
+	"github.com/labstack/echo/v4"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+const (
+	testOAuthClientId    = "https://app.example.com/client-metadata.json"
+	testOAuthRedirectUri = "https://app.example.com/callback"
+	testOAuthDid         = "did:plc:testaccount"
+)
+
+type fakeClientFetcher struct {
+	clients map[string]*oauth.ClientMetadata
+}
+
+func (f *fakeClientFetcher) FetchClient(ctx context.Context, clientId string) (*oauth.ClientMetadata, error) {
+	metadata, ok := f.clients[clientId]
+	if !ok {
+		return nil, fmt.Errorf("client metadata request returned status 404")
+	}
+
+	cp := *metadata
+	return &cp, nil
+}
+
+func newOAuthTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	s, _ := newTestServer(t)
+
+	if err := s.db.Create(&models.Repo{Did: testOAuthDid, Email: "test@example.com"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	fetcher := &fakeClientFetcher{clients: map[string]*oauth.ClientMetadata{
+		testOAuthClientId: {
+			ClientId:              testOAuthClientId,
+			RedirectUris:          []string{testOAuthRedirectUri},
+			GrantTypes:            []string{"authorization_code", "refresh_token"},
+			ResponseTypes:         []string{"code"},
+			Scope:                 "atproto transition:generic",
+			DpopBoundAccessTokens: true,
+		},
+	}}
+
+	s.oauthReplay = oauth.NewReplayCache()
+	s.dpop = oauth.NewDpopManager(s.oauthReplay)
+	s.oauthClients = oauth.NewClientResolver(fetcher, false)
+
+	return s
+}
+
+type oauthTestClient struct {
+	key      jwk.Key
+	verifier string
+}
+
+func newOAuthTestClient(t *testing.T) *oauthTestClient {
+	t.Helper()
+
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := jwk.FromRaw(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &oauthTestClient{
+		key:      key,
+		verifier: strings.Repeat("v", 64),
+	}
+}
+
+func (c *oauthTestClient) challenge() string {
+	sum := sha256.Sum256([]byte(c.verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (c *oauthTestClient) jkt(t *testing.T) string {
+	t.Helper()
+
+	thumb, err := c.key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(thumb)
+}
+
+func (c *oauthTestClient) proof(t *testing.T, s *Server, htm, htu string) string {
+	t.Helper()
+
+	pub, err := c.key.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hdrs := jws.NewHeaders()
+	hdrs.Set(jws.TypeKey, "dpop+jwt")
+	hdrs.Set(jws.JWKKey, pub)
+
+	payload, err := json.Marshal(map[string]any{
+		"jti":   uuid.NewString(),
+		"htm":   htm,
+		"htu":   htu,
+		"iat":   time.Now().Unix(),
+		"nonce": s.dpop.Nonce(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := jws.Sign(payload, jws.WithKey(jwa.ES256, c.key, jws.WithProtectedHeaders(hdrs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(signed)
+}
+
+// posts to the token endpoint and returns the status and decoded body
+func (c *oauthTestClient) token(t *testing.T, s *Server, form url.Values) (int, map[string]any) {
+	t.Helper()
+
+	form.Set("client_id", testOAuthClientId)
+
+	req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+	req.Header.Set("DPoP", c.proof(t, s, "POST", s.oauthIssuer()+"/oauth/token"))
+
+	rec := httptest.NewRecorder()
+	if err := s.handleOAuthToken(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	return rec.Code, body
+}
+
+// stands in for par and the sign in page, leaving an approved request with a code the way they would
+func (c *oauthTestClient) approvedCode(t *testing.T, s *Server) string {
+	t.Helper()
+
+	code := oauth.RandomToken("cod-")
+	did := testOAuthDid
+
+	if err := s.db.Create(&models.OAuthAuthorizationRequest{
+		ID:            uuid.NewString(),
+		ClientId:      testOAuthClientId,
+		ClientAuth:    "none",
+		DpopJkt:       c.jkt(t),
+		RedirectUri:   testOAuthRedirectUri,
+		Scope:         "atproto transition:generic",
+		CodeChallenge: c.challenge(),
+		Did:           &did,
+		Code:          &code,
+		CreatedAt:     time.Now(),
+		ExpiresAt:     time.Now().Add(oauthCodeExpiry),
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	return code
+}
+
+func codeForm(code, redirectUri, verifier string) url.Values {
+	return url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectUri},
+		"code_verifier": {verifier},
+	}
+}
+
+func refreshForm(refresh string) url.Values {
+	return url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refresh},
+	}
+}
+
+func TestOAuthCodeExchange(t *testing.T) {
+	s := newOAuthTestServer(t)
+	c := newOAuthTestClient(t)
+
+	status, body := c.token(t, s, codeForm(c.approvedCode(t, s), testOAuthRedirectUri, c.verifier))
+	if status != 200 {
+		t.Fatalf("expected 200, got %d: %v", status, body)
+	}
+
+	if body["token_type"] != "DPoP" || body["sub"] != testOAuthDid || body["access_token"] == "" || body["refresh_token"] == "" {
+		t.Fatalf("unexpected token response: %v", body)
+	}
+}
+
+func TestOAuthCodeExchangeFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		form    func(c *oauthTestClient, code string) url.Values
+		client  func(t *testing.T, c *oauthTestClient) *oauthTestClient
+		wantErr string
+	}{
+		{
+			name: "wrong verifier",
+			form: func(c *oauthTestClient, code string) url.Values {
+				return codeForm(code, testOAuthRedirectUri, strings.Repeat("x", 64))
+			},
+			wantErr: "invalid_grant",
+		},
+		{
+			name: "wrong redirect uri",
+			form: func(c *oauthTestClient, code string) url.Values {
+				return codeForm(code, "https://app.example.com/other", c.verifier)
+			},
+			wantErr: "invalid_grant",
+		},
+		{
+			name: "unknown code",
+			form: func(c *oauthTestClient, code string) url.Values {
+				return codeForm("cod-nope", testOAuthRedirectUri, c.verifier)
+			},
+			wantErr: "invalid_grant",
+		},
+		{
+			name: "different dpop key",
+			form: func(c *oauthTestClient, code string) url.Values {
+				return codeForm(code, testOAuthRedirectUri, c.verifier)
+			},
+			client: func(t *testing.T, c *oauthTestClient) *oauthTestClient {
+				other := newOAuthTestClient(t)
+				other.verifier = c.verifier
+				return other
+			},
+			wantErr: "invalid_dpop_proof",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newOAuthTestServer(t)
+			c := newOAuthTestClient(t)
+			code := c.approvedCode(t, s)
+
+			sender := c
+			if tt.client != nil {
+				sender = tt.client(t, c)
+			}
+
+			status, body := sender.token(t, s, tt.form(c, code))
+			if status != 400 || body["error"] != tt.wantErr {
+				t.Fatalf("expected 400 %s, got %d: %v", tt.wantErr, status, body)
+			}
+		})
+	}
+}
+
+func TestOAuthCodeOnlyWorksOnce(t *testing.T) {
+	s := newOAuthTestServer(t)
+	c := newOAuthTestClient(t)
+	code := c.approvedCode(t, s)
+
+	if status, body := c.token(t, s, codeForm(code, testOAuthRedirectUri, c.verifier)); status != 200 {
+		t.Fatalf("expected 200, got %d: %v", status, body)
+	}
+
+	if status, body := c.token(t, s, codeForm(code, testOAuthRedirectUri, c.verifier)); status != 400 || body["error"] != "invalid_grant" {
+		t.Fatalf("expected the code to be rejected the second time, got %d: %v", status, body)
+	}
+}
+
+func TestOAuthRefresh(t *testing.T) {
+	s := newOAuthTestServer(t)
+	c := newOAuthTestClient(t)
+
+	_, body := c.token(t, s, codeForm(c.approvedCode(t, s), testOAuthRedirectUri, c.verifier))
+	first := body["refresh_token"].(string)
+
+	status, body := c.token(t, s, refreshForm(first))
+	if status != 200 {
+		t.Fatalf("expected 200, got %d: %v", status, body)
+	}
+
+	second := body["refresh_token"].(string)
+	if second == first {
+		t.Fatal("expected the refresh token to rotate")
+	}
+
+	if status, body := c.token(t, s, refreshForm(first)); status != 400 || body["error"] != "invalid_grant" {
+		t.Fatalf("expected the old refresh token to be rejected, got %d: %v", status, body)
+	}
+
+	other := newOAuthTestClient(t)
+	if status, body := other.token(t, s, refreshForm(second)); status != 400 || body["error"] != "invalid_dpop_proof" {
+		t.Fatalf("expected a different dpop key to be rejected, got %d: %v", status, body)
+	}
+
+	if status, body := c.token(t, s, refreshForm(second)); status != 200 {
+		t.Fatalf("expected 200, got %d: %v", status, body)
+	}
+}
+
+func TestOAuthTokenNeedsNonce(t *testing.T) {
+	s := newOAuthTestServer(t)
+	c := newOAuthTestClient(t)
+
+	form := codeForm(c.approvedCode(t, s), testOAuthRedirectUri, c.verifier)
+	form.Set("client_id", testOAuthClientId)
+
+	// a proof from a server with a different nonce secret
+	other := newOAuthTestServer(t)
+
+	req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+	req.Header.Set("DPoP", c.proof(t, other, "POST", s.oauthIssuer()+"/oauth/token"))
+
+	rec := httptest.NewRecorder()
+	if err := s.handleOAuthToken(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec.Code != 400 || !strings.Contains(rec.Body.String(), "use_dpop_nonce") || rec.Header().Get("DPoP-Nonce") == "" {
+		t.Fatalf("expected use_dpop_nonce with a fresh nonce, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/haileyok/cocoon/identity"
+	"github.com/haileyok/cocoon/models"
+	"github.com/haileyok/cocoon/plc"
+)
+
+// returns the most recent operation in the did's audit log that hasn't been nullified
+func (s *Server) getLatestPlcOperation(ctx context.Context, did string) (*identity.DidAuditEntry, error) {
+	log, err := identity.FetchDidAuditLog(ctx, nil, did)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(log) - 1; i >= 0; i-- {
+		if !log[i].Nullified {
+			return &log[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("no valid operations in audit log for %s", did)
+}
+
+// makes sure that an operation a user wants to submit won't take their account away from us. the
+// operation still needs to point at this pds with the account's current signing key and handle
+func (s *Server) validatePlcOperation(urepo *models.RepoActor, op *plc.Operation) error {
+	if op.Type != "plc_operation" {
+		return fmt.Errorf("Operation must be of type plc_operation")
+	}
+
+	k, err := crypto.ParsePrivateBytesK256(urepo.SigningKey)
+	if err != nil {
+		return err
+	}
+
+	pubsigkey, err := k.PublicKey()
+	if err != nil {
+		return err
+	}
+
+	if op.VerificationMethods["atproto"] != pubsigkey.DIDKey() {
+		return fmt.Errorf("Operation must use this server's signing key for the account")
+	}
+
+	rotationKey, err := s.plcClient.RotationKeyDid()
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(op.RotationKeys, rotationKey) {
+		return fmt.Errorf("Operation must include this server's rotation key")
+	}
+
+	pds, ok := op.Services["atproto_pds"]
+	if !ok || pds.Type != "AtprotoPersonalDataServer" || pds.Endpoint != "https://"+s.config.Hostname {
+		return fmt.Errorf("Operation must list https://%s as the account's PDS", s.config.Hostname)
+	}
+
+	if !slices.Contains(op.AlsoKnownAs, "at://"+urepo.Handle) {
+		return fmt.Errorf("Operation must include at://%s in alsoKnownAs", urepo.Handle)
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+const (
+	defaultAppview = "did:web:api.bsky.app#bsky_appview"
+	proxyTimeout   = 30 * time.Second
+)
+
+var defaultProxyRoutes = []string{
+	"chat.bsky.=did:web:api.bsky.chat#bsky_chat",
+}
+
+// only these headers are passed between the client and the upstream service. everything else, cookies
+// and our own auth included, stays here
+var (
+	proxyRequestHeaders = []string{
+		"Accept",
+		"Accept-Language",
+		"Atproto-Accept-Labelers",
+		"Content-Type",
+		"If-None-Match",
+		"X-Bsky-Topics",
+	}
+	proxyResponseHeaders = []string{
+		"Atproto-Content-Labelers",
+		"Atproto-Repo-Rev",
+		"Cache-Control",
+		"Content-Language",
+		"Content-Type",
+		"Etag",
+		"Retry-After",
+		"Ratelimit-Limit",
+		"Ratelimit-Policy",
+		"Ratelimit-Remaining",
+		"Ratelimit-Reset",
+	}
+)
+
+var serviceIdRegex = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*$`)
+
+// sends requests for every method starting with prefix to service, unless the client asks for
+// something else with atproto-proxy
+type proxyRoute struct {
+	prefix  string
+	service string
+}
+
+type ProxyError struct {
+	Name    string
+	Message string
+}
+
+func (pe *ProxyError) Error() string {
+	return pe.Message
+}
+
+// parses routes in the form of "chat.bsky.=did:web:api.bsky.chat#bsky_chat". the result is sorted so
+// that the most specific prefix matches first
+func parseProxyRoutes(routes []string) ([]proxyRoute, error) {
+	var parsed []proxyRoute
+	for _, r := range routes {
+		prefix, service, ok := strings.Cut(r, "=")
+		if !ok || prefix == "" {
+			return nil, fmt.Errorf("invalid proxy route %q, expected prefix=did#service", r)
+		}
+
+		if _, _, err := parseProxyService(service); err != nil {
+			return nil, fmt.Errorf("invalid proxy route %q: %w", r, err)
+		}
+
+		parsed = append(parsed, proxyRoute{
+			prefix:  prefix,
+			service: service,
+		})
+	}
+
+	sort.SliceStable(parsed, func(i, j int) bool {
+		return len(parsed[i].prefix) > len(parsed[j].prefix)
+	})
+
+	return parsed, nil
+}
+
+// splits a service reference like did:web:api.bsky.app#bsky_appview into its did and service id
+func parseProxyService(svc string) (string, string, error) {
+	did, id, ok := strings.Cut(svc, "#")
+	if !ok {
+		return "", "", fmt.Errorf("service must be a did and a service id, i.e. did:web:api.bsky.app#bsky_appview")
+	}
+
+	if _, err := syntax.ParseDID(did); err != nil {
+		return "", "", fmt.Errorf("invalid service did: %w", err)
+	}
+
+	if !serviceIdRegex.MatchString(id) {
+		return "", "", fmt.Errorf("invalid service id %q", id)
+	}
+
+	return did, "#" + id, nil
+}
+
+// works out which service a proxied call to nsid should go to. an atproto-proxy header always wins
+// over the routing table
+func (s *Server) proxyServiceFor(nsid string, header string) (string, string, error) {
+	if header != "" {
+		did, id, err := parseProxyService(header)
+		if err != nil {
+			return "", "", &ProxyError{Name: "InvalidRequest", Message: "Invalid atproto-proxy header: " + err.Error()}
+		}
+		return did, id, nil
+	}
+
+	svc := s.config.DefaultAppview
+	for _, r := range s.config.ProxyRoutes {
+		if strings.HasPrefix(nsid, r.prefix) {
+			svc = r.service
+			break
+		}
+	}
+
+	return parseProxyService(svc)
+}
+
+// looks up the endpoint for a service in its did document
+func (s *Server) resolveProxyEndpoint(ctx context.Context, did string, id string) (*url.URL, error) {
+	doc, err := s.passport.FetchDoc(ctx, did)
+	if err != nil {
+		return nil, &ProxyError{Name: "UpstreamFailure", Message: "Could not resolve " + did + ": " + err.Error()}
+	}
+
+	var endpoint string
+	for _, svc := range doc.Service {
+		if svc.Id == id || svc.Id == did+id {
+			endpoint = svc.ServiceEndpoint
+			break
+		}
+	}
+
+	if endpoint == "" {
+		return nil, &ProxyError{Name: "InvalidRequest", Message: "Could not find " + id + " in the DID document for " + did}
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, &ProxyError{Name: "UpstreamFailure", Message: "Invalid service endpoint for " + did + id}
+	}
+
+	// clients can name any did in atproto-proxy, so plain http is only for services the admin set up
+	if u.Scheme == "http" && !s.isConfiguredProxyService(did+id) {
+		return nil, &ProxyError{Name: "InvalidRequest", Message: "The service endpoint for " + did + id + " must use https"}
+	}
+
+	return u, nil
+}
+
+func (s *Server) isConfiguredProxyService(service string) bool {
+	if service == s.config.DefaultAppview {
+		return true
+	}
+
+	for _, r := range s.config.ProxyRoutes {
+		if r.service == service {
+			return true
+		}
+	}
+
+	return false
+}
+
+func copyHeaders(dst, src http.Header, allowed []string) {
+	for _, k := range allowed {
+		for _, v := range src.Values(k) {
+			dst.Add(k, v)
+		}
+	}
+}
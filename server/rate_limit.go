@@ -0,0 +1,244 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RussellLuo/slidingwindow"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/labstack/echo/v4"
+)
+
+type RateLimit struct {
+	Name   string
+	Limit  int64
+	Window time.Duration
+}
+
+// limits are checked per client ip by rateLimitByIp, and per account inside the handlers once they know which
+// account a request is for. signing in through oauth shares the createSession limits, both per ip and per account,
+// but checks them itself so it can render the sign in page
+var (
+	rateLimitLoginIp = []RateLimit{
+		{Name: "login-ip-5m", Limit: 30, Window: 5 * time.Minute},
+		{Name: "login-ip-day", Limit: 300, Window: 24 * time.Hour},
+	}
+	rateLimitLoginAccount = []RateLimit{
+		{Name: "login-account-5m", Limit: 10, Window: 5 * time.Minute},
+		{Name: "login-account-day", Limit: 100, Window: 24 * time.Hour},
+	}
+	rateLimitCreateAccountIp = []RateLimit{
+		{Name: "create-account-ip-5m", Limit: 20, Window: 5 * time.Minute},
+		{Name: "create-account-ip-day", Limit: 100, Window: 24 * time.Hour},
+	}
+	rateLimitEmailIp = []RateLimit{
+		{Name: "email-ip-hour", Limit: 15, Window: time.Hour},
+	}
+	rateLimitEmailAccount = []RateLimit{
+		{Name: "email-account-hour", Limit: 5, Window: time.Hour},
+	}
+	rateLimitResetPasswordIp = []RateLimit{
+		{Name: "reset-password-ip-5m", Limit: 50, Window: 5 * time.Minute},
+	}
+	rateLimitResetPasswordAccount = []RateLimit{
+		{Name: "reset-password-account-5m", Limit: 10, Window: 5 * time.Minute},
+	}
+	rateLimitReserveSigningKeyIp = []RateLimit{
+		{Name: "reserve-signing-key-ip-5m", Limit: 10, Window: 5 * time.Minute},
+		{Name: "reserve-signing-key-ip-day", Limit: 50, Window: 24 * time.Hour},
+	}
+	rateLimitWaitlistIp = []RateLimit{
+		{Name: "waitlist-ip-hour", Limit: 5, Window: time.Hour},
+		{Name: "waitlist-ip-day", Limit: 20, Window: 24 * time.Hour},
+	}
+	// shared by every endpoint that takes an authenticator code from someone who's already signed in
+	rateLimitTotpAccount = []RateLimit{
+		{Name: "totp-account-5m", Limit: 10, Window: 5 * time.Minute},
+		{Name: "totp-account-day", Limit: 50, Window: 24 * time.Hour},
+	}
+)
+
+// a slidingwindow.LocalWindow that remembers the count of the window before it. the limiter only says yes or no,
+// and this is enough to work out how many requests are left for the RateLimit-Remaining header
+type rateLimitWindow struct {
+	slidingwindow.LocalWindow
+	size      time.Duration
+	prevCount int64
+}
+
+func (w *rateLimitWindow) Reset(start time.Time, count int64) {
+	if start.Sub(w.Start()) == w.size {
+		w.prevCount = w.Count()
+	} else {
+		w.prevCount = 0
+	}
+	w.LocalWindow.Reset(start, count)
+}
+
+func (w *rateLimitWindow) used(now time.Time) int64 {
+	weight := float64(w.size-now.Sub(w.Start())) / float64(w.size)
+	return int64(weight*float64(w.prevCount)) + w.Count()
+}
+
+type rateLimitBucket struct {
+	lk       sync.Mutex
+	limiter  *slidingwindow.Limiter
+	window   *rateLimitWindow
+	lastUsed time.Time
+}
+
+type rateLimitResult struct {
+	Allowed   bool
+	Limit     RateLimit
+	Remaining int64
+	Reset     time.Time
+}
+
+// buckets are kept in memory, so limits start over when cocoon restarts
+type RateLimiter struct {
+	lk        sync.Mutex
+	buckets   map[string]*rateLimitBucket
+	lastPrune time.Time
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		buckets: map[string]*rateLimitBucket{},
+	}
+}
+
+func (rl *RateLimiter) bucket(limit RateLimit, key string, now time.Time) *rateLimitBucket {
+	rl.lk.Lock()
+	defer rl.lk.Unlock()
+
+	if now.Sub(rl.lastPrune) > time.Minute {
+		for k, b := range rl.buckets {
+			b.lk.Lock()
+			idle := now.Sub(b.lastUsed) > 2*b.window.size
+			b.lk.Unlock()
+			if idle {
+				delete(rl.buckets, k)
+			}
+		}
+		rl.lastPrune = now
+	}
+
+	k := limit.Name + "|" + key
+	if b, ok := rl.buckets[k]; ok {
+		return b
+	}
+
+	window := &rateLimitWindow{size: limit.Window}
+	limiter, _ := slidingwindow.NewLimiter(limit.Window, limit.Limit, func() (slidingwindow.Window, slidingwindow.StopFunc) {
+		return window, func() {}
+	})
+
+	b := &rateLimitBucket{
+		limiter: limiter,
+		window:  window,
+	}
+	rl.buckets[k] = b
+
+	return b
+}
+
+// counts a request against each limit in turn, stopping at the first one that's used up. the result is for
+// whichever limit has the fewest requests left
+func (rl *RateLimiter) Allow(key string, limits []RateLimit) rateLimitResult {
+	now := time.Now()
+
+	var res rateLimitResult
+	for i, limit := range limits {
+		b := rl.bucket(limit, key, now)
+
+		b.lk.Lock()
+		allowed := b.limiter.AllowN(now, 1)
+		b.lastUsed = now
+		remaining := max(limit.Limit-b.window.used(now), 0)
+		reset := b.window.Start().Add(limit.Window)
+		b.lk.Unlock()
+
+		if i == 0 || !allowed || remaining < res.Remaining {
+			res = rateLimitResult{
+				Allowed:   allowed,
+				Limit:     limit,
+				Remaining: remaining,
+				Reset:     reset,
+			}
+		}
+
+		if !allowed {
+			break
+		}
+	}
+
+	return res
+}
+
+// sets the RateLimit-* headers and returns true if the request should be turned away
+func (s *Server) rateLimited(e echo.Context, key string, limits []RateLimit) bool {
+	if s.rateLimiter == nil || len(limits) == 0 {
+		return false
+	}
+
+	res := s.rateLimiter.Allow(key, limits)
+
+	// requests limited by both ip and account get the headers for whichever has less left
+	hdr := e.Response().Header()
+	if cur, err := strconv.ParseInt(hdr.Get("RateLimit-Remaining"), 10, 64); err == nil && res.Allowed && cur <= res.Remaining {
+		return false
+	}
+
+	hdr.Set("RateLimit-Limit", strconv.FormatInt(res.Limit.Limit, 10))
+	hdr.Set("RateLimit-Remaining", strconv.FormatInt(res.Remaining, 10))
+	hdr.Set("RateLimit-Reset", strconv.FormatInt(res.Reset.Unix(), 10))
+	hdr.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", res.Limit.Limit, int64(res.Limit.Window.Seconds())))
+
+	return !res.Allowed
+}
+
+func (s *Server) rateLimitByIp(limits []RateLimit) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(e echo.Context) error {
+			if s.rateLimited(e, "ip:"+e.RealIP(), limits) {
+				return helpers.RateLimitError(e, "Too many requests, try again later")
+			}
+			return next(e)
+		}
+	}
+}
+
+// account is the did when the account exists, or whatever identifier the request used when it doesn't, so
+// guessing at accounts that aren't here is limited too
+func (s *Server) rateLimitByAccount(e echo.Context, account string, limits []RateLimit) bool {
+	return s.rateLimited(e, "account:"+strings.ToLower(account), limits)
+}
+
+// client ips are taken from X-Forwarded-For when the request comes through a trusted proxy. loopback and private
+// addresses are always trusted, since that's where a reverse proxy in front of cocoon usually is
+func newIPExtractor(trustedProxies []string) (echo.IPExtractor, error) {
+	var opts []echo.TrustOption
+	for _, p := range trustedProxies {
+		p = strings.TrimSpace(p)
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+
+		_, ipnet, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", p, err)
+		}
+
+		opts = append(opts, echo.TrustIPRange(ipnet))
+	}
+
+	return echo.ExtractIPFromXFFHeader(opts...), nil
+}
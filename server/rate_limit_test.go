@@ -0,0 +1,67 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimitWindowUsed(t *testing.T) {
+	start := time.Unix(1_000_000, 0)
+
+	tests := []struct {
+		name string
+		// when the window after the first one starts, and how far into it we look
+		next  time.Duration
+		at    time.Duration
+		count int64
+		want  int64
+	}{
+		{"same window", 0, 30 * time.Second, 4, 14},
+		{"start of next window", time.Minute, 0, 0, 10},
+		{"quarter into next window", time.Minute, 15 * time.Second, 0, 7},
+		{"half into next window", time.Minute, 30 * time.Second, 2, 7},
+		{"end of next window", time.Minute, time.Minute, 3, 3},
+		{"skipped a window", 2 * time.Minute, 0, 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &rateLimitWindow{size: time.Minute}
+			w.Reset(start, 10)
+			if tt.next != 0 {
+				w.Reset(start.Add(tt.next), 0)
+			}
+			w.AddCount(tt.count)
+
+			if got := w.used(w.Start().Add(tt.at)); got != tt.want {
+				t.Errorf("used = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	rl := NewRateLimiter()
+	limits := []RateLimit{
+		{Name: "test-minute", Limit: 3, Window: time.Minute},
+		{Name: "test-hour", Limit: 10, Window: time.Hour},
+	}
+
+	for i := range 3 {
+		res := rl.Allow("key", limits)
+		if !res.Allowed {
+			t.Fatalf("request %d was turned away", i+1)
+		}
+		if want := int64(2 - i); res.Remaining != want || res.Limit.Name != "test-minute" {
+			t.Fatalf("request %d: remaining %d on %s, want %d on test-minute", i+1, res.Remaining, res.Limit.Name, want)
+		}
+	}
+
+	if res := rl.Allow("key", limits); res.Allowed || res.Limit.Name != "test-minute" {
+		t.Fatalf("fourth request allowed = %v on %s", res.Allowed, res.Limit.Name)
+	}
+
+	if res := rl.Allow("other", limits); !res.Allowed {
+		t.Fatal("a different key shares the bucket")
+	}
+}
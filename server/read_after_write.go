@@ -0,0 +1,533 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/util"
+	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+)
+
+// the appview reports the rev of the user's repo it has indexed in the atproto-repo-rev header. anything
+// written locally after that rev isn't in its responses yet, so for a few methods we patch those records in
+// ourselves. otherwise people don't see their own posts and profile edits until the appview catches up
+const readAfterWriteLimit = 10
+
+var readAfterWriteMethods = map[string]bool{
+	"app.bsky.actor.getProfile":   true,
+	"app.bsky.feed.getAuthorFeed": true,
+	"app.bsky.feed.getPostThread": true,
+	"app.bsky.feed.getTimeline":   true,
+}
+
+type localRecord struct {
+	Uri       string
+	Cid       string
+	Value     map[string]any
+	IndexedAt time.Time
+}
+
+type localRecords struct {
+	count   int
+	oldest  time.Time
+	profile *localRecord
+	posts   []localRecord
+}
+
+func (s *Server) getRecordsSinceRev(did, rev string) (*localRecords, error) {
+	// the newest writes are the ones people go looking for, so those are the ones kept when there are too many
+	var rows []models.Record
+	if err := s.db.Raw("SELECT * FROM records WHERE did = ? AND rev > ? AND takedown_ref IS NULL ORDER BY rev DESC LIMIT ?", did, rev, readAfterWriteLimit).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	slices.Reverse(rows)
+
+	local := &localRecords{}
+	if len(rows) == 0 {
+		return local, nil
+	}
+
+	// if the appview's rev is older than every record we have, it's looking at a repo from before the account
+	// moved here and there's nothing sensible to merge
+	var older models.Record
+	if err := s.db.Raw("SELECT did, nsid, rkey FROM records WHERE did = ? AND rev <= ? LIMIT 1", did, rev).Scan(&older).Error; err != nil {
+		return nil, err
+	}
+
+	if older.Rkey == "" {
+		return local, nil
+	}
+
+	// the lag is measured from the oldest write the appview hasn't seen, which might not be one we kept
+	local.oldest = recordIndexedAt(rows[0])
+	if len(rows) == readAfterWriteLimit {
+		var first models.Record
+		if err := s.db.Raw("SELECT * FROM records WHERE did = ? AND rev > ? AND takedown_ref IS NULL ORDER BY rev ASC LIMIT 1", did, rev).Scan(&first).Error; err != nil {
+			return nil, err
+		}
+		local.oldest = recordIndexedAt(first)
+	}
+
+	for _, row := range rows {
+		local.count++
+
+		if row.Nsid != "app.bsky.actor.profile" && row.Nsid != "app.bsky.feed.post" {
+			continue
+		}
+
+		rec, err := localRecordFromRow(row)
+		if err != nil {
+			s.logger.Warn("error decoding local record", "did", did, "nsid", row.Nsid, "rkey", row.Rkey, "error", err)
+			continue
+		}
+
+		if row.Nsid == "app.bsky.actor.profile" {
+			if row.Rkey == "self" {
+				local.profile = &rec
+			}
+		} else {
+			local.posts = append(local.posts, rec)
+		}
+	}
+
+	return local, nil
+}
+
+// records written before revs were tracked, or imported from another pds, only have the tid they were
+// indexed under
+func recordIndexedAt(row models.Record) time.Time {
+	if tid, err := syntax.ParseTID(row.Rev); err == nil {
+		return tid.Time()
+	}
+
+	if tid, err := syntax.ParseTID(row.CreatedAt); err == nil {
+		return tid.Time()
+	}
+
+	return time.Time{}
+}
+
+func localRecordFromRow(row models.Record) (localRecord, error) {
+	val, err := recordToJson(row.Value)
+	if err != nil {
+		return localRecord{}, err
+	}
+
+	return localRecord{
+		Uri:       "at://" + row.Did + "/" + row.Nsid + "/" + row.Rkey,
+		Cid:       row.Cid,
+		Value:     val,
+		IndexedAt: recordIndexedAt(row),
+	}, nil
+}
+
+// converts a stored cbor record into the same json shape the appview returns records in
+func recordToJson(value []byte) (map[string]any, error) {
+	val, err := data.UnmarshalCBOR(value)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+
+	var out map[string]any
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (s *Server) readAfterWrite(e echo.Context, urepo *models.RepoActor, nsid string, resp *http.Response) error {
+	rev := resp.Header.Get("atproto-repo-rev")
+	if rev == "" || !strings.HasPrefix(resp.Header.Get("content-type"), "application/json") {
+		return e.Stream(resp.StatusCode, resp.Header.Get("content-type"), resp.Body)
+	}
+
+	local, err := s.getRecordsSinceRev(urepo.Repo.Did, rev)
+	if err != nil {
+		s.logger.Error("error getting local records for read after write", "did", urepo.Repo.Did, "error", err)
+		return e.Stream(resp.StatusCode, resp.Header.Get("content-type"), resp.Body)
+	}
+
+	if local.count == 0 {
+		return e.Stream(resp.StatusCode, resp.Header.Get("content-type"), resp.Body)
+	}
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var body map[string]any
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&body); err != nil {
+		return e.Blob(resp.StatusCode, resp.Header.Get("content-type"), raw)
+	}
+
+	status := resp.StatusCode
+	switch {
+	case status == 200:
+		s.mungeReadAfterWrite(e, urepo, nsid, local, body)
+	case status == 400 && nsid == "app.bsky.feed.getPostThread" && body["error"] == "NotFound":
+		thread := s.localPostThread(urepo, local, e.QueryParam("uri"))
+		if thread == nil {
+			return e.Blob(resp.StatusCode, resp.Header.Get("content-type"), raw)
+		}
+		status = 200
+		body = map[string]any{"thread": thread}
+	default:
+		return e.Blob(resp.StatusCode, resp.Header.Get("content-type"), raw)
+	}
+
+	e.Response().Header().Del("Etag")
+	e.Response().Header().Set("Atproto-Upstream-Lag", fmt.Sprintf("%d", time.Since(local.oldest).Milliseconds()))
+
+	return e.JSON(status, body)
+}
+
+func (s *Server) mungeReadAfterWrite(e echo.Context, urepo *models.RepoActor, nsid string, local *localRecords, body map[string]any) {
+	isSelf := func(actor string) bool {
+		return actor == urepo.Repo.Did || actor == urepo.Handle
+	}
+
+	switch nsid {
+	case "app.bsky.actor.getProfile":
+		if isSelf(e.QueryParam("actor")) && local.profile != nil {
+			s.updateProfileView(body, urepo.Repo.Did, local.profile, true)
+		}
+	case "app.bsky.feed.getAuthorFeed":
+		if !isSelf(e.QueryParam("actor")) {
+			return
+		}
+		feed, _ := body["feed"].([]any)
+		s.updateFeedAuthors(feed, urepo.Repo.Did, local.profile)
+		body["feed"] = s.insertLocalPosts(feed, urepo, local)
+	case "app.bsky.feed.getTimeline":
+		feed, _ := body["feed"].([]any)
+		s.updateFeedAuthors(feed, urepo.Repo.Did, local.profile)
+		body["feed"] = s.insertLocalPosts(feed, urepo, local)
+	case "app.bsky.feed.getPostThread":
+		thread, ok := body["thread"].(map[string]any)
+		if !ok || thread["$type"] != "app.bsky.feed.defs#threadViewPost" {
+			return
+		}
+		s.updateThreadAuthors(thread, urepo.Repo.Did, local.profile)
+		s.insertLocalReplies(thread, urepo, local)
+	}
+}
+
+func (s *Server) blobUrl(did string, blob any) string {
+	b, ok := blob.(map[string]any)
+	if !ok {
+		return ""
+	}
+
+	ref, ok := b["ref"].(map[string]any)
+	if !ok {
+		return ""
+	}
+
+	link, ok := ref["$link"].(string)
+	if !ok {
+		return ""
+	}
+
+	return fmt.Sprintf("https://%s/xrpc/com.atproto.sync.getBlob?did=%s&cid=%s", s.config.Hostname, did, link)
+}
+
+func (s *Server) updateProfileView(view map[string]any, did string, profile *localRecord, detailed bool) {
+	if profile == nil {
+		return
+	}
+
+	fields := []string{"displayName"}
+	blobs := []string{"avatar"}
+	if detailed {
+		fields = append(fields, "description")
+		blobs = append(blobs, "banner")
+	}
+
+	for _, f := range fields {
+		if v, ok := profile.Value[f]; ok {
+			view[f] = v
+		} else {
+			delete(view, f)
+		}
+	}
+
+	for _, f := range blobs {
+		if u := s.blobUrl(did, profile.Value[f]); u != "" {
+			view[f] = u
+		} else {
+			delete(view, f)
+		}
+	}
+}
+
+func (s *Server) updateFeedAuthors(feed []any, did string, profile *localRecord) {
+	if profile == nil {
+		return
+	}
+
+	for _, item := range feed {
+		fi, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		post, ok := fi["post"].(map[string]any)
+		if !ok {
+			continue
+		}
+
+		if author, ok := post["author"].(map[string]any); ok && author["did"] == did {
+			s.updateProfileView(author, did, profile, false)
+		}
+	}
+}
+
+func (s *Server) updateThreadAuthors(node map[string]any, did string, profile *localRecord) {
+	if profile == nil || node == nil {
+		return
+	}
+
+	if post, ok := node["post"].(map[string]any); ok {
+		if author, ok := post["author"].(map[string]any); ok && author["did"] == did {
+			s.updateProfileView(author, did, profile, false)
+		}
+	}
+
+	if parent, ok := node["parent"].(map[string]any); ok {
+		s.updateThreadAuthors(parent, did, profile)
+	}
+
+	replies, _ := node["replies"].([]any)
+	for _, r := range replies {
+		reply, _ := r.(map[string]any)
+		s.updateThreadAuthors(reply, did, profile)
+	}
+}
+
+func (s *Server) localPostView(urepo *models.RepoActor, profile *localRecord, post localRecord) map[string]any {
+	author := map[string]any{
+		"did":    urepo.Repo.Did,
+		"handle": urepo.Handle,
+	}
+	s.updateProfileView(author, urepo.Repo.Did, profile, false)
+
+	view := map[string]any{
+		"$type":       "app.bsky.feed.defs#postView",
+		"uri":         post.Uri,
+		"cid":         post.Cid,
+		"author":      author,
+		"record":      post.Value,
+		"replyCount":  0,
+		"repostCount": 0,
+		"likeCount":   0,
+		"quoteCount":  0,
+		"indexedAt":   post.IndexedAt.UTC().Format(util.ISO8601),
+		"labels":      []any{},
+	}
+
+	// only image embeds can be rendered from what we have locally
+	if embed, ok := post.Value["embed"].(map[string]any); ok && embed["$type"] == "app.bsky.embed.images" {
+		images, _ := embed["images"].([]any)
+		views := []any{}
+		for _, i := range images {
+			img, ok := i.(map[string]any)
+			if !ok {
+				continue
+			}
+			u := s.blobUrl(urepo.Repo.Did, img["image"])
+			if u == "" {
+				continue
+			}
+			iv := map[string]any{
+				"thumb":    u,
+				"fullsize": u,
+				"alt":      img["alt"],
+			}
+			if ar, ok := img["aspectRatio"]; ok {
+				iv["aspectRatio"] = ar
+			}
+			views = append(views, iv)
+		}
+		view["embed"] = map[string]any{
+			"$type":  "app.bsky.embed.images#view",
+			"images": views,
+		}
+	}
+
+	return view
+}
+
+func feedItemTime(item any) time.Time {
+	fi, _ := item.(map[string]any)
+	post, _ := fi["post"].(map[string]any)
+	str, _ := post["indexedAt"].(string)
+	t, _ := time.Parse(time.RFC3339Nano, str)
+	return t
+}
+
+// replies are left out since we can't build their parent and root views from local records
+func (s *Server) insertLocalPosts(feed []any, urepo *models.RepoActor, local *localRecords) []any {
+	if len(local.posts) == 0 {
+		return feed
+	}
+
+	var lastTime time.Time
+	inFeed := map[string]bool{}
+	if len(feed) > 0 {
+		lastTime = feedItemTime(feed[len(feed)-1])
+		for _, item := range feed {
+			fi, _ := item.(map[string]any)
+			post, _ := fi["post"].(map[string]any)
+			if uri, ok := post["uri"].(string); ok {
+				inFeed[uri] = true
+			}
+		}
+	}
+
+	for _, post := range local.posts {
+		if _, isReply := post.Value["reply"]; isReply || inFeed[post.Uri] {
+			continue
+		}
+
+		// posts older than the end of this page belong on a later one
+		if post.IndexedAt.Before(lastTime) {
+			continue
+		}
+
+		item := map[string]any{
+			"post": s.localPostView(urepo, local.profile, post),
+		}
+
+		idx := len(feed)
+		for i, existing := range feed {
+			if feedItemTime(existing).Before(post.IndexedAt) {
+				idx = i
+				break
+			}
+		}
+
+		feed = append(feed[:idx], append([]any{item}, feed[idx:]...)...)
+	}
+
+	return feed
+}
+
+func findThreadNode(node map[string]any, uri string) map[string]any {
+	if post, ok := node["post"].(map[string]any); ok && post["uri"] == uri {
+		return node
+	}
+
+	replies, _ := node["replies"].([]any)
+	for _, r := range replies {
+		reply, ok := r.(map[string]any)
+		if !ok {
+			continue
+		}
+		if found := findThreadNode(reply, uri); found != nil {
+			return found
+		}
+	}
+
+	return nil
+}
+
+func replyParentUri(post localRecord) string {
+	reply, _ := post.Value["reply"].(map[string]any)
+	parent, _ := reply["parent"].(map[string]any)
+	uri, _ := parent["uri"].(string)
+	return uri
+}
+
+func replyRootUri(post localRecord) string {
+	reply, _ := post.Value["reply"].(map[string]any)
+	root, _ := reply["root"].(map[string]any)
+	uri, _ := root["uri"].(string)
+	return uri
+}
+
+// what goes in records.reply_root, so a thread's posts can be found without decoding every post
+func recordReplyRoot(nsid string, value []byte) *string {
+	if nsid != "app.bsky.feed.post" {
+		return nil
+	}
+
+	val, err := data.UnmarshalCBOR(value)
+	if err != nil {
+		return to.StringPtr("")
+	}
+
+	reply, _ := val["reply"].(map[string]any)
+	root, _ := reply["root"].(map[string]any)
+	uri, _ := root["uri"].(string)
+	return &uri
+}
+
+// posts come oldest first, so replies to our own new replies find their parent already in the thread
+func (s *Server) insertLocalReplies(thread map[string]any, urepo *models.RepoActor, local *localRecords) {
+	for _, post := range local.posts {
+		parentUri := replyParentUri(post)
+		if parentUri == "" || findThreadNode(thread, post.Uri) != nil {
+			continue
+		}
+
+		parent := findThreadNode(thread, parentUri)
+		if parent == nil {
+			continue
+		}
+
+		replies, _ := parent["replies"].([]any)
+		parent["replies"] = append([]any{map[string]any{
+			"$type":   "app.bsky.feed.defs#threadViewPost",
+			"post":    s.localPostView(urepo, local.profile, post),
+			"replies": []any{},
+		}}, replies...)
+	}
+}
+
+// the appview doesn't know about a post at all yet. if it's one of ours we can still show it
+func (s *Server) localPostThread(urepo *models.RepoActor, local *localRecords, uri string) map[string]any {
+	aturi, err := syntax.ParseATURI(uri)
+	if err != nil {
+		return nil
+	}
+
+	if author := aturi.Authority().String(); author != urepo.Repo.Did && author != urepo.Handle {
+		return nil
+	}
+
+	for _, post := range local.posts {
+		if !strings.HasSuffix(post.Uri, "/"+aturi.Collection().String()+"/"+aturi.RecordKey().String()) {
+			continue
+		}
+
+		thread := map[string]any{
+			"$type":   "app.bsky.feed.defs#threadViewPost",
+			"post":    s.localPostView(urepo, local.profile, post),
+			"replies": []any{},
+		}
+		s.insertLocalReplies(thread, urepo, local)
+
+		return thread
+	}
+
+	return nil
+}
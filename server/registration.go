@@ -0,0 +1,28 @@
+package server
+
+import "fmt"
+
+const (
+	// anyone can sign up, invite codes are optional
+	RegistrationPolicyOpen = "open"
+	// an invite code is required to sign up
+	RegistrationPolicyInvite = "invite"
+	// an invite code is required, but people can ask to be put on a waitlist. once an admin
+	// approves them, they get emailed a single-use code
+	RegistrationPolicyWaitlist = "waitlist"
+	// nobody can sign up
+	RegistrationPolicyClosed = "closed"
+)
+
+func validateRegistrationPolicy(policy string) error {
+	switch policy {
+	case RegistrationPolicyOpen, RegistrationPolicyInvite, RegistrationPolicyWaitlist, RegistrationPolicyClosed:
+		return nil
+	default:
+		return fmt.Errorf("unknown registration policy %q", policy)
+	}
+}
+
+func (s *Server) inviteCodeRequired() bool {
+	return s.config.RegistrationPolicy != RegistrationPolicyOpen
+}
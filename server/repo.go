@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"strings"
 	"time"
 
 	"github.com/Azure/go-autorest/autorest/to"
@@ -136,6 +137,7 @@ func (rm *RepoMan) applyWrites(urepo models.Repo, writes []Op, swapCommit *strin
 				Rkey:      *op.Rkey,
 				Cid:       nc.String(),
 				Value:     d,
+				ReplyRoot: recordReplyRoot(op.Collection, d),
 			})
 			results = append(results, ApplyWriteResult{
 				Type:             to.StringPtr(OpTypeCreate.String()),
@@ -175,6 +177,7 @@ func (rm *RepoMan) applyWrites(urepo models.Repo, writes []Op, swapCommit *strin
 				Rkey:      *op.Rkey,
 				Cid:       nc.String(),
 				Value:     d,
+				ReplyRoot: recordReplyRoot(op.Collection, d),
 			})
 			results = append(results, ApplyWriteResult{
 				Type:             to.StringPtr(OpTypeUpdate.String()),
@@ -256,14 +259,17 @@ func (rm *RepoMan) applyWrites(urepo models.Repo, writes []Op, swapCommit *strin
 	for _, entry := range entries {
 		var cids []cid.Cid
 		if entry.Cid != "" {
+			entry.Rev = rev
+
 			if err := rm.s.db.Clauses(clause.OnConflict{
-				Columns:   []clause.Column{{Name: "did"}, {Name: "nsid"}, {Name: "rkey"}},
-				UpdateAll: true,
+				Columns: []clause.Column{{Name: "did"}, {Name: "nsid"}, {Name: "rkey"}},
+				// don't touch takedown_ref, otherwise an update would lift a takedown
+				DoUpdates: clause.AssignmentColumns([]string{"created_at", "cid", "rev", "value", "reply_root"}),
 			}).Create(&entry).Error; err != nil {
 				return nil, err
 			}
 
-			cids, err = rm.incrementBlobRefs(urepo, entry.Value)
+			cids, err = rm.incrementBlobRefs(rm.db, urepo, entry.Value)
 			if err != nil {
 				return nil, err
 			}
@@ -333,14 +339,153 @@ func (rm *RepoMan) getRecordProof(urepo models.Repo, collection, rkey string) (c
 	return c, bs.GetLoggedBlocks(), nil
 }
 
-func (rm *RepoMan) incrementBlobRefs(urepo models.Repo, cbor []byte) ([]cid.Cid, error) {
+// replaces everything in the account's repo with the contents of a car file, then signs a new commit
+// over the imported data with our key for the account
+func (rm *RepoMan) importRepo(ctx context.Context, urepo models.Repo, rd io.Reader) (cid.Cid, string, error) {
+	cr, err := car.NewCarReader(rd)
+	if err != nil {
+		return cid.Undef, "", err
+	}
+
+	if len(cr.Header.Roots) != 1 {
+		return cid.Undef, "", fmt.Errorf("car file must have exactly one root")
+	}
+
+	var newroot cid.Cid
+	var rev string
+	err = rm.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM records WHERE did = ?", urepo.Did).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec("DELETE FROM blocks WHERE did = ?", urepo.Did).Error; err != nil {
+			return err
+		}
+
+		// refs are counted again from the imported records
+		if err := tx.Exec("UPDATE blobs SET ref_count = 0 WHERE did = ?", urepo.Did).Error; err != nil {
+			return err
+		}
+
+		bs := blockstore.New(urepo.Did, tx)
+
+		for {
+			blk, err := cr.Next()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return err
+			}
+
+			if err := bs.Put(ctx, blk); err != nil {
+				return err
+			}
+		}
+
+		r, err := repo.OpenRepo(ctx, bs, cr.Header.Roots[0])
+		if err != nil {
+			return err
+		}
+
+		if r.RepoDid() != urepo.Did {
+			return fmt.Errorf("car file is for %s, not %s", r.RepoDid(), urepo.Did)
+		}
+
+		if err := r.ForEach(ctx, "", func(k string, v cid.Cid) error {
+			nsid, rkey, ok := strings.Cut(k, "/")
+			if !ok {
+				return fmt.Errorf("invalid record path %s", k)
+			}
+
+			blk, err := bs.Get(ctx, v)
+			if err != nil {
+				return err
+			}
+
+			if err := tx.Create(&models.Record{
+				Did:       urepo.Did,
+				CreatedAt: rm.clock.Next().String(),
+				Nsid:      nsid,
+				Rkey:      rkey,
+				Cid:       v.String(),
+				Value:     blk.RawData(),
+				ReplyRoot: recordReplyRoot(nsid, blk.RawData()),
+			}).Error; err != nil {
+				return err
+			}
+
+			_, err = rm.incrementBlobRefs(tx, urepo, blk.RawData())
+			return err
+		}); err != nil {
+			return err
+		}
+
+		newroot, rev, err = r.Commit(ctx, urepo.SignFor)
+		if err != nil {
+			return err
+		}
+
+		return bs.UpdateRepo(ctx, newroot, rev)
+	})
+	if err != nil {
+		return cid.Undef, "", err
+	}
+
+	return newroot, rev, nil
+}
+
+// a #sync event for the account's current commit, which tells relays to drop what they have and take the repo
+// as it is now. sent when an imported repo goes live, since relays have never seen a commit it can be diffed from
+func (rm *RepoMan) sendSyncEvent(urepo models.Repo) error {
+	root, err := cid.Cast(urepo.Root)
+	if err != nil {
+		return err
+	}
+
+	blk, err := blockstore.New(urepo.Did, rm.db).Get(context.TODO(), root)
+	if err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+
+	hb, err := cbor.DumpObject(&car.CarHeader{
+		Roots:   []cid.Cid{root},
+		Version: 1,
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := carstore.LdWrite(buf, hb); err != nil {
+		return err
+	}
+
+	if _, err := carstore.LdWrite(buf, blk.Cid().Bytes(), blk.RawData()); err != nil {
+		return err
+	}
+
+	rm.s.evtman.AddEvent(context.TODO(), &events.XRPCStreamEvent{
+		RepoSync: &atproto.SyncSubscribeRepos_Sync{
+			Did:    urepo.Did,
+			Blocks: buf.Bytes(),
+			Rev:    urepo.Rev,
+			Time:   time.Now().Format(util.ISO8601),
+		},
+	})
+
+	return nil
+}
+
+func (rm *RepoMan) incrementBlobRefs(db *gorm.DB, urepo models.Repo, cbor []byte) ([]cid.Cid, error) {
 	cids, err := getBlobCidsFromCbor(cbor)
 	if err != nil {
 		return nil, err
 	}
 
 	for _, c := range cids {
-		if err := rm.db.Exec("UPDATE blobs SET ref_count = ref_count + 1 WHERE did = ? AND cid = ?", urepo.Did, c.Bytes()).Error; err != nil {
+		if err := db.Exec("UPDATE blobs SET ref_count = ref_count + 1 WHERE did = ? AND cid = ?", urepo.Did, c.Bytes()).Error; err != nil {
 			return nil, err
 		}
 	}
@@ -376,6 +521,52 @@ func (rm *RepoMan) decrementBlobRefs(urepo models.Repo, cbor []byte) ([]cid.Cid,
 	return cids, nil
 }
 
+// blobs are usually uploaded after the repo is imported when an account moves here, so their refs can't be
+// counted at import time. this sets every blob's ref count from the records that are there now
+func (rm *RepoMan) recountBlobRefs(did string) error {
+	return rm.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("UPDATE blobs SET ref_count = 0 WHERE did = ?", did).Error; err != nil {
+			return err
+		}
+
+		rows, err := tx.Raw("SELECT value FROM records WHERE did = ?", did).Rows()
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		counts := map[cid.Cid]int{}
+		for rows.Next() {
+			var value []byte
+			if err := rows.Scan(&value); err != nil {
+				return err
+			}
+
+			cids, err := getBlobCidsFromCbor(value)
+			if err != nil {
+				return err
+			}
+
+			for _, c := range cids {
+				counts[c]++
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+
+		for c, n := range counts {
+			if err := tx.Exec("UPDATE blobs SET ref_count = ? WHERE did = ? AND cid = ?", n, did, c.Bytes()).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
 // to be honest, we could just store both the cbor and non-cbor in []entries above to avoid an additional
 // unmarshal here. this will work for now though
 func getBlobCidsFromCbor(cbor []byte) ([]cid.Cid, error) {
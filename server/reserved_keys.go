@@ -0,0 +1,30 @@
+package server
+
+import (
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/haileyok/cocoon/models"
+)
+
+// how long a key from reserveSigningKey is held for a did that hasn't created an account yet. anyone can
+// reserve one, so expiring them along with the per ip rate limit is what keeps the table from growing
+const reservedKeyExpiry = 24 * time.Hour
+
+// returns the unexpired signing key reserved for a did, or nil if there isn't one
+func (s *Server) getReservedSigningKey(did string) (*crypto.PrivateKeyK256, error) {
+	var rk models.ReservedKey
+	if err := s.db.Raw("SELECT * FROM reserved_keys WHERE did = ? AND created_at > ?", did, time.Now().Add(-reservedKeyExpiry)).Scan(&rk).Error; err != nil {
+		return nil, err
+	}
+
+	if rk.Did == "" {
+		return nil, nil
+	}
+
+	return crypto.ParsePrivateBytesK256(rk.PrivateKey)
+}
+
+func (s *Server) deleteExpiredReservedKeys() error {
+	return s.db.Exec("DELETE FROM reserved_keys WHERE created_at < ?", time.Now().Add(-reservedKeyExpiry)).Error
+}
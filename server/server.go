@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/smtp"
 	"os"
@@ -25,29 +26,41 @@ import (
 	"github.com/haileyok/cocoon/identity"
 	"github.com/haileyok/cocoon/internal/helpers"
 	"github.com/haileyok/cocoon/models"
+	"github.com/haileyok/cocoon/oauth"
 	"github.com/haileyok/cocoon/plc"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	slogecho "github.com/samber/slog-echo"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
 type Server struct {
-	http       *http.Client
-	httpd      *http.Server
-	mail       *mailyak.MailYak
-	mailLk     *sync.Mutex
-	echo       *echo.Echo
-	db         *gorm.DB
-	plcClient  *plc.Client
-	logger     *slog.Logger
-	config     *config
-	privateKey *ecdsa.PrivateKey
-	repoman    *RepoMan
-	evtman     *events.EventManager
-	passport   *identity.Passport
+	http        *http.Client
+	proxyClient *http.Client
+	httpd       *http.Server
+	mail        *mailyak.MailYak
+	mailLk      *sync.Mutex
+	echo        *echo.Echo
+	db          *gorm.DB
+	plcClient   *plc.Client
+	logger      *slog.Logger
+	config      *config
+	privateKey  *ecdsa.PrivateKey
+	repoman     *RepoMan
+	evtman      *events.EventManager
+	passport    *identity.Passport
+	dns         *HandleDNS
+	upstreams   *UpstreamPool
+
+	rateLimiter    *RateLimiter
+	sessionTouches sessionTouches
+	oauthHttp      *http.Client
+	dpop           *oauth.DpopManager
+	oauthReplay    *oauth.ReplayCache
+	oauthClients   *oauth.ClientResolver
 }
 
 type Args struct {
@@ -57,11 +70,35 @@ type Args struct {
 	Version         string
 	Did             string
 	Hostname        string
+	UserDomains     []string
+	DnsAddr         string
+	DnsAddresses    []string
+	MetricsAddr     string
 	RotationKeyPath string
 	JwkPath         string
 	ContactEmail    string
 	Relays          []string
+	ModerationDids  []string
 	AdminPassword   string
+	DefaultAppview  string
+	ProxyRoutes     []string
+	LocalAppview    string
+
+	// where oauth client metadata documents come from. defaults to fetching them over https
+	OAuthClientFetcher oauth.ClientFetcher
+	// accept plain http client ids and redirect uris, for developing clients locally
+	OAuthAllowInsecureClients bool
+
+	RegistrationPolicy string
+	InviteInterval     time.Duration
+	InviteCodeExpiry   time.Duration
+
+	HandleChangeCooldown time.Duration
+	HandleChangeLimit    int
+
+	// proxies (ips or cidrs) whose X-Forwarded-For is trusted, on top of loopback and private addresses
+	TrustedProxies    []string
+	DisableRateLimits bool
 
 	SmtpUser  string
 	SmtpPass  string
@@ -75,12 +112,48 @@ type config struct {
 	Version        string
 	Did            string
 	Hostname       string
+	UserDomains    []string
 	ContactEmail   string
 	EnforcePeering bool
 	Relays         []string
+	ModerationDids []string
 	AdminPassword  string
+	DefaultAppview string
+	ProxyRoutes    []proxyRoute
+	LocalAppview   string
+	MetricsAddr    string
 	SmtpEmail      string
 	SmtpName       string
+
+	RegistrationPolicy string
+	InviteInterval     time.Duration
+	InviteCodeExpiry   time.Duration
+
+	HandleChangeCooldown time.Duration
+	HandleChangeLimit    int
+}
+
+// every table cocoon keeps, migrated when the server starts
+var dbModels = []any{
+	&models.Actor{},
+	&models.Repo{},
+	&models.InviteCode{},
+	&models.InviteCodeUse{},
+	&models.WaitlistEntry{},
+	&models.HandleBlocklistEntry{},
+	&models.HandleChange{},
+	&models.TotpRecoveryCode{},
+	&models.ReservedKey{},
+	&models.UsedServiceAuthToken{},
+	&models.ProxyUpstream{},
+	&models.Token{},
+	&models.RefreshToken{},
+	&models.OAuthAuthorizationRequest{},
+	&models.OAuthToken{},
+	&models.Block{},
+	&models.Record{},
+	&models.Blob{},
+	&models.BlobPart{},
 }
 
 type CustomValidator struct {
@@ -113,19 +186,75 @@ func (cv *CustomValidator) Validate(i any) error {
 
 func (s *Server) handleAdminMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(e echo.Context) error {
+		// moderation services can also call the moderation endpoints with service auth, using tokens bound to
+		// the method
 		username, password, ok := e.Request().BasicAuth()
 		if !ok || username != "admin" || password != s.config.AdminPassword {
-			return helpers.InputError(e, to.StringPtr("Unauthorized"))
+			lxm := strings.TrimPrefix(e.Request().URL.Path, "/xrpc/")
+			if _, ok := serviceAuthModerationMethods[lxm]; !ok {
+				return helpers.InputError(e, to.StringPtr("Unauthorized"))
+			}
+
+			claims, err := s.moderationServiceAuthFromRequest(e)
+			if err != nil || claims == nil || claims.Lxm != lxm {
+				return helpers.InputError(e, to.StringPtr("Unauthorized"))
+			}
+
+			e.Set("serviceAuth", claims)
 		}
 
 		if err := next(e); err != nil {
 			e.Error(err)
 		}
-		
+
 		return nil
 	}
 }
 
+// for public endpoints that show more to some services, i.e. taken down blobs to a moderation service.
+// a token that doesn't verify is ignored rather than rejected, since clients sometimes send their
+// session tokens along to endpoints that don't need them
+func (s *Server) handleOptionalServiceAuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(e echo.Context) error {
+		claims, err := s.moderationServiceAuthFromRequest(e)
+		if err != nil {
+			s.logger.Debug("ignoring invalid service auth", "error", err)
+		} else if claims != nil {
+			e.Set("serviceAuth", claims)
+		}
+
+		return next(e)
+	}
+}
+
+// requests for a user's handle (i.e. alice.cocoon.example.com) shouldn't hit the rest of the pds. all we
+// serve there is the handle's did, so wildcard dns pointed at cocoon is enough for every handle to resolve
+func (s *Server) handleUserDomainMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(e echo.Context) error {
+		host := strings.ToLower(e.Request().Host)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+
+		if host == s.config.Hostname || !s.isLocalHandle(host) {
+			return next(e)
+		}
+
+		switch e.Request().URL.Path {
+		case "/.well-known/atproto-did":
+			return s.handleAtprotoDid(e, host)
+		case "/.well-known/did.json":
+			return s.handleDidWebDocument(e, host)
+		case "/", "":
+			return e.String(200, fmt.Sprintf("%s is a handle hosted on %s, an atproto PDS.\n", host, s.config.Hostname))
+		case "/robots.txt":
+			return s.handleRobots(e)
+		default:
+			return e.String(404, "not found")
+		}
+	}
+}
+
 func (s *Server) handleSessionMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(e echo.Context) error {
 		authheader := e.Request().Header.Get("authorization")
@@ -140,6 +269,14 @@ func (s *Server) handleSessionMiddleware(next echo.HandlerFunc) echo.HandlerFunc
 
 		tokenstr := pts[1]
 
+		if pts[0] == "DPoP" {
+			return s.handleOAuthSession(e, next, tokenstr)
+		}
+
+		if iss, ok := serviceAuthTokenIssuer(tokenstr); ok {
+			return s.handleServiceAuthSession(e, next, tokenstr, iss)
+		}
+
 		token, err := new(jwt.Parser).Parse(tokenstr, func(t *jwt.Token) (any, error) {
 			if _, ok := t.Method.(*jwt.SigningMethodECDSA); !ok {
 				return nil, fmt.Errorf("unsupported signing method: %v", t.Header["alg"])
@@ -173,10 +310,11 @@ func (s *Server) handleSessionMiddleware(next echo.HandlerFunc) echo.HandlerFunc
 		}
 
 		type Result struct {
-			Found bool
+			Found     bool
+			SessionId string
 		}
 		var result Result
-		if err := s.db.Raw("SELECT EXISTS(SELECT 1 FROM "+table+" WHERE token = ?) AS found", tokenstr).Scan(&result).Error; err != nil {
+		if err := s.db.Raw("SELECT 1 AS found, session_id FROM "+table+" WHERE token = ?", tokenstr).Scan(&result).Error; err != nil {
 			if err == gorm.ErrRecordNotFound {
 				return helpers.InputError(e, to.StringPtr("InvalidToken"))
 			}
@@ -205,9 +343,18 @@ func (s *Server) handleSessionMiddleware(next echo.HandlerFunc) echo.HandlerFunc
 			return helpers.ServerError(e, nil)
 		}
 
+		if repo.TakedownRef != nil {
+			return helpers.InputError(e, to.StringPtr("AccountTakedown"))
+		}
+
+		if !isRefresh && result.SessionId != "" {
+			s.touchSession(result.SessionId)
+		}
+
 		e.Set("repo", repo)
 		e.Set("did", claims["sub"])
 		e.Set("token", tokenstr)
+		e.Set("sessionId", result.SessionId)
 
 		if err := next(e); err != nil {
 			e.Error(err)
@@ -217,6 +364,36 @@ func (s *Server) handleSessionMiddleware(next echo.HandlerFunc) echo.HandlerFunc
 	}
 }
 
+func newValidator() *CustomValidator {
+	vdtor := validator.New()
+	vdtor.RegisterValidation("atproto-handle", func(fl validator.FieldLevel) bool {
+		if _, err := syntax.ParseHandle(fl.Field().String()); err != nil {
+			return false
+		}
+		return true
+	})
+	vdtor.RegisterValidation("atproto-did", func(fl validator.FieldLevel) bool {
+		if _, err := syntax.ParseDID(fl.Field().String()); err != nil {
+			return false
+		}
+		return true
+	})
+	vdtor.RegisterValidation("atproto-rkey", func(fl validator.FieldLevel) bool {
+		if _, err := syntax.ParseRecordKey(fl.Field().String()); err != nil {
+			return false
+		}
+		return true
+	})
+	vdtor.RegisterValidation("atproto-nsid", func(fl validator.FieldLevel) bool {
+		if _, err := syntax.ParseNSID(fl.Field().String()); err != nil {
+			return false
+		}
+		return true
+	})
+
+	return &CustomValidator{validator: vdtor}
+}
+
 func New(args *Args) (*Server, error) {
 	if args.Addr == "" {
 		return nil, fmt.Errorf("addr must be set")
@@ -246,12 +423,51 @@ func New(args *Args) (*Server, error) {
 		return nil, fmt.Errorf("admin password must be set")
 	}
 
+	if args.RegistrationPolicy == "" {
+		args.RegistrationPolicy = RegistrationPolicyInvite
+	}
+
+	if err := validateRegistrationPolicy(args.RegistrationPolicy); err != nil {
+		return nil, err
+	}
+
+	if args.DefaultAppview == "" {
+		args.DefaultAppview = defaultAppview
+	}
+
+	if _, _, err := parseProxyService(args.DefaultAppview); err != nil {
+		return nil, fmt.Errorf("invalid default appview: %w", err)
+	}
+
+	if args.ProxyRoutes == nil {
+		args.ProxyRoutes = defaultProxyRoutes
+	}
+
+	proxyRoutes, err := parseProxyRoutes(args.ProxyRoutes)
+	if err != nil {
+		return nil, err
+	}
+
+	if args.LocalAppview == "" {
+		args.LocalAppview = LocalAppviewOff
+	}
+
+	if err := validateLocalAppview(args.LocalAppview); err != nil {
+		return nil, err
+	}
+
 	if args.Logger == nil {
 		args.Logger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
 	}
 
 	e := echo.New()
 
+	ipExtractor, err := newIPExtractor(args.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	e.IPExtractor = ipExtractor
+
 	e.Pre(middleware.RemoveTrailingSlash())
 	e.Pre(slogecho.New(args.Logger))
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
@@ -259,36 +475,11 @@ func New(args *Args) (*Server, error) {
 		AllowHeaders:     []string{"*"},
 		AllowMethods:     []string{"*"},
 		AllowCredentials: true,
+		ExposeHeaders:    []string{"DPoP-Nonce", "WWW-Authenticate"},
 		MaxAge:           100_000_000,
 	}))
 
-	vdtor := validator.New()
-	vdtor.RegisterValidation("atproto-handle", func(fl validator.FieldLevel) bool {
-		if _, err := syntax.ParseHandle(fl.Field().String()); err != nil {
-			return false
-		}
-		return true
-	})
-	vdtor.RegisterValidation("atproto-did", func(fl validator.FieldLevel) bool {
-		if _, err := syntax.ParseDID(fl.Field().String()); err != nil {
-			return false
-		}
-		return true
-	})
-	vdtor.RegisterValidation("atproto-rkey", func(fl validator.FieldLevel) bool {
-		if _, err := syntax.ParseRecordKey(fl.Field().String()); err != nil {
-			return false
-		}
-		return true
-	})
-	vdtor.RegisterValidation("atproto-nsid", func(fl validator.FieldLevel) bool {
-		if _, err := syntax.ParseNSID(fl.Field().String()); err != nil {
-			return false
-		}
-		return true
-	})
-
-	e.Validator = &CustomValidator{validator: vdtor}
+	e.Validator = newValidator()
 
 	httpd := &http.Server{
 		Addr:    args.Addr,
@@ -333,7 +524,10 @@ func New(args *Args) (*Server, error) {
 	}
 
 	s := &Server{
-		http:       h,
+		http: h,
+		proxyClient: &http.Client{
+			Timeout: proxyTimeout,
+		},
 		httpd:      httpd,
 		echo:       e,
 		logger:     args.Logger,
@@ -344,18 +538,58 @@ func New(args *Args) (*Server, error) {
 			Version:        args.Version,
 			Did:            args.Did,
 			Hostname:       args.Hostname,
+			UserDomains:    normalizeUserDomains(args.Hostname, args.UserDomains),
 			ContactEmail:   args.ContactEmail,
 			EnforcePeering: false,
 			Relays:         args.Relays,
+			ModerationDids: args.ModerationDids,
+			DefaultAppview: args.DefaultAppview,
+			ProxyRoutes:    proxyRoutes,
+			LocalAppview:   args.LocalAppview,
+			MetricsAddr:    args.MetricsAddr,
 			AdminPassword:  args.AdminPassword,
 			SmtpName:       args.SmtpName,
 			SmtpEmail:      args.SmtpEmail,
+
+			RegistrationPolicy: args.RegistrationPolicy,
+			InviteInterval:     args.InviteInterval,
+			InviteCodeExpiry:   args.InviteCodeExpiry,
+
+			HandleChangeCooldown: args.HandleChangeCooldown,
+			HandleChangeLimit:    args.HandleChangeLimit,
 		},
 		evtman:   events.NewEventManager(events.NewMemPersister()),
 		passport: identity.NewPassport(h, identity.NewMemCache(10_000)),
 	}
 
 	s.repoman = NewRepoMan(s) // TODO: this is way too lazy, stop it
+	s.upstreams = NewUpstreamPool(s)
+
+	// clients on the local network are only reachable in development, when insecure clients are allowed
+	s.oauthHttp = oauth.NewPublicHttpClient()
+	if args.OAuthAllowInsecureClients {
+		s.oauthHttp = h
+	}
+
+	if args.OAuthClientFetcher == nil {
+		args.OAuthClientFetcher = oauth.NewHttpClientFetcher(s.oauthHttp)
+	}
+
+	s.oauthReplay = oauth.NewReplayCache()
+	s.dpop = oauth.NewDpopManager(s.oauthReplay)
+	s.oauthClients = oauth.NewClientResolver(args.OAuthClientFetcher, args.OAuthAllowInsecureClients)
+
+	if !args.DisableRateLimits {
+		s.rateLimiter = NewRateLimiter()
+	}
+
+	if args.DnsAddr != "" {
+		hd, err := NewHandleDNS(s, args.DnsAddr, args.DnsAddresses)
+		if err != nil {
+			return nil, err
+		}
+		s.dns = hd
+	}
 
 	// TODO: should validate these args
 	if args.SmtpUser == "" || args.SmtpPass == "" || args.SmtpHost == "" || args.SmtpPort == "" || args.SmtpEmail == "" || args.SmtpName == "" {
@@ -373,43 +607,77 @@ func New(args *Args) (*Server, error) {
 }
 
 func (s *Server) addRoutes() {
+	s.echo.Pre(s.handleUserDomainMiddleware)
+
 	// random stuff
 	s.echo.GET("/", s.handleRoot)
 	s.echo.GET("/xrpc/_health", s.handleHealth)
 	s.echo.GET("/.well-known/did.json", s.handleWellKnown)
 	s.echo.GET("/robots.txt", s.handleRobots)
 
+	// oauth
+	s.echo.GET("/.well-known/oauth-protected-resource", s.handleOAuthProtectedResource)
+	s.echo.GET("/.well-known/oauth-authorization-server", s.handleOAuthAuthorizationServer)
+	s.echo.GET("/oauth/jwks", s.handleOAuthJwks)
+	s.echo.POST("/oauth/par", s.handleOAuthPar)
+	s.echo.GET("/oauth/authorize", s.handleOAuthAuthorize)
+	s.echo.POST("/oauth/authorize/sign-in", s.handleOAuthAuthorizeSignIn) // rate limited by ip and account in the handler, so limits show up on the page
+	s.echo.POST("/oauth/authorize/sign-out", s.handleOAuthAuthorizeSignOut)
+	s.echo.POST("/oauth/authorize/accept", s.handleOAuthAuthorizeAccept)
+	s.echo.POST("/oauth/authorize/reject", s.handleOAuthAuthorizeReject)
+	s.echo.POST("/oauth/token", s.handleOAuthToken)
+	s.echo.POST("/oauth/revoke", s.handleOAuthRevoke)
+
 	// public
 	s.echo.GET("/xrpc/com.atproto.identity.resolveHandle", s.handleResolveHandle)
-	s.echo.POST("/xrpc/com.atproto.server.createAccount", s.handleCreateAccount)
-	s.echo.POST("/xrpc/com.atproto.server.createAccount", s.handleCreateAccount)
-	s.echo.POST("/xrpc/com.atproto.server.createSession", s.handleCreateSession)
+	s.echo.POST("/xrpc/com.atproto.server.createAccount", s.handleCreateAccount, s.rateLimitByIp(rateLimitCreateAccountIp))
+	s.echo.POST("/xrpc/com.atproto.server.createSession", s.handleCreateSession, s.rateLimitByIp(rateLimitLoginIp))
 	s.echo.GET("/xrpc/com.atproto.server.describeServer", s.handleDescribeServer)
+	s.echo.POST("/xrpc/com.atproto.server.reserveSigningKey", s.handleServerReserveSigningKey, s.rateLimitByIp(rateLimitReserveSigningKeyIp))
+	s.echo.GET("/xrpc/com.atproto.server.getServiceAuth", s.handleServerGetServiceAuth, s.handleSessionMiddleware)
+	s.echo.POST("/xrpc/com.atproto.server.deleteAccount", s.handleServerDeleteAccount, s.rateLimitByIp(rateLimitLoginIp))
+	s.echo.POST("/xrpc/cocoon.server.joinWaitlist", s.handleServerJoinWaitlist, s.rateLimitByIp(rateLimitWaitlistIp))
 
 	s.echo.GET("/xrpc/com.atproto.repo.describeRepo", s.handleDescribeRepo)
 	s.echo.GET("/xrpc/com.atproto.sync.listRepos", s.handleListRepos)
 	s.echo.GET("/xrpc/com.atproto.repo.listRecords", s.handleListRecords)
 	s.echo.GET("/xrpc/com.atproto.repo.getRecord", s.handleRepoGetRecord)
-	s.echo.GET("/xrpc/com.atproto.sync.getRecord", s.handleSyncGetRecord)
+	s.echo.GET("/xrpc/com.atproto.sync.getRecord", s.handleSyncGetRecord, s.handleOptionalServiceAuthMiddleware)
 	s.echo.GET("/xrpc/com.atproto.sync.getBlocks", s.handleGetBlocks)
 	s.echo.GET("/xrpc/com.atproto.sync.getLatestCommit", s.handleSyncGetLatestCommit)
 	s.echo.GET("/xrpc/com.atproto.sync.getRepoStatus", s.handleSyncGetRepoStatus)
 	s.echo.GET("/xrpc/com.atproto.sync.getRepo", s.handleSyncGetRepo)
 	s.echo.GET("/xrpc/com.atproto.sync.subscribeRepos", s.handleSyncSubscribeRepos)
 	s.echo.GET("/xrpc/com.atproto.sync.listBlobs", s.handleSyncListBlobs)
-	s.echo.GET("/xrpc/com.atproto.sync.getBlob", s.handleSyncGetBlob)
+	s.echo.GET("/xrpc/com.atproto.sync.getBlob", s.handleSyncGetBlob, s.handleOptionalServiceAuthMiddleware)
 
 	// authed
 	s.echo.GET("/xrpc/com.atproto.server.getSession", s.handleGetSession, s.handleSessionMiddleware)
 	s.echo.POST("/xrpc/com.atproto.server.refreshSession", s.handleRefreshSession, s.handleSessionMiddleware)
 	s.echo.POST("/xrpc/com.atproto.server.deleteSession", s.handleDeleteSession, s.handleSessionMiddleware)
 	s.echo.POST("/xrpc/com.atproto.identity.updateHandle", s.handleIdentityUpdateHandle, s.handleSessionMiddleware)
+	s.echo.GET("/xrpc/com.atproto.identity.getRecommendedDidCredentials", s.handleIdentityGetRecommendedDidCredentials, s.handleSessionMiddleware)
+	s.echo.POST("/xrpc/com.atproto.identity.requestPlcOperationSignature", s.handleIdentityRequestPlcOperationSignature, s.rateLimitByIp(rateLimitEmailIp), s.handleSessionMiddleware)
+	s.echo.POST("/xrpc/com.atproto.identity.signPlcOperation", s.handleIdentitySignPlcOperation, s.handleSessionMiddleware)
+	s.echo.POST("/xrpc/com.atproto.identity.submitPlcOperation", s.handleIdentitySubmitPlcOperation, s.handleSessionMiddleware)
 	s.echo.POST("/xrpc/com.atproto.server.confirmEmail", s.handleServerConfirmEmail, s.handleSessionMiddleware)
-	s.echo.POST("/xrpc/com.atproto.server.requestEmailConfirmation", s.handleServerRequestEmailConfirmation, s.handleSessionMiddleware)
-	s.echo.POST("/xrpc/com.atproto.server.requestPasswordReset", s.handleServerRequestPasswordReset) // AUTH NOT REQUIRED FOR THIS ONE
-	s.echo.POST("/xrpc/com.atproto.server.requestEmailUpdate", s.handleServerRequestEmailUpdate, s.handleSessionMiddleware)
-	s.echo.POST("/xrpc/com.atproto.server.resetPassword", s.handleServerResetPassword, s.handleSessionMiddleware)
+	s.echo.POST("/xrpc/com.atproto.server.requestEmailConfirmation", s.handleServerRequestEmailConfirmation, s.rateLimitByIp(rateLimitEmailIp), s.handleSessionMiddleware)
+	s.echo.POST("/xrpc/com.atproto.server.requestPasswordReset", s.handleServerRequestPasswordReset, s.rateLimitByIp(rateLimitEmailIp)) // AUTH NOT REQUIRED FOR THIS ONE
+	s.echo.POST("/xrpc/com.atproto.server.requestEmailUpdate", s.handleServerRequestEmailUpdate, s.rateLimitByIp(rateLimitEmailIp), s.handleSessionMiddleware)
+	s.echo.POST("/xrpc/com.atproto.server.resetPassword", s.handleServerResetPassword, s.rateLimitByIp(rateLimitResetPasswordIp), s.handleSessionMiddleware)
 	s.echo.POST("/xrpc/com.atproto.server.updateEmail", s.handleServerUpdateEmail, s.handleSessionMiddleware)
+	s.echo.POST("/xrpc/com.atproto.server.activateAccount", s.handleServerActivateAccount, s.handleSessionMiddleware)
+	s.echo.POST("/xrpc/com.atproto.server.deactivateAccount", s.handleServerDeactivateAccount, s.handleSessionMiddleware)
+	s.echo.GET("/xrpc/com.atproto.server.checkAccountStatus", s.handleServerCheckAccountStatus, s.handleSessionMiddleware)
+	s.echo.POST("/xrpc/com.atproto.server.requestAccountDelete", s.handleServerRequestAccountDelete, s.rateLimitByIp(rateLimitEmailIp), s.handleSessionMiddleware)
+	s.echo.GET("/xrpc/com.atproto.server.getAccountInviteCodes", s.handleServerGetAccountInviteCodes, s.handleSessionMiddleware)
+	s.echo.POST("/xrpc/cocoon.server.enrollTotp", s.handleServerEnrollTotp, s.handleSessionMiddleware)
+	s.echo.POST("/xrpc/cocoon.server.confirmTotp", s.handleServerConfirmTotp, s.handleSessionMiddleware)
+	s.echo.POST("/xrpc/cocoon.server.disableTotp", s.handleServerDisableTotp, s.handleSessionMiddleware)
+	s.echo.POST("/xrpc/cocoon.server.createTotpRecoveryCodes", s.handleServerCreateTotpRecoveryCodes, s.handleSessionMiddleware)
+	s.echo.GET("/xrpc/cocoon.server.listSessions", s.handleServerListSessions, s.handleSessionMiddleware)
+	s.echo.POST("/xrpc/cocoon.server.revokeSession", s.handleServerRevokeSession, s.handleSessionMiddleware)
+	s.echo.POST("/xrpc/cocoon.server.revokeOtherSessions", s.handleServerRevokeOtherSessions, s.handleSessionMiddleware)
 
 	// repo
 	s.echo.POST("/xrpc/com.atproto.repo.createRecord", s.handleCreateRecord, s.handleSessionMiddleware)
@@ -417,6 +685,7 @@ func (s *Server) addRoutes() {
 	s.echo.POST("/xrpc/com.atproto.repo.deleteRecord", s.handleDeleteRecord, s.handleSessionMiddleware)
 	s.echo.POST("/xrpc/com.atproto.repo.applyWrites", s.handleApplyWrites, s.handleSessionMiddleware)
 	s.echo.POST("/xrpc/com.atproto.repo.uploadBlob", s.handleRepoUploadBlob, s.handleSessionMiddleware)
+	s.echo.POST("/xrpc/com.atproto.repo.importRepo", s.handleRepoImportRepo, s.handleSessionMiddleware)
 
 	// stupid silly endpoints
 	s.echo.GET("/xrpc/app.bsky.actor.getPreferences", s.handleActorGetPreferences, s.handleSessionMiddleware)
@@ -425,10 +694,33 @@ func (s *Server) addRoutes() {
 	// are there any routes that we should be allowing without auth? i dont think so but idk
 	s.echo.GET("/xrpc/*", s.handleProxy, s.handleSessionMiddleware)
 	s.echo.POST("/xrpc/*", s.handleProxy, s.handleSessionMiddleware)
-	
+
 	// admin routes
 	s.echo.POST("/xrpc/com.atproto.server.createInviteCode", s.handleCreateInviteCode, s.handleAdminMiddleware)
 	s.echo.POST("/xrpc/com.atproto.server.createInviteCodes", s.handleCreateInviteCodes, s.handleAdminMiddleware)
+	s.echo.POST("/xrpc/com.atproto.admin.updateSubjectStatus", s.handleAdminUpdateSubjectStatus, s.handleAdminMiddleware)
+	s.echo.GET("/xrpc/com.atproto.admin.getSubjectStatus", s.handleAdminGetSubjectStatus, s.handleAdminMiddleware)
+	s.echo.GET("/xrpc/com.atproto.admin.getAccountInfo", s.handleAdminGetAccountInfo, s.handleAdminMiddleware)
+	s.echo.GET("/xrpc/com.atproto.admin.getAccountInfos", s.handleAdminGetAccountInfos, s.handleAdminMiddleware)
+	s.echo.GET("/xrpc/com.atproto.admin.searchAccounts", s.handleAdminSearchAccounts, s.handleAdminMiddleware)
+	s.echo.POST("/xrpc/com.atproto.admin.updateAccountHandle", s.handleAdminUpdateAccountHandle, s.handleAdminMiddleware)
+	s.echo.POST("/xrpc/com.atproto.admin.updateAccountEmail", s.handleAdminUpdateAccountEmail, s.handleAdminMiddleware)
+	s.echo.POST("/xrpc/com.atproto.admin.updateAccountPassword", s.handleAdminUpdateAccountPassword, s.handleAdminMiddleware)
+	s.echo.POST("/xrpc/com.atproto.admin.deleteAccount", s.handleAdminDeleteAccount, s.handleAdminMiddleware)
+	s.echo.POST("/xrpc/com.atproto.admin.sendEmail", s.handleAdminSendEmail, s.handleAdminMiddleware)
+	s.echo.GET("/xrpc/com.atproto.admin.getInviteCodes", s.handleAdminGetInviteCodes, s.handleAdminMiddleware)
+	s.echo.POST("/xrpc/com.atproto.admin.disableInviteCodes", s.handleAdminDisableInviteCodes, s.handleAdminMiddleware)
+	s.echo.POST("/xrpc/com.atproto.admin.disableAccountInvites", s.handleAdminDisableAccountInvites, s.handleAdminMiddleware)
+	s.echo.POST("/xrpc/com.atproto.admin.enableAccountInvites", s.handleAdminEnableAccountInvites, s.handleAdminMiddleware)
+	s.echo.GET("/xrpc/cocoon.admin.getWaitlist", s.handleAdminGetWaitlist, s.handleAdminMiddleware)
+	s.echo.POST("/xrpc/cocoon.admin.approveWaitlistEntry", s.handleAdminApproveWaitlistEntry, s.handleAdminMiddleware)
+	s.echo.GET("/xrpc/cocoon.admin.getHandleBlocklist", s.handleAdminGetHandleBlocklist, s.handleAdminMiddleware)
+	s.echo.POST("/xrpc/cocoon.admin.addHandleBlocklistTerm", s.handleAdminAddHandleBlocklistTerm, s.handleAdminMiddleware)
+	s.echo.POST("/xrpc/cocoon.admin.removeHandleBlocklistTerm", s.handleAdminRemoveHandleBlocklistTerm, s.handleAdminMiddleware)
+	s.echo.GET("/xrpc/cocoon.admin.getProxyUpstreams", s.handleAdminGetProxyUpstreams, s.handleAdminMiddleware)
+	s.echo.POST("/xrpc/cocoon.admin.addProxyUpstream", s.handleAdminAddProxyUpstream, s.handleAdminMiddleware)
+	s.echo.POST("/xrpc/cocoon.admin.updateProxyUpstream", s.handleAdminUpdateProxyUpstream, s.handleAdminMiddleware)
+	s.echo.POST("/xrpc/cocoon.admin.removeProxyUpstream", s.handleAdminRemoveProxyUpstream, s.handleAdminMiddleware)
 }
 
 func (s *Server) Serve(ctx context.Context) error {
@@ -436,26 +728,38 @@ func (s *Server) Serve(ctx context.Context) error {
 
 	s.logger.Info("migrating...")
 
-	s.db.AutoMigrate(
-		&models.Actor{},
-		&models.Repo{},
-		&models.InviteCode{},
-		&models.Token{},
-		&models.RefreshToken{},
-		&models.Block{},
-		&models.Record{},
-		&models.Blob{},
-		&models.BlobPart{},
-	)
+	s.db.AutoMigrate(dbModels...)
+
+	if err := s.upstreams.Load(); err != nil {
+		return err
+	}
+	go s.upstreams.Run(ctx)
 
 	s.logger.Info("starting cocoon")
 
+	if s.config.MetricsAddr != "" {
+		go func() {
+			if err := http.ListenAndServe(s.config.MetricsAddr, promhttp.Handler()); err != nil {
+				s.logger.Error("error serving metrics", "error", err)
+			}
+		}()
+	}
+
 	go func() {
 		if err := s.httpd.ListenAndServe(); err != nil {
 			panic(err)
 		}
 	}()
 
+	if s.dns != nil {
+		if err := s.dns.Start(); err != nil {
+			return err
+		}
+		defer s.dns.Shutdown(context.TODO())
+
+		s.logger.Info("serving handle dns", "zones", s.config.UserDomains)
+	}
+
 	for _, relay := range s.config.Relays {
 		cli := xrpc.Client{Host: relay}
 		atproto.SyncRequestCrawl(ctx, &cli, &atproto.SyncRequestCrawl_Input{
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bluesky-social/indigo/events"
+	"github.com/domodwyer/mailyak/v3"
+	"github.com/haileyok/cocoon/identity"
+	"github.com/labstack/echo/v4"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	testServerDid      = "did:web:pds.example.com"
+	testServerHostname = "pds.example.com"
+)
+
+// a server on a fresh database with every table migrated. did documents and handles are only ever read from
+// the returned cache, and mail goes nowhere. tests set up anything else they need themselves
+func newTestServer(t *testing.T) (*Server, *identity.MemCache) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "cocoon.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(dbModels...); err != nil {
+		t.Fatal(err)
+	}
+
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := identity.NewMemCache(100)
+
+	return &Server{
+		db:         db,
+		logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+		privateKey: pk,
+		passport:   identity.NewPassport(http.DefaultClient, cache),
+		evtman:     events.NewEventManager(events.NewMemPersister()),
+		mail:       mailyak.New("127.0.0.1:1", nil),
+		mailLk:     &sync.Mutex{},
+		config: &config{
+			Did:         testServerDid,
+			Hostname:    testServerHostname,
+			UserDomains: []string{"." + testServerHostname},
+		},
+	}, cache
+}
+
+// a context for calling a handler directly, with a json body if there is one
+func newTestContext(method, target, body string) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("content-type", "application/json")
+	}
+
+	rec := httptest.NewRecorder()
+
+	e := echo.New()
+	e.Validator = newValidator()
+
+	return e.NewContext(req, rec), rec
+}
@@ -0,0 +1,381 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/google/uuid"
+	"github.com/haileyok/cocoon/internal/helpers"
+	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	serviceAuthDefaultExpiry    = 1 * time.Minute
+	serviceAuthMaxExpiry        = 1 * time.Hour
+	serviceAuthPrivilegedExpiry = 1 * time.Minute
+)
+
+// methods that nobody should be able to act on an account through with a service auth token
+var serviceAuthProtectedMethods = map[string]struct{}{
+	"com.atproto.admin.sendEmail":                       {},
+	"com.atproto.identity.requestPlcOperationSignature": {},
+	"com.atproto.identity.signPlcOperation":             {},
+	"com.atproto.identity.submitPlcOperation":           {},
+	"com.atproto.identity.updateHandle":                 {},
+	"com.atproto.server.activateAccount":                {},
+	"com.atproto.server.confirmEmail":                   {},
+	"com.atproto.server.createAppPassword":              {},
+	"com.atproto.server.deactivateAccount":              {},
+	"com.atproto.server.getAccountInviteCodes":          {},
+	"com.atproto.server.getServiceAuth":                 {},
+	"com.atproto.server.requestAccountDelete":           {},
+	"com.atproto.server.requestEmailConfirmation":       {},
+	"com.atproto.server.requestEmailUpdate":             {},
+	"com.atproto.server.requestPasswordReset":           {},
+	"com.atproto.server.resetPassword":                  {},
+	"com.atproto.server.updateEmail":                    {},
+}
+
+// admin methods that our moderation services can call with service auth. everything else under the admin
+// middleware needs the admin password
+var serviceAuthModerationMethods = map[string]struct{}{
+	"com.atproto.admin.getAccountInfo":      {},
+	"com.atproto.admin.getAccountInfos":     {},
+	"com.atproto.admin.getSubjectStatus":    {},
+	"com.atproto.admin.sendEmail":           {},
+	"com.atproto.admin.updateSubjectStatus": {},
+}
+
+// methods that tokens can be minted for, but only short lived ones that are bound to the method
+var serviceAuthPrivilegedMethods = map[string]struct{}{
+	"com.atproto.server.createAccount": {},
+}
+
+func isPrivilegedServiceAuthMethod(lxm string) bool {
+	if _, ok := serviceAuthPrivilegedMethods[lxm]; ok {
+		return true
+	}
+	return strings.HasPrefix(lxm, "chat.bsky.")
+}
+
+type serviceAuthClaims struct {
+	Iss string `json:"iss"`
+	Aud string `json:"aud"`
+	Iat int64  `json:"iat,omitempty"`
+	Exp int64  `json:"exp"`
+	Lxm string `json:"lxm,omitempty"`
+	Jti string `json:"jti,omitempty"`
+}
+
+type ServiceAuthError struct {
+	Name    string
+	Message string
+}
+
+func (se *ServiceAuthError) Error() string {
+	return se.Message
+}
+
+// the rules every service auth token we mint has to follow, whoever asked for it
+func checkServiceAuthRequest(lxm string, exp time.Time) error {
+	if _, ok := serviceAuthProtectedMethods[lxm]; ok {
+		return &ServiceAuthError{Name: "InvalidRequest", Message: "Service auth tokens can't be created for " + lxm}
+	}
+
+	now := time.Now()
+
+	if !exp.After(now) {
+		return &ServiceAuthError{Name: "BadExpiration", Message: "Expiration is in the past"}
+	}
+
+	if exp.Sub(now) > serviceAuthMaxExpiry {
+		return &ServiceAuthError{Name: "BadExpiration", Message: "Expiration can't be more than an hour from now"}
+	}
+
+	// a token without a method can be used for anything, so it can't live long either
+	if (lxm == "" || isPrivilegedServiceAuthMethod(lxm)) && exp.Sub(now) > serviceAuthPrivilegedExpiry {
+		return &ServiceAuthError{Name: "BadExpiration", Message: "Tokens without lxm, or for privileged methods, can't be valid for more than a minute"}
+	}
+
+	return nil
+}
+
+// mints a service auth jwt for the account, signed with its repo signing key. this is the only place
+// that tokens for other services should be created. returns a *ServiceAuthError if the method or
+// expiration isn't allowed
+func (s *Server) signServiceAuth(urepo *models.RepoActor, aud string, lxm string, exp time.Time) (string, error) {
+	if err := checkServiceAuthRequest(lxm, exp); err != nil {
+		return "", err
+	}
+
+	k, err := crypto.ParsePrivateBytesK256(urepo.SigningKey)
+	if err != nil {
+		return "", err
+	}
+
+	hj, err := json.Marshal(map[string]string{
+		"alg": "ES256K",
+		"typ": "JWT",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	pj, err := json.Marshal(serviceAuthClaims{
+		Iss: urepo.Repo.Did,
+		Aud: aud,
+		Iat: time.Now().Unix(),
+		Exp: exp.Unix(),
+		Lxm: lxm,
+		Jti: uuid.NewString(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	input := base64.RawURLEncoding.EncodeToString(hj) + "." + base64.RawURLEncoding.EncodeToString(pj)
+
+	// this gives us the 64 byte, low-S r||s signature that ES256K jwts use
+	sig, err := k.HashAndSign([]byte(input))
+	if err != nil {
+		return "", err
+	}
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// checks a service auth jwt against the signing key in its issuer's did document. aud has to be
+// the given audience, and if the token is bound to a method, it has to be lxm. when issuers isn't nil the
+// issuer has to be one of them, which is checked before its did is resolved
+func (s *Server) verifyServiceAuth(ctx context.Context, token string, aud string, lxm string, issuers []string) (*serviceAuthClaims, error) {
+	pts := strings.Split(token, ".")
+	if len(pts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	hb, err := base64.RawURLEncoding.DecodeString(pts[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token header: %w", err)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(hb, &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %w", err)
+	}
+
+	if header.Alg != "ES256K" && header.Alg != "ES256" {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", header.Alg)
+	}
+
+	pb, err := base64.RawURLEncoding.DecodeString(pts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token payload: %w", err)
+	}
+
+	var claims serviceAuthClaims
+	if err := json.Unmarshal(pb, &claims); err != nil {
+		return nil, fmt.Errorf("malformed token payload: %w", err)
+	}
+
+	if claims.Exp < time.Now().Unix() {
+		return nil, fmt.Errorf("token has expired")
+	}
+
+	// the audience can also be a specific service on the did, like did:web:example.com#atproto_pds
+	if claims.Aud != aud && strings.Split(claims.Aud, "#")[0] != aud {
+		return nil, fmt.Errorf("token audience %q does not match %q", claims.Aud, aud)
+	}
+
+	if claims.Lxm != "" && claims.Lxm != lxm {
+		return nil, fmt.Errorf("token is not valid for %s", lxm)
+	}
+
+	if claims.Lxm == "" && isPrivilegedServiceAuthMethod(lxm) {
+		return nil, fmt.Errorf("token must be bound to %s", lxm)
+	}
+
+	if claims.Jti == "" {
+		return nil, fmt.Errorf("token is missing a jti")
+	}
+
+	// the issuer can be a service on a did, like did:web:example.com#atproto_labeler
+	issDid := strings.Split(claims.Iss, "#")[0]
+	if issuers != nil && !slices.Contains(issuers, issDid) {
+		return nil, fmt.Errorf("tokens from %s aren't accepted here", issDid)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(pts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %w", err)
+	}
+
+	signed := []byte(pts[0] + "." + pts[1])
+
+	verify := func(ctx context.Context) error {
+		doc, err := s.passport.FetchDoc(ctx, issDid)
+		if err != nil {
+			return err
+		}
+
+		k, err := doc.AtprotoSigningKey()
+		if err != nil {
+			return err
+		}
+
+		return k.HashAndVerifyLenient(signed, sig)
+	}
+
+	if err := verify(ctx); err != nil {
+		// the key might have been rotated since we cached the doc, so try again with a fresh one
+		if err := verify(context.WithValue(ctx, "skip-cache", true)); err != nil {
+			return nil, fmt.Errorf("could not verify token signature: %w", err)
+		}
+	}
+
+	if err := s.db.Exec("DELETE FROM used_service_auth_tokens WHERE expires_at < ?", time.Now()).Error; err != nil {
+		return nil, err
+	}
+
+	// jtis are only unique per issuer, so one service can't use up another's
+	res := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.UsedServiceAuthToken{
+		Iss:       issDid,
+		Jti:       claims.Jti,
+		ExpiresAt: time.Unix(claims.Exp, 0),
+	})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return nil, fmt.Errorf("token has already been used")
+	}
+
+	return &claims, nil
+}
+
+// methods an account can't call with a service auth token in place of a session, on top of the ones nobody
+// can get a token for. these manage sessions, or are what oauth apps aren't trusted with either
+var serviceAuthSessionForbiddenMethods = map[string]struct{}{
+	"com.atproto.server.deleteSession":  {},
+	"com.atproto.server.refreshSession": {},
+}
+
+func serviceAuthSessionAllows(lxm string) bool {
+	if _, ok := serviceAuthProtectedMethods[lxm]; ok {
+		return false
+	}
+	if _, ok := serviceAuthSessionForbiddenMethods[lxm]; ok {
+		return false
+	}
+	return !oauthForbiddenMethods[lxm]
+}
+
+// returns the issuer of a bearer token that looks like service auth rather than one of our own session
+// tokens, which have a scope and no issuer. the token isn't verified
+func serviceAuthTokenIssuer(token string) (string, bool) {
+	pts := strings.Split(token, ".")
+	if len(pts) != 3 {
+		return "", false
+	}
+
+	pb, err := base64.RawURLEncoding.DecodeString(pts[1])
+	if err != nil {
+		return "", false
+	}
+
+	var claims struct {
+		Iss   string `json:"iss"`
+		Scope string `json:"scope"`
+	}
+	if err := json.Unmarshal(pb, &claims); err != nil {
+		return "", false
+	}
+
+	if claims.Iss == "" || claims.Scope != "" {
+		return "", false
+	}
+
+	return strings.Split(claims.Iss, "#")[0], true
+}
+
+// authenticates a request as an account here with a service auth token signed by the account's own key, i.e.
+// one another service got from getServiceAuth to call back to us. the token has to be bound to the method
+func (s *Server) handleServiceAuthSession(e echo.Context, next echo.HandlerFunc, token string, iss string) error {
+	lxm := strings.TrimPrefix(e.Request().URL.Path, "/xrpc/")
+	if !serviceAuthSessionAllows(lxm) {
+		return helpers.InputErrorWithMessage(e, "InvalidToken", lxm+" can't be called with service auth")
+	}
+
+	// only accounts that live here are resolved, so tokens can't be used to make us fetch arbitrary dids
+	repo, err := s.getRepoActorByDid(iss)
+	if err != nil {
+		s.logger.Error("error fetching repo", "error", err)
+		return helpers.ServerError(e, nil)
+	}
+
+	if repo.Repo.Did == "" {
+		return helpers.InputError(e, to.StringPtr("InvalidToken"))
+	}
+
+	claims, err := s.verifyServiceAuth(e.Request().Context(), token, s.config.Did, lxm, []string{repo.Repo.Did})
+	if err != nil {
+		return helpers.InputErrorWithMessage(e, "InvalidToken", err.Error())
+	}
+
+	if claims.Lxm != lxm {
+		return helpers.InputErrorWithMessage(e, "InvalidToken", "Service auth tokens must be bound to "+lxm)
+	}
+
+	if repo.TakedownRef != nil {
+		return helpers.InputError(e, to.StringPtr("AccountTakedown"))
+	}
+
+	e.Set("repo", repo)
+	e.Set("did", repo.Repo.Did)
+	e.Set("token", token)
+	e.Set("serviceAuth", claims)
+
+	if err := next(e); err != nil {
+		e.Error(err)
+	}
+
+	return nil
+}
+
+// verifies the bearer token on a request as a service auth token from one of our moderation services, for
+// the method being called. returns nil claims if there is no bearer token at all
+func (s *Server) moderationServiceAuthFromRequest(e echo.Context) (*serviceAuthClaims, error) {
+	token, ok := strings.CutPrefix(e.Request().Header.Get("authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, nil
+	}
+
+	if len(s.config.ModerationDids) == 0 {
+		return nil, fmt.Errorf("no moderation services are configured")
+	}
+
+	lxm := strings.TrimPrefix(e.Request().URL.Path, "/xrpc/")
+
+	return s.verifyServiceAuth(e.Request().Context(), token, s.config.Did, lxm, s.config.ModerationDids)
+}
+
+func (s *Server) isModerationService(claims *serviceAuthClaims) bool {
+	return slices.Contains(s.config.ModerationDids, strings.Split(claims.Iss, "#")[0])
+}
+
+// whether the request was made with service auth from one of our moderation services
+func (s *Server) requestIsFromModerator(e echo.Context) bool {
+	claims, ok := e.Get("serviceAuth").(*serviceAuthClaims)
+	return ok && s.isModerationService(claims)
+}
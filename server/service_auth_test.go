@@ -0,0 +1,372 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/haileyok/cocoon/identity"
+	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+)
+
+// an account whose did document is in the cache, so tokens it signs can be verified
+func newServiceAuthTestAccount(t *testing.T, s *Server, cache *identity.MemCache, did string) *models.RepoActor {
+	t.Helper()
+
+	k, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	urepo := &models.RepoActor{
+		Repo:  models.Repo{Did: did, SigningKey: k.Bytes()},
+		Actor: models.Actor{Did: did, Handle: "alice.example.com"},
+	}
+
+	doc, err := s.didWebDocument(urepo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.PutDoc(did, doc); err != nil {
+		t.Fatal(err)
+	}
+
+	return urepo
+}
+
+func TestServiceAuthRoundTrip(t *testing.T) {
+	s, cache := newTestServer(t)
+	urepo := newServiceAuthTestAccount(t, s, cache, "did:web:alice.example.com")
+	ctx := context.Background()
+	exp := time.Now().Add(serviceAuthDefaultExpiry)
+
+	tests := []struct {
+		name     string
+		aud      string
+		tokenLxm string
+		exp      time.Time
+		lxm      string
+		wantErr  bool
+	}{
+		{name: "bound to the method", aud: testServerDid, tokenLxm: "app.bsky.feed.getTimeline", exp: exp, lxm: "app.bsky.feed.getTimeline"},
+		{name: "audience with a service id", aud: testServerDid + "#atproto_pds", tokenLxm: "app.bsky.feed.getTimeline", exp: exp, lxm: "app.bsky.feed.getTimeline"},
+		{name: "unbound", aud: testServerDid, exp: exp, lxm: "app.bsky.feed.getTimeline"},
+		{name: "wrong audience", aud: "did:web:other.example.com", tokenLxm: "app.bsky.feed.getTimeline", exp: exp, lxm: "app.bsky.feed.getTimeline", wantErr: true},
+		{name: "wrong method", aud: testServerDid, tokenLxm: "app.bsky.feed.getTimeline", exp: exp, lxm: "app.bsky.actor.getProfile", wantErr: true},
+		{name: "privileged method bound", aud: testServerDid, tokenLxm: "com.atproto.server.createAccount", exp: exp, lxm: "com.atproto.server.createAccount"},
+		{name: "privileged method unbound", aud: testServerDid, exp: exp, lxm: "com.atproto.server.createAccount", wantErr: true},
+		{name: "chat method unbound", aud: testServerDid, exp: exp, lxm: "chat.bsky.convo.sendMessage", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := s.signServiceAuth(urepo, tt.aud, tt.tokenLxm, tt.exp)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			claims, err := s.verifyServiceAuth(ctx, token, testServerDid, tt.lxm, nil)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if claims.Iss != urepo.Repo.Did || claims.Lxm != tt.tokenLxm || claims.Jti == "" {
+				t.Errorf("unexpected claims: %+v", claims)
+			}
+		})
+	}
+}
+
+func TestSignServiceAuthRules(t *testing.T) {
+	s, cache := newTestServer(t)
+	urepo := newServiceAuthTestAccount(t, s, cache, "did:web:alice.example.com")
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		lxm     string
+		exp     time.Time
+		wantErr string
+	}{
+		{name: "bound", lxm: "app.bsky.feed.getTimeline", exp: now.Add(serviceAuthMaxExpiry - time.Second)},
+		{name: "protected", lxm: "com.atproto.identity.updateHandle", exp: now.Add(time.Minute), wantErr: "InvalidRequest"},
+		{name: "expired", lxm: "app.bsky.feed.getTimeline", exp: now.Add(-time.Second), wantErr: "BadExpiration"},
+		{name: "privileged for too long", lxm: "chat.bsky.convo.getLog", exp: now.Add(5 * time.Minute), wantErr: "BadExpiration"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.signServiceAuth(urepo, "did:web:api.bsky.app", tt.lxm, tt.exp)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var serr *ServiceAuthError
+			if !errors.As(err, &serr) || serr.Name != tt.wantErr {
+				t.Fatalf("expected %s, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestServiceAuthReplay(t *testing.T) {
+	s, cache := newTestServer(t)
+	urepo := newServiceAuthTestAccount(t, s, cache, "did:web:alice.example.com")
+	ctx := context.Background()
+
+	token, err := s.signServiceAuth(urepo, testServerDid, "app.bsky.feed.getTimeline", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.verifyServiceAuth(ctx, token, testServerDid, "app.bsky.feed.getTimeline", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := s.verifyServiceAuth(ctx, token, testServerDid, "app.bsky.feed.getTimeline", nil); err == nil {
+		t.Fatal("expected a replayed token to be rejected")
+	}
+}
+
+// signs arbitrary claims with the account's key, for tokens that signServiceAuth would refuse to make
+func signTestServiceAuth(t *testing.T, urepo *models.RepoActor, claims serviceAuthClaims) string {
+	t.Helper()
+
+	k, err := crypto.ParsePrivateBytesK256(urepo.SigningKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hj, err := json.Marshal(map[string]string{"alg": "ES256K", "typ": "JWT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pj, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := base64.RawURLEncoding.EncodeToString(hj) + "." + base64.RawURLEncoding.EncodeToString(pj)
+
+	sig, err := k.HashAndSign([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestServiceAuthHandcrafted(t *testing.T) {
+	s, cache := newTestServer(t)
+	urepo := newServiceAuthTestAccount(t, s, cache, "did:web:alice.example.com")
+
+	tests := []struct {
+		name   string
+		claims serviceAuthClaims
+	}{
+		{name: "expired", claims: serviceAuthClaims{Exp: time.Now().Add(-time.Minute).Unix(), Jti: "expired"}},
+		{name: "no jti", claims: serviceAuthClaims{Exp: time.Now().Add(time.Minute).Unix()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.claims.Iss = urepo.Repo.Did
+			tt.claims.Aud = testServerDid
+			tt.claims.Lxm = "app.bsky.feed.getTimeline"
+
+			token := signTestServiceAuth(t, urepo, tt.claims)
+			if _, err := s.verifyServiceAuth(context.Background(), token, testServerDid, "app.bsky.feed.getTimeline", nil); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestServiceAuthIssuerAllowList(t *testing.T) {
+	s, cache := newTestServer(t)
+	urepo := newServiceAuthTestAccount(t, s, cache, "did:web:alice.example.com")
+	ctx := context.Background()
+
+	sign := func() string {
+		token, err := s.signServiceAuth(urepo, testServerDid, "com.atproto.admin.getSubjectStatus", time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	if _, err := s.verifyServiceAuth(ctx, sign(), testServerDid, "com.atproto.admin.getSubjectStatus", []string{"did:web:mod.example.com"}); err == nil {
+		t.Fatal("expected an issuer that isn't allowed to be rejected")
+	}
+
+	if _, err := s.verifyServiceAuth(ctx, sign(), testServerDid, "com.atproto.admin.getSubjectStatus", []string{urepo.Repo.Did}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestServiceAuthReplayIsPerIssuer(t *testing.T) {
+	s, cache := newTestServer(t)
+	alice := newServiceAuthTestAccount(t, s, cache, "did:web:alice.example.com")
+	bob := newServiceAuthTestAccount(t, s, cache, "did:web:bob.example.com")
+	exp := time.Now().Add(time.Minute).Unix()
+
+	for _, urepo := range []*models.RepoActor{alice, bob} {
+		token := signTestServiceAuth(t, urepo, serviceAuthClaims{
+			Iss: urepo.Repo.Did,
+			Aud: testServerDid,
+			Exp: exp,
+			Lxm: "app.bsky.feed.getTimeline",
+			Jti: "same-jti",
+		})
+
+		if _, err := s.verifyServiceAuth(context.Background(), token, testServerDid, "app.bsky.feed.getTimeline", nil); err != nil {
+			t.Fatalf("unexpected error for %s: %v", urepo.Repo.Did, err)
+		}
+	}
+}
+
+func TestServiceAuthSession(t *testing.T) {
+	s, cache := newTestServer(t)
+	urepo := newServiceAuthTestAccount(t, s, cache, "did:web:alice.example.com")
+	stranger := newServiceAuthTestAccount(t, s, cache, "did:web:stranger.example.com")
+
+	if err := s.db.Create(&urepo.Repo).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.db.Create(&urepo.Actor).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		urepo    *models.RepoActor
+		tokenLxm string
+		path     string
+		wantCode int
+	}{
+		{name: "bound to the method", urepo: urepo, tokenLxm: "app.bsky.actor.getPreferences", path: "app.bsky.actor.getPreferences", wantCode: 200},
+		{name: "bound to another method", urepo: urepo, tokenLxm: "app.bsky.actor.getPreferences", path: "app.bsky.actor.putPreferences", wantCode: 400},
+		{name: "unbound", urepo: urepo, path: "app.bsky.actor.getPreferences", wantCode: 400},
+		{name: "session management", urepo: urepo, tokenLxm: "com.atproto.server.deleteSession", path: "com.atproto.server.deleteSession", wantCode: 400},
+		{name: "oauth forbidden method", urepo: urepo, tokenLxm: "com.atproto.repo.importRepo", path: "com.atproto.repo.importRepo", wantCode: 400},
+		{name: "not an account here", urepo: stranger, tokenLxm: "app.bsky.actor.getPreferences", path: "app.bsky.actor.getPreferences", wantCode: 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := signTestServiceAuth(t, tt.urepo, serviceAuthClaims{
+				Iss: tt.urepo.Repo.Did,
+				Aud: testServerDid,
+				Exp: time.Now().Add(time.Minute).Unix(),
+				Lxm: tt.tokenLxm,
+				Jti: tt.name,
+			})
+
+			req := httptest.NewRequest("GET", "/xrpc/"+tt.path, nil)
+			req.Header.Set("authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+
+			var got *models.RepoActor
+			handler := s.handleSessionMiddleware(func(e echo.Context) error {
+				got = e.Get("repo").(*models.RepoActor)
+				return e.NoContent(200)
+			})
+
+			if err := handler(c); err != nil {
+				t.Fatal(err)
+			}
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("expected %d, got %d: %s", tt.wantCode, rec.Code, rec.Body.String())
+			}
+
+			if tt.wantCode == 200 && got.Repo.Did != urepo.Repo.Did {
+				t.Errorf("expected the request to be for %s, got %s", urepo.Repo.Did, got.Repo.Did)
+			}
+		})
+	}
+}
+
+func TestGetServiceAuth(t *testing.T) {
+	s, cache := newTestServer(t)
+	urepo := newServiceAuthTestAccount(t, s, cache, "did:web:alice.example.com")
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		query   string
+		scope   string
+		wantErr string
+	}{
+		{name: "bound", query: "aud=did:web:api.bsky.app&lxm=app.bsky.feed.getTimeline"},
+		{name: "bound for an hour", query: "aud=did:web:api.bsky.app&lxm=app.bsky.feed.getTimeline&exp=" + unixString(now.Add(serviceAuthMaxExpiry-time.Second))},
+		{name: "too long", query: "aud=did:web:api.bsky.app&lxm=app.bsky.feed.getTimeline&exp=" + unixString(now.Add(2*time.Hour)), wantErr: "BadExpiration"},
+		{name: "in the past", query: "aud=did:web:api.bsky.app&exp=" + unixString(now.Add(-time.Minute)), wantErr: "BadExpiration"},
+		{name: "unbound for too long", query: "aud=did:web:api.bsky.app&exp=" + unixString(now.Add(10*time.Minute)), wantErr: "BadExpiration"},
+		{name: "privileged for too long", query: "aud=did:web:other.example.com&lxm=com.atproto.server.createAccount&exp=" + unixString(now.Add(10*time.Minute)), wantErr: "BadExpiration"},
+		{name: "protected method", query: "aud=did:web:api.bsky.app&lxm=com.atproto.server.updateEmail", wantErr: "InvalidRequest"},
+		{name: "protected getServiceAuth", query: "aud=did:web:api.bsky.app&lxm=com.atproto.server.getServiceAuth", wantErr: "InvalidRequest"},
+		{name: "aud isn't a did", query: "aud=api.bsky.app", wantErr: "InvalidRequest"},
+		{name: "oauth", query: "aud=did:web:video.bsky.app&lxm=com.atproto.repo.uploadBlob", scope: "atproto transition:generic"},
+		{name: "oauth createAccount", query: "aud=did:web:other.example.com&lxm=com.atproto.server.createAccount", scope: "atproto transition:generic", wantErr: "InvalidRequest"},
+		{name: "oauth without the chat scope", query: "aud=did:web:api.bsky.chat&lxm=chat.bsky.convo.getLog", scope: "atproto transition:generic", wantErr: "InvalidRequest"},
+		{name: "oauth with the chat scope", query: "aud=did:web:api.bsky.chat&lxm=chat.bsky.convo.getLog", scope: "atproto transition:generic transition:chat.bsky"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/xrpc/com.atproto.server.getServiceAuth?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			c.Set("repo", urepo)
+			if tt.scope != "" {
+				c.Set("oauthScope", tt.scope)
+			}
+
+			if err := s.handleServerGetServiceAuth(c); err != nil {
+				t.Fatal(err)
+			}
+
+			var body map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.wantErr != "" {
+				if rec.Code != 400 || body["error"] != tt.wantErr {
+					t.Fatalf("expected 400 %s, got %d: %v", tt.wantErr, rec.Code, body)
+				}
+				return
+			}
+
+			if rec.Code != 200 || body["token"] == "" {
+				t.Fatalf("expected a token, got %d: %v", rec.Code, body)
+			}
+		})
+	}
+}
+
+func unixString(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
@@ -1,11 +1,18 @@
 package server
 
 import (
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
 	"github.com/haileyok/cocoon/models"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	sessionTouchInterval = 5 * time.Minute
+	sessionUserAgentMax  = 256
 )
 
 type Session struct {
@@ -13,7 +20,29 @@ type Session struct {
 	RefreshToken string
 }
 
-func (s *Server) createSession(repo *models.Repo) (*Session, error) {
+// what users see about each of their sessions in cocoon.server.listSessions
+type sessionInfo struct {
+	Id        string
+	CreatedAt time.Time
+	UserAgent string
+	Ip        string
+}
+
+func newSessionInfo(e echo.Context) *sessionInfo {
+	ua := e.Request().UserAgent()
+	if len(ua) > sessionUserAgentMax {
+		ua = ua[:sessionUserAgentMax]
+	}
+
+	return &sessionInfo{
+		Id:        uuid.NewString(),
+		CreatedAt: time.Now(),
+		UserAgent: ua,
+		Ip:        e.RealIP(),
+	}
+}
+
+func (s *Server) createSession(repo *models.Repo, info *sessionInfo) (*Session, error) {
 	now := time.Now()
 	accexp := now.Add(3 * time.Hour)
 	refexp := now.Add(7 * 24 * time.Hour)
@@ -53,6 +82,7 @@ func (s *Server) createSession(repo *models.Repo) (*Session, error) {
 		Token:        accessString,
 		Did:          repo.Did,
 		RefreshToken: refreshString,
+		SessionId:    info.Id,
 		CreatedAt:    now,
 		ExpiresAt:    accexp,
 	}).Error; err != nil {
@@ -60,10 +90,15 @@ func (s *Server) createSession(repo *models.Repo) (*Session, error) {
 	}
 
 	if err := s.db.Create(&models.RefreshToken{
-		Token:     refreshString,
-		Did:       repo.Did,
-		CreatedAt: now,
-		ExpiresAt: refexp,
+		Token:            refreshString,
+		Did:              repo.Did,
+		SessionId:        info.Id,
+		SessionCreatedAt: info.CreatedAt,
+		LastUsedAt:       now,
+		UserAgent:        info.UserAgent,
+		Ip:               info.Ip,
+		CreatedAt:        now,
+		ExpiresAt:        refexp,
 	}).Error; err != nil {
 		return nil, err
 	}
@@ -73,3 +108,71 @@ func (s *Server) createSession(repo *models.Repo) (*Session, error) {
 		RefreshToken: refreshString,
 	}, nil
 }
+
+// when each session's last used time was last written. entries are only needed for sessionTouchInterval, so
+// older ones are pruned
+type sessionTouches struct {
+	lk        sync.Mutex
+	touched   map[string]time.Time
+	lastPrune time.Time
+}
+
+// returns true if the session's last used time is due to be written, and notes that it's being written now
+func (t *sessionTouches) due(sessionId string, now time.Time) bool {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	if t.touched == nil {
+		t.touched = map[string]time.Time{}
+	}
+
+	if now.Sub(t.lastPrune) > sessionTouchInterval {
+		for k, last := range t.touched {
+			if now.Sub(last) >= sessionTouchInterval {
+				delete(t.touched, k)
+			}
+		}
+		t.lastPrune = now
+	}
+
+	if last, ok := t.touched[sessionId]; ok && now.Sub(last) < sessionTouchInterval {
+		return false
+	}
+	t.touched[sessionId] = now
+
+	return true
+}
+
+func (t *sessionTouches) forget(sessionId string) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	delete(t.touched, sessionId)
+}
+
+// last used times only get written every few minutes, rather than on every request
+func (s *Server) touchSession(sessionId string) {
+	now := time.Now()
+	if !s.sessionTouches.due(sessionId, now) {
+		return
+	}
+
+	if err := s.db.Exec("UPDATE refresh_tokens SET last_used_at = ? WHERE session_id = ?", now, sessionId).Error; err != nil {
+		s.logger.Error("error updating session last used time", "error", err)
+	}
+}
+
+func (s *Server) revokeSessions(did string) error {
+	if err := s.db.Exec("DELETE FROM tokens WHERE did = ?", did).Error; err != nil {
+		return err
+	}
+
+	if err := s.db.Exec("DELETE FROM refresh_tokens WHERE did = ?", did).Error; err != nil {
+		return err
+	}
+
+	if err := s.db.Exec("DELETE FROM oauth_tokens WHERE did = ?", did).Error; err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/haileyok/cocoon/models"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"gorm.io/gorm"
+)
+
+const (
+	totpPeriod            = 30
+	totpSkew              = 1
+	totpRecoveryCodeCount = 10
+)
+
+var totpOpts = totp.ValidateOpts{
+	Period:    totpPeriod,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+// totp secrets are encrypted with a key derived from the server's jwk, so a copy of the database alone isn't
+// enough to generate codes. this does mean that rotating the jwk turns everyone's authenticator off
+func (s *Server) totpCipher() (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, s.privateKey.D.Bytes(), nil, "cocoon totp secrets", 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// the did is used as additional data so a secret can't be copied over to another account's row
+func (s *Server) encryptTotpSecret(did, secret string) ([]byte, error) {
+	aead, err := s.totpCipher()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, []byte(secret), []byte(did)), nil
+}
+
+func (s *Server) decryptTotpSecret(did string, box []byte) (string, error) {
+	aead, err := s.totpCipher()
+	if err != nil {
+		return "", err
+	}
+
+	if len(box) < aead.NonceSize() {
+		return "", fmt.Errorf("totp secret is too short")
+	}
+
+	secret, err := aead.Open(nil, box[:aead.NonceSize()], box[aead.NonceSize():], []byte(did))
+	if err != nil {
+		return "", err
+	}
+
+	return string(secret), nil
+}
+
+// returns false for wrong codes, and for codes from a time step that has already been used
+func (s *Server) checkTotpCode(urepo *models.RepoActor, code string) (bool, error) {
+	if len(urepo.TotpSecret) == 0 {
+		return false, nil
+	}
+
+	secret, err := s.decryptTotpSecret(urepo.Repo.Did, urepo.TotpSecret)
+	if err != nil {
+		return false, err
+	}
+
+	code = strings.TrimSpace(code)
+	step := time.Now().Unix() / totpPeriod
+
+	for i := -totpSkew; i <= totpSkew; i++ {
+		st := step + int64(i)
+		if st <= urepo.TotpLastStep {
+			continue
+		}
+
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(st*totpPeriod, 0), totpOpts)
+		if err != nil {
+			return false, err
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) != 1 {
+			continue
+		}
+
+		// only move forward, so two requests racing with the same code can't both get through
+		res := s.db.Exec("UPDATE repos SET totp_last_step = ? WHERE did = ? AND totp_last_step < ?", st, urepo.Repo.Did, st)
+		if res.Error != nil {
+			return false, res.Error
+		}
+
+		return res.RowsAffected == 1, nil
+	}
+
+	return false, nil
+}
+
+func hashTotpRecoveryCode(code string) string {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// replaces any recovery codes the account already has
+func (s *Server) createTotpRecoveryCodes(did string) ([]string, error) {
+	now := time.Now()
+	codes := make([]string, 0, totpRecoveryCodeCount)
+	rows := make([]models.TotpRecoveryCode, 0, totpRecoveryCodeCount)
+
+	for range totpRecoveryCodeCount {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+
+		raw := base32.StdEncoding.EncodeToString(b)
+		code := fmt.Sprintf("%s-%s-%s-%s", raw[0:4], raw[4:8], raw[8:12], raw[12:16])
+
+		codes = append(codes, code)
+		rows = append(rows, models.TotpRecoveryCode{
+			Did:       did,
+			CodeHash:  hashTotpRecoveryCode(code),
+			CreatedAt: now,
+		})
+	}
+
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM totp_recovery_codes WHERE did = ?", did).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&rows).Error
+	}); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func (s *Server) useTotpRecoveryCode(did, code string) (bool, error) {
+	res := s.db.Exec("DELETE FROM totp_recovery_codes WHERE did = ? AND code_hash = ?", did, hashTotpRecoveryCode(code))
+	if res.Error != nil {
+		return false, res.Error
+	}
+
+	return res.RowsAffected > 0, nil
+}
+
+// anywhere a totp code is accepted, one of the recovery codes works too
+func (s *Server) checkTotpOrRecoveryCode(urepo *models.RepoActor, code string) (bool, error) {
+	ok, err := s.checkTotpCode(urepo, code)
+	if err != nil || ok {
+		return ok, err
+	}
+
+	return s.useTotpRecoveryCode(urepo.Repo.Did, code)
+}
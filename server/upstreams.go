@@ -0,0 +1,272 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/haileyok/cocoon/models"
+)
+
+const (
+	upstreamHealthCheckInterval = 15 * time.Second
+	upstreamHealthCheckTimeout  = 5 * time.Second
+	upstreamFailureThreshold    = 5
+	upstreamCircuitCooldown     = 30 * time.Second
+)
+
+type upstream struct {
+	id       uint
+	service  string
+	url      *url.URL
+	priority int
+
+	lk       sync.Mutex
+	healthy  bool
+	failures int
+	// set when the circuit opens, and only cleared by a request that works. once the cooldown is over the
+	// circuit is half open: requests go through again, but the first one that fails opens it straight away
+	circuitOpenUntil time.Time
+}
+
+// an upstream is skipped while its last health check failed, or while its circuit is open after too
+// many failed requests in a row
+func (u *upstream) available() bool {
+	u.lk.Lock()
+	defer u.lk.Unlock()
+	return u.healthy && time.Now().After(u.circuitOpenUntil)
+}
+
+func (u *upstream) recordResult(ok bool) {
+	u.lk.Lock()
+	defer u.lk.Unlock()
+
+	if ok {
+		u.failures = 0
+		if !u.circuitOpenUntil.IsZero() {
+			u.circuitOpenUntil = time.Time{}
+			proxyUpstreamCircuitOpen.WithLabelValues(u.service, u.url.String()).Set(0)
+		}
+		return
+	}
+
+	u.failures++
+	halfOpen := !u.circuitOpenUntil.IsZero() && time.Now().After(u.circuitOpenUntil)
+	if halfOpen || u.failures >= upstreamFailureThreshold {
+		u.circuitOpenUntil = time.Now().Add(upstreamCircuitCooldown)
+		u.failures = 0
+		proxyUpstreamCircuitOpen.WithLabelValues(u.service, u.url.String()).Set(1)
+	}
+}
+
+// health checks don't close the circuit, since an upstream can answer _health while its real requests
+// keep failing. they do clear the circuit gauge once the cooldown is over
+func (u *upstream) setHealthy(healthy bool) {
+	u.lk.Lock()
+	defer u.lk.Unlock()
+
+	u.healthy = healthy
+	if healthy {
+		proxyUpstreamHealthy.WithLabelValues(u.service, u.url.String()).Set(1)
+	} else {
+		proxyUpstreamHealthy.WithLabelValues(u.service, u.url.String()).Set(0)
+	}
+
+	if !u.circuitOpenUntil.IsZero() && time.Now().After(u.circuitOpenUntil) {
+		proxyUpstreamCircuitOpen.WithLabelValues(u.service, u.url.String()).Set(0)
+	}
+}
+
+// drops the upstream's metrics once it's been removed from the pool
+func (u *upstream) deleteMetrics() {
+	proxyUpstreamHealthy.DeleteLabelValues(u.service, u.url.String())
+	proxyUpstreamCircuitOpen.DeleteLabelValues(u.service, u.url.String())
+}
+
+type UpstreamStatus struct {
+	Healthy     bool
+	CircuitOpen bool
+	Failures    int
+}
+
+func (u *upstream) status() UpstreamStatus {
+	u.lk.Lock()
+	defer u.lk.Unlock()
+	return UpstreamStatus{
+		Healthy:     u.healthy,
+		CircuitOpen: time.Now().Before(u.circuitOpenUntil),
+		Failures:    u.failures,
+	}
+}
+
+// the upstreams that proxied requests for a service (i.e. did:web:api.bsky.app#bsky_appview) can be sent
+// to instead of the endpoint in the service's did document. these live in the db so that traffic can
+// be shifted between them without a restart
+type UpstreamPool struct {
+	s         *Server
+	h         *http.Client
+	lk        sync.RWMutex
+	upstreams map[string][]*upstream
+}
+
+func NewUpstreamPool(s *Server) *UpstreamPool {
+	return &UpstreamPool{
+		s: s,
+		h: &http.Client{
+			Timeout: upstreamHealthCheckTimeout,
+		},
+		upstreams: map[string][]*upstream{},
+	}
+}
+
+// reads the upstreams from the db again. upstreams that are still there keep their health state
+func (up *UpstreamPool) Load() error {
+	var rows []models.ProxyUpstream
+	if err := up.s.db.Raw("SELECT * FROM proxy_upstreams WHERE disabled = ?", false).Scan(&rows).Error; err != nil {
+		return err
+	}
+
+	up.lk.Lock()
+	defer up.lk.Unlock()
+
+	existing := map[uint]*upstream{}
+	for _, us := range up.upstreams {
+		for _, u := range us {
+			existing[u.id] = u
+		}
+	}
+
+	upstreams := map[string][]*upstream{}
+	for _, row := range rows {
+		parsed, err := url.Parse(strings.TrimSuffix(row.Url, "/"))
+		if err != nil {
+			up.s.logger.Error("skipping invalid proxy upstream", "id", row.ID, "url", row.Url, "error", err)
+			continue
+		}
+
+		u, ok := existing[row.ID]
+		if !ok || u.url.String() != parsed.String() || u.service != row.Service {
+			u = &upstream{
+				id:      row.ID,
+				service: row.Service,
+				url:     parsed,
+				healthy: true,
+			}
+		}
+		u.priority = row.Priority
+
+		upstreams[row.Service] = append(upstreams[row.Service], u)
+	}
+
+	for _, us := range upstreams {
+		sort.SliceStable(us, func(i, j int) bool {
+			return us[i].priority < us[j].priority
+		})
+	}
+
+	kept := map[*upstream]bool{}
+	for _, us := range upstreams {
+		for _, u := range us {
+			kept[u] = true
+		}
+	}
+
+	for _, u := range existing {
+		if !kept[u] {
+			u.deleteMetrics()
+		}
+	}
+
+	up.upstreams = upstreams
+
+	return nil
+}
+
+// returns the upstreams to try for a service, in order. available ones come first by priority, and the
+// rest after them, since trying an unhealthy upstream beats failing outright
+func (up *UpstreamPool) Candidates(service string) []*upstream {
+	up.lk.RLock()
+	defer up.lk.RUnlock()
+
+	var available, unavailable []*upstream
+	for _, u := range up.upstreams[service] {
+		if u.available() {
+			available = append(available, u)
+		} else {
+			unavailable = append(unavailable, u)
+		}
+	}
+
+	return append(available, unavailable...)
+}
+
+func (up *UpstreamPool) Status(id uint) (UpstreamStatus, bool) {
+	up.lk.RLock()
+	defer up.lk.RUnlock()
+
+	for _, us := range up.upstreams {
+		for _, u := range us {
+			if u.id == id {
+				return u.status(), true
+			}
+		}
+	}
+
+	return UpstreamStatus{}, false
+}
+
+func (up *UpstreamPool) Run(ctx context.Context) {
+	ticker := time.NewTicker(upstreamHealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		up.checkAll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (up *UpstreamPool) checkAll(ctx context.Context) {
+	up.lk.RLock()
+	var all []*upstream
+	for _, us := range up.upstreams {
+		all = append(all, us...)
+	}
+	up.lk.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, u := range all {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			u.setHealthy(up.check(ctx, u))
+		}()
+	}
+	wg.Wait()
+}
+
+// any response that isn't a server error means the upstream is up, since not every service
+// implements _health
+func (up *UpstreamPool) check(ctx context.Context, u *upstream) bool {
+	req, err := http.NewRequestWithContext(ctx, "GET", u.url.String()+"/xrpc/_health", nil)
+	if err != nil {
+		return false
+	}
+
+	resp, err := up.h.Do(req)
+	if err != nil {
+		up.s.logger.Warn("proxy upstream health check failed", "service", u.service, "url", u.url.String(), "error", err)
+		return false
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode < 500
+}